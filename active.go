@@ -0,0 +1,61 @@
+package main
+
+import (
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ActiveActions gates the state changing actions of active features, e.g.
+// connecting to peers or sending probe payments. In dry-run mode actions
+// are only logged and counted instead of performed.
+type ActiveActions struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	dryRun  bool
+	skipped map[[2]string]uint64
+}
+
+func NewActiveActions(namespace string, dryRun bool) *ActiveActions {
+	return &ActiveActions{
+		dryRun:  dryRun,
+		skipped: map[[2]string]uint64{},
+
+		metrics: map[string]*prometheus.Desc{
+			"active_dry_run":               newGlobalMetric(namespace, "active_dry_run", "Whether active features run in dry-run mode", []string{}),
+			"active_dry_run_actions_total": newGlobalCounter(namespace, "active_dry_run_actions_total", "Number of state changing actions skipped in dry-run mode", []string{"feature", "action"}),
+		},
+	}
+}
+
+func (a *ActiveActions) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(a.metrics, ch)
+}
+
+func (a *ActiveActions) Collect(ch chan<- prometheus.Metric) {
+	a.Lock()
+	defer a.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(a.metrics["active_dry_run"],
+		prometheus.GaugeValue, boolToFloat(a.dryRun))
+	for key, n := range a.skipped {
+		ch <- prometheus.MustNewConstMetric(a.metrics["active_dry_run_actions_total"],
+			prometheus.CounterValue, float64(n), key[0], key[1])
+	}
+}
+
+// allow reports whether feature may perform action, described by what for
+// the log, or only record that it would have in dry-run mode.
+func (a *ActiveActions) allow(feature string, action string, what string) bool {
+	if !a.dryRun {
+		return true
+	}
+
+	a.Lock()
+	defer a.Unlock()
+	a.skipped[[2]string{feature, action}]++
+	log.Printf("dry-run: %s would %s", feature, what)
+	return false
+}
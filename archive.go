@@ -0,0 +1,164 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+var forwardingArchiveHeader = []string{
+	"timestamp", "node",
+	"chan_id_in", "chan_id_out", "peer_alias_in", "peer_alias_out",
+	"amt_in_msat", "amt_out_msat", "fee_msat",
+}
+
+// forwardingArchive appends the forwards added to the forwarding counters
+// to CSV files in dir, one per UTC day the forwards settled on, for long
+// term storage outside of Prometheus. Without -state.path forwards of the
+// lookback are archived again after a restart.
+type forwardingArchive struct {
+	sync.Mutex
+
+	dir string
+
+	// file is open for day until a forward of another day is archived.
+	day  string
+	file *os.File
+	w    *csv.Writer
+}
+
+func newForwardingArchive(dir string) (*forwardingArchive, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &forwardingArchive{dir: dir}, nil
+}
+
+// open switches to the file of day, creating it with a header if needed.
+func (a *forwardingArchive) open(day string) error {
+	if a.day == day {
+		return nil
+	}
+	if err := a.close(); err != nil {
+		return err
+	}
+
+	path := filepath.Join(a.dir, "forwards-"+day+".csv")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	a.day, a.file, a.w = day, f, csv.NewWriter(f)
+	if info.Size() == 0 {
+		return a.w.Write(forwardingArchiveHeader)
+	}
+	return nil
+}
+
+func (a *forwardingArchive) close() error {
+	if a.file == nil {
+		return nil
+	}
+	a.w.Flush()
+	err := a.w.Error()
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	a.day, a.file, a.w = "", nil, nil
+	return err
+}
+
+// write appends the forwards of node, flushing them to disk before it
+// returns so that a checkpoint saved afterwards doesn't skip any.
+func (a *forwardingArchive) write(node string, forwards []*lnrpc.ForwardingEvent) error {
+	a.Lock()
+	defer a.Unlock()
+
+	for _, f := range forwards {
+		ts := time.Unix(0, int64(f.TimestampNs)).UTC()
+		if err := a.open(ts.Format("2006-01-02")); err != nil {
+			return err
+		}
+		if err := a.w.Write([]string{
+			ts.Format(time.RFC3339Nano), node,
+			strconv.FormatUint(f.ChanIdIn, 10), strconv.FormatUint(f.ChanIdOut, 10),
+			f.PeerAliasIn, f.PeerAliasOut,
+			strconv.FormatUint(f.AmtInMsat, 10), strconv.FormatUint(f.AmtOutMsat, 10),
+			strconv.FormatUint(f.FeeMsat, 10),
+		}); err != nil {
+			return err
+		}
+	}
+	if a.w == nil {
+		return nil
+	}
+	a.w.Flush()
+	if err := a.w.Error(); err != nil {
+		return err
+	}
+	return a.file.Sync()
+}
+
+// forwardingTotals are the forwards summed up over a period.
+type forwardingTotals struct {
+	forwards      uint64
+	forwardedMsat uint64
+	feesMsat      uint64
+}
+
+// sum adds up the archived forwards of node within [start, end).
+func (a *forwardingArchive) sum(node string, start time.Time, end time.Time) (forwardingTotals, error) {
+	a.Lock()
+	defer a.Unlock()
+
+	// Rows written twice, e.g. by an exporter restarted without
+	// -state.path, are the same forward and counted once.
+	seen := map[string]bool{}
+	var totals forwardingTotals
+	for day := start.UTC().Truncate(24 * time.Hour); day.Before(end); day = day.Add(24 * time.Hour) {
+		f, err := os.Open(filepath.Join(a.dir, "forwards-"+day.Format("2006-01-02")+".csv"))
+		if os.IsNotExist(err) {
+			continue
+		} else if err != nil {
+			return totals, err
+		}
+		rows, err := csv.NewReader(f).ReadAll()
+		f.Close()
+		if err != nil {
+			return totals, err
+		}
+
+		for _, row := range rows {
+			if len(row) != len(forwardingArchiveHeader) || row[0] == forwardingArchiveHeader[0] || row[1] != node {
+				continue
+			}
+			ts, err := time.Parse(time.RFC3339Nano, row[0])
+			if err != nil || ts.Before(start) || !ts.Before(end) {
+				continue
+			}
+			key := strings.Join(row, ",")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			outMsat, _ := strconv.ParseUint(row[7], 10, 64)
+			feeMsat, _ := strconv.ParseUint(row[8], 10, 64)
+			totals.forwards++
+			totals.forwardedMsat += outMsat
+			totals.feesMsat += feeMsat
+		}
+	}
+	return totals, nil
+}
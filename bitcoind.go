@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// BitcoindExporter exports health metrics of the bitcoind backend lnd is
+// connected to, so a lagging backend can be told apart from a broken lnd.
+type BitcoindExporter struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	rpcAddr string
+	rpcUser string
+	rpcPass string
+
+	timeout    time.Duration
+	httpClient *http.Client
+}
+
+func NewBitcoindExporter(namespace string, rpcAddr string, rpcUser string, rpcPass string, timeout time.Duration) *BitcoindExporter {
+	if !strings.HasPrefix(rpcAddr, "http://") && !strings.HasPrefix(rpcAddr, "https://") {
+		rpcAddr = "http://" + rpcAddr
+	}
+
+	return &BitcoindExporter{
+		rpcAddr: rpcAddr,
+		rpcUser: rpcUser,
+		rpcPass: rpcPass,
+		timeout: timeout,
+
+		httpClient: &http.Client{Timeout: timeout},
+
+		metrics: map[string]*prometheus.Desc{
+			"bitcoind_up":                      newGlobalMetric(namespace, "bitcoind_up", "Whether the bitcoind RPC could be reached.", []string{}),
+			"bitcoind_block_height":            newGlobalMetric(namespace, "bitcoind_block_height", "The height of the most-work fully-validated chain.", []string{}),
+			"bitcoind_headers":                 newGlobalMetric(namespace, "bitcoind_headers", "The current number of headers validated.", []string{}),
+			"bitcoind_verification_progress":   newGlobalMetric(namespace, "bitcoind_verification_progress", "Estimate of chain verification progress [0..1].", []string{}),
+			"bitcoind_initial_block_download":  newGlobalMetric(namespace, "bitcoind_initial_block_download", "Whether bitcoind is in initial block download mode.", []string{}),
+			"bitcoind_mempool_transactions":    newGlobalMetric(namespace, "bitcoind_mempool_transactions", "Number of transactions in the mempool.", []string{}),
+			"bitcoind_mempool_bytes":           newGlobalMetric(namespace, "bitcoind_mempool_bytes", "Sum of all virtual transaction sizes in the mempool.", []string{}),
+			"bitcoind_mempool_min_fee_btc_kvb": newGlobalMetric(namespace, "bitcoind_mempool_min_fee_btc_kvb", "Minimum fee rate in BTC/kvB for a transaction to be accepted.", []string{}),
+			"bitcoind_peers":                   newGlobalMetric(namespace, "bitcoind_peers", "Number of connections to other nodes.", []string{}),
+		},
+	}
+}
+
+func (c *BitcoindExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+type bitcoindRequest struct {
+	JsonRpc string        `json:"jsonrpc"`
+	Id      string        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type bitcoindResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type bitcoindBlockchainInfo struct {
+	Blocks               int64   `json:"blocks"`
+	Headers              int64   `json:"headers"`
+	VerificationProgress float64 `json:"verificationprogress"`
+	InitialBlockDownload bool    `json:"initialblockdownload"`
+}
+
+type bitcoindMempoolInfo struct {
+	Size       int64   `json:"size"`
+	Bytes      int64   `json:"bytes"`
+	MempoolMin float64 `json:"mempoolminfee"`
+}
+
+func (c *BitcoindExporter) call(ctx context.Context, method string, result interface{}) error {
+	body, err := json.Marshal(&bitcoindRequest{
+		JsonRpc: "1.0",
+		Id:      "lnd-exporter",
+		Method:  method,
+		Params:  []interface{}{},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.rpcAddr, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.rpcUser != "" {
+		req.SetBasicAuth(c.rpcUser, c.rpcPass)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return errors.New("bitcoind rpc: unauthorized")
+	}
+
+	rpcResp := &bitcoindResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(rpcResp); err != nil {
+		return fmt.Errorf("bitcoind rpc %s: %s (http status %d)", method, err, resp.StatusCode)
+	}
+	if rpcResp.Error != nil {
+		return fmt.Errorf("bitcoind rpc %s: %s (code %d)", method, rpcResp.Error.Message, rpcResp.Error.Code)
+	}
+
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+func (c *BitcoindExporter) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	chainInfo := &bitcoindBlockchainInfo{}
+	if err := c.call(ctx, "getblockchaininfo", chainInfo); err != nil {
+		log.Printf("bitcoind getblockchaininfo err: %s", err)
+		ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_up"], prometheus.GaugeValue, 0.0)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_block_height"],
+		prometheus.GaugeValue, float64(chainInfo.Blocks))
+	ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_headers"],
+		prometheus.GaugeValue, float64(chainInfo.Headers))
+	ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_verification_progress"],
+		prometheus.GaugeValue, chainInfo.VerificationProgress)
+	ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_initial_block_download"],
+		prometheus.GaugeValue, boolToFloat(chainInfo.InitialBlockDownload))
+
+	mempoolInfo := &bitcoindMempoolInfo{}
+	if err := c.call(ctx, "getmempoolinfo", mempoolInfo); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_mempool_transactions"],
+			prometheus.GaugeValue, float64(mempoolInfo.Size))
+		ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_mempool_bytes"],
+			prometheus.GaugeValue, float64(mempoolInfo.Bytes))
+		ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_mempool_min_fee_btc_kvb"],
+			prometheus.GaugeValue, mempoolInfo.MempoolMin)
+	} else {
+		log.Printf("bitcoind getmempoolinfo err: %s", err)
+	}
+
+	var connections int64
+	if err := c.call(ctx, "getconnectioncount", &connections); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_peers"],
+			prometheus.GaugeValue, float64(connections))
+	} else {
+		log.Printf("bitcoind getconnectioncount err: %s", err)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["bitcoind_up"], prometheus.GaugeValue, 1.0)
+}
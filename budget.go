@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var errBudgetExhausted = errors.New("daily probe budget exhausted")
+
+const probeBudgetStateKey = "probe_budget"
+
+// probeBudgetUsage is the usage of the budget persisted in the state store.
+type probeBudgetUsage struct {
+	Day        time.Time `json:"day"`
+	AmountMsat int64     `json:"amount_msat"`
+	FeeMsat    int64     `json:"fee_msat"`
+}
+
+// ProbeBudget limits the amount and fees all active probes together may
+// send per UTC day. Probes use payment hashes the destination can't settle,
+// so the budget bounds what is at risk should one settle anyway. The usage
+// is persisted before a probe is sent so that a restart doesn't reset it,
+// which needs -state.path.
+type ProbeBudget struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	// Limits in msat, 0 means unlimited.
+	amountLimitMsat int64
+	feeLimitMsat    int64
+
+	day            time.Time
+	amountUsedMsat int64
+	feeUsedMsat    int64
+	denied         map[string]uint64
+
+	state *stateStore
+}
+
+func NewProbeBudget(namespace string, amountLimitSat int64, feeLimitSat int64, state *stateStore) *ProbeBudget {
+	b := &ProbeBudget{
+		amountLimitMsat: amountLimitSat * 1000,
+		feeLimitMsat:    feeLimitSat * 1000,
+		denied:          map[string]uint64{},
+		state:           state,
+
+		metrics: map[string]*prometheus.Desc{
+			"probe_budget_limit_msat": newGlobalMetric(namespace, "probe_budget_limit_msat", "Daily limit of the amount or fees sent by probes, 0 if unlimited", []string{"resource"}),
+			"probe_budget_used_msat":  newGlobalMetric(namespace, "probe_budget_used_msat", "Amount or fees sent by probes in the current UTC day", []string{"resource"}),
+			"probe_budget_denied":     newGlobalCounter(namespace, "probe_budget_denied_total", "Number of probes skipped because they would exceed the daily budget", []string{"probe"}),
+		},
+	}
+
+	var usage probeBudgetUsage
+	if _, err := state.get(probeBudgetStateKey, &usage); err != nil {
+		log.Printf("invalid persisted probe budget usage, ignoring it: %s", err)
+	} else {
+		b.day, b.amountUsedMsat, b.feeUsedMsat = usage.Day, usage.AmountMsat, usage.FeeMsat
+	}
+	return b
+}
+
+func (b *ProbeBudget) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(b.metrics, ch)
+}
+
+func (b *ProbeBudget) Collect(ch chan<- prometheus.Metric) {
+	b.Lock()
+	defer b.Unlock()
+	b.resetDaily(time.Now())
+
+	ch <- prometheus.MustNewConstMetric(b.metrics["probe_budget_limit_msat"],
+		prometheus.GaugeValue, float64(b.amountLimitMsat), "amount")
+	ch <- prometheus.MustNewConstMetric(b.metrics["probe_budget_limit_msat"],
+		prometheus.GaugeValue, float64(b.feeLimitMsat), "fee")
+	ch <- prometheus.MustNewConstMetric(b.metrics["probe_budget_used_msat"],
+		prometheus.GaugeValue, float64(b.amountUsedMsat), "amount")
+	ch <- prometheus.MustNewConstMetric(b.metrics["probe_budget_used_msat"],
+		prometheus.GaugeValue, float64(b.feeUsedMsat), "fee")
+	for probe, n := range b.denied {
+		ch <- prometheus.MustNewConstMetric(b.metrics["probe_budget_denied"],
+			prometheus.CounterValue, float64(n), probe)
+	}
+}
+
+// spend books a probe sending amountMsat with feeMsat fees against the
+// budget, or returns errBudgetExhausted if that would exceed it.
+func (b *ProbeBudget) spend(probe string, amountMsat int64, feeMsat int64) error {
+	b.Lock()
+	defer b.Unlock()
+	b.resetDaily(time.Now())
+
+	if (b.amountLimitMsat > 0 && b.amountUsedMsat+amountMsat > b.amountLimitMsat) ||
+		(b.feeLimitMsat > 0 && b.feeUsedMsat+feeMsat > b.feeLimitMsat) {
+		b.denied[probe]++
+		return errBudgetExhausted
+	}
+
+	// The probe is only sent once its usage is persisted.
+	if err := b.state.put(probeBudgetStateKey, probeBudgetUsage{
+		Day:        b.day,
+		AmountMsat: b.amountUsedMsat + amountMsat,
+		FeeMsat:    b.feeUsedMsat + feeMsat,
+	}); err != nil {
+		return fmt.Errorf("saving probe budget usage: %w", err)
+	}
+
+	b.amountUsedMsat += amountMsat
+	b.feeUsedMsat += feeMsat
+	return nil
+}
+
+func (b *ProbeBudget) resetDaily(now time.Time) {
+	day := now.UTC().Truncate(24 * time.Hour)
+	if !day.Equal(b.day) {
+		b.day = day
+		b.amountUsedMsat = 0
+		b.feeUsedMsat = 0
+	}
+}
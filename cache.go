@@ -0,0 +1,181 @@
+package main
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// cacheMaxEntries bounds the number of entries of each internal cache, the
+// least recently used entries are evicted beyond it. Zero is unbounded.
+var cacheMaxEntries = 10000
+
+// lruCache is a map bounded to cacheMaxEntries entries that evicts the
+// least recently used entry when full. It is safe for concurrent use.
+type lruCache[K comparable, V any] struct {
+	sync.Mutex
+
+	maxEntries int
+	entries    map[K]*list.Element
+	order      *list.List
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+type lruEntry[K comparable, V any] struct {
+	key   K
+	value V
+}
+
+// newLruCache returns an empty cache that is exported by the CacheExporter
+// as name of the node at rpcAddr. Only the first cache of a name and node
+// is exported, the one of the exporter on /metrics rather than of a probe
+// of the same node.
+func newLruCache[K comparable, V any](name string, rpcAddr string) *lruCache[K, V] {
+	c := &lruCache[K, V]{
+		maxEntries: cacheMaxEntries,
+		entries:    map[K]*list.Element{},
+		order:      list.New(),
+	}
+
+	caches.Lock()
+	defer caches.Unlock()
+	key := cacheKey{name: name, rpcAddr: rpcAddr}
+	if _, ok := caches.m[key]; !ok {
+		caches.m[key] = c
+	}
+	return c
+}
+
+// get returns the value of key and marks it as recently used.
+func (c *lruCache[K, V]) get(key K) (V, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		var zero V
+		return zero, false
+	}
+	c.hits++
+	c.order.MoveToFront(e)
+	return e.Value.(*lruEntry[K, V]).value, true
+}
+
+// put sets the value of key, evicting the least recently used entry if the
+// cache is full.
+func (c *lruCache[K, V]) put(key K, value V) {
+	c.Lock()
+	defer c.Unlock()
+
+	if e, ok := c.entries[key]; ok {
+		e.Value.(*lruEntry[K, V]).value = value
+		c.order.MoveToFront(e)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&lruEntry[K, V]{key: key, value: value})
+	for c.maxEntries > 0 && c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*lruEntry[K, V]).key)
+		c.evictions++
+	}
+}
+
+// each calls f for all entries without marking them as used.
+func (c *lruCache[K, V]) each(f func(key K, value V)) {
+	c.Lock()
+	defer c.Unlock()
+
+	for e := c.order.Front(); e != nil; e = e.Next() {
+		entry := e.Value.(*lruEntry[K, V])
+		f(entry.key, entry.value)
+	}
+}
+
+// items returns a copy of all entries as a map.
+func (c *lruCache[K, V]) items() map[K]V {
+	m := map[K]V{}
+	c.each(func(key K, value V) { m[key] = value })
+	return m
+}
+
+func (c *lruCache[K, V]) stats() cacheStats {
+	c.Lock()
+	defer c.Unlock()
+
+	return cacheStats{
+		entries:    c.order.Len(),
+		maxEntries: c.maxEntries,
+		hits:       c.hits,
+		misses:     c.misses,
+		evictions:  c.evictions,
+	}
+}
+
+type cacheStats struct {
+	entries    int
+	maxEntries int
+	hits       uint64
+	misses     uint64
+	evictions  uint64
+}
+
+type cacheKey struct {
+	name    string
+	rpcAddr string
+}
+
+// caches holds all caches created with newLruCache.
+var caches = struct {
+	sync.Mutex
+	m map[cacheKey]interface{ stats() cacheStats }
+}{m: map[cacheKey]interface{ stats() cacheStats }{}}
+
+// CacheExporter exports the size and efficiency of all internal caches.
+type CacheExporter struct {
+	metrics map[string]*prometheus.Desc
+}
+
+func NewCacheExporter(namespace string) *CacheExporter {
+	labels := []string{"cache", "rpc_addr"}
+	return &CacheExporter{
+		metrics: map[string]*prometheus.Desc{
+			"cache_entries":         newGlobalMetric(namespace, "cache_entries", "Number of entries in the internal cache", labels),
+			"cache_max_entries":     newGlobalMetric(namespace, "cache_max_entries", "Maximum number of entries of the internal cache, 0 is unbounded", labels),
+			"cache_hits_total":      newGlobalCounter(namespace, "cache_hits_total", "Number of lookups that found an entry in the internal cache", labels),
+			"cache_misses_total":    newGlobalCounter(namespace, "cache_misses_total", "Number of lookups that found no entry in the internal cache", labels),
+			"cache_evictions_total": newGlobalCounter(namespace, "cache_evictions_total", "Number of least recently used entries evicted from the full internal cache", labels),
+		},
+	}
+}
+
+func (c *CacheExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *CacheExporter) Collect(ch chan<- prometheus.Metric) {
+	caches.Lock()
+	defer caches.Unlock()
+
+	for key, cache := range caches.m {
+		s := cache.stats()
+		ch <- prometheus.MustNewConstMetric(c.metrics["cache_entries"],
+			prometheus.GaugeValue, float64(s.entries), key.name, key.rpcAddr)
+		ch <- prometheus.MustNewConstMetric(c.metrics["cache_max_entries"],
+			prometheus.GaugeValue, float64(s.maxEntries), key.name, key.rpcAddr)
+		ch <- prometheus.MustNewConstMetric(c.metrics["cache_hits_total"],
+			prometheus.CounterValue, float64(s.hits), key.name, key.rpcAddr)
+		ch <- prometheus.MustNewConstMetric(c.metrics["cache_misses_total"],
+			prometheus.CounterValue, float64(s.misses), key.name, key.rpcAddr)
+		ch <- prometheus.MustNewConstMetric(c.metrics["cache_evictions_total"],
+			prometheus.CounterValue, float64(s.evictions), key.name, key.rpcAddr)
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// wumboThreshold is the largest channel capacity in satoshis allowed
+// without the wumbo feature bit, the default of -channels.size-thresholds.
+const wumboThreshold = 1<<24 - 1
+
+// parsePositiveInts parses a comma separated list of positive integers
+// like "1,5,10" and returns them sorted.
+func parsePositiveInts(s string) ([]int64, error) {
+	var res []int64
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid value %q, expected a positive integer", v)
+		}
+		res = append(res, n)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return res, nil
+}
+
+// collectSizeExposure exports how many channels and how much capacity is
+// in channels above each size threshold, and the share of the total
+// capacity in the largest channels, as a measure of concentration risk.
+func (c *channelsCollector) collectSizeExposure(ch chan<- prometheus.Metric, channels []*lnrpc.Channel) {
+	capacities := make([]int64, 0, len(channels))
+	var total int64
+	for _, channel := range channels {
+		capacities = append(capacities, channel.Capacity)
+		total += channel.Capacity
+	}
+	sort.Slice(capacities, func(i, j int) bool { return capacities[i] > capacities[j] })
+
+	for _, threshold := range c.sizeThresholds {
+		var n int
+		var capacity int64
+		for _, size := range capacities {
+			if size <= threshold {
+				break
+			}
+			n++
+			capacity += size
+		}
+		label := strconv.FormatInt(threshold, 10)
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_above_size"],
+			prometheus.GaugeValue, float64(n), label)
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_above_size_capacity"],
+			prometheus.GaugeValue, float64(capacity), label)
+	}
+
+	if total == 0 {
+		return
+	}
+	for _, top := range c.concentrationTop {
+		var capacity int64
+		for i := 0; i < len(capacities) && int64(i) < top; i++ {
+			capacity += capacities[i]
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_capacity_top_share"],
+			prometheus.GaugeValue, float64(capacity)/float64(total), strconv.FormatInt(top, 10))
+	}
+}
+
+// collectPeerConcentration exports the share of the total capacity held
+// with each of the peerShareTop largest peers, and the Herfindahl index of
+// the capacity by peer, the sum of the squared shares. The index is 1 with
+// a single peer and 1/n with the capacity evenly spread over n peers.
+func (c *channelsCollector) collectPeerConcentration(ch chan<- prometheus.Metric, channels []*lnrpc.Channel) {
+	byPeer := map[string]int64{}
+	var total int64
+	for _, channel := range channels {
+		byPeer[channel.RemotePubkey] += channel.Capacity
+		total += channel.Capacity
+	}
+	if total == 0 {
+		return
+	}
+
+	peers := make([]string, 0, len(byPeer))
+	var index float64
+	for pubkey, capacity := range byPeer {
+		peers = append(peers, pubkey)
+		share := float64(capacity) / float64(total)
+		index += share * share
+	}
+	sort.Slice(peers, func(i, j int) bool {
+		if byPeer[peers[i]] != byPeer[peers[j]] {
+			return byPeer[peers[i]] > byPeer[peers[j]]
+		}
+		return peers[i] < peers[j]
+	})
+
+	for i, pubkey := range peers {
+		if i >= c.peerShareTop {
+			break
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_capacity_share"],
+			prometheus.GaugeValue, float64(byPeer[pubkey])/float64(total), pubkey, c.peerTags[pubkey])
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_capacity_concentration"],
+		prometheus.GaugeValue, index)
+}
@@ -2,13 +2,18 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"os"
-	"strconv"
+	"runtime/debug"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/watchtowerrpc"
 	"github.com/lightningnetwork/lnd/macaroons"
 	"github.com/prometheus/client_golang/prometheus"
 	"google.golang.org/grpc"
@@ -16,6 +21,82 @@ import (
 	"gopkg.in/macaroon.v2"
 )
 
+// lndCollectors lists the sections of the lnd metrics, in the order they
+// are collected. Each can be enabled or disabled with -collector.<name>.
+// Low priority collectors run last and are shed in order when the scrape
+// deadline is close.
+var lndCollectors = []struct {
+	name        string
+	help        string
+	lowPriority bool
+
+	// disabledByDefault collectors need an RPC subserver or permission
+	// not every setup has.
+	disabledByDefault bool
+}{
+	{name: "info", help: "node info, chain sync state and channel counts"},
+	{name: "wallet", help: "on-chain wallet balance, UTXOs and fees paid"},
+	{name: "wallet_accounts", help: "on-chain wallet balance per account and address type, needs the walletrpc subserver", disabledByDefault: true},
+	{name: "fee_estimates", help: "on-chain fee rate estimates for the confirmation targets from -fees.conf-targets, needs the walletrpc subserver", disabledByDefault: true},
+	{name: "sweeps", help: "outputs pending to be swept by the sweeper, e.g. anchors and force close outputs, needs the walletrpc subserver", disabledByDefault: true},
+	{name: "channels", help: "pending and open channels, their balances and fee policies"},
+	{name: "peers", help: "connected peers, the close related features and stability score of channel peers, and peer counts by country and AS with -geoip.*"},
+	{name: "consistency", help: "cross-checks of totals reported by lnd against the listed channels and peers", lowPriority: true},
+	{name: "forwarding", help: "forwarding history", lowPriority: true},
+	{name: "closed_channels", help: "closed channels and their settled balance by close type", lowPriority: true},
+	{name: "invoices", help: "number and amount of invoices by state", lowPriority: true},
+	{name: "payments", help: "number and amount of sent payments", lowPriority: true},
+	{name: "network", help: "network graph size, channel policies and channel peers that stopped updating their policy", lowPriority: true},
+	{name: "mission_control", help: "mission control pair history of payment attempts to our peers, needs the routerrpc subserver", lowPriority: true, disabledByDefault: true},
+	{name: "rebalance", help: "per channel rebalance priority and fee adjustment suggestions for automation", lowPriority: true, disabledByDefault: true},
+	{name: "watchtower", help: "watchtower server state when lnd runs as a tower, needs the watchtowerrpc subserver", disabledByDefault: true},
+	{name: "fields", help: "RPC response fields mapped to metrics with field_metrics in the config file"},
+}
+
+// lndCollector collects one section of the lnd metrics.
+type lndCollector interface {
+	Describe(ch chan<- *prometheus.Desc)
+	Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric)
+}
+
+type enabledCollector struct {
+	name        string
+	lowPriority bool
+	collector   lndCollector
+}
+
+// scrape holds the client and the RPC responses shared by the collectors
+// during one scrape.
+type scrape struct {
+	client lnrpc.LightningClient
+	info   *lnrpc.GetInfoResponse
+
+	// walletKit, router and watchtower are nil in replay mode.
+	walletKit  walletrpc.WalletKitClient
+	router     routerrpc.RouterClient
+	watchtower watchtowerrpc.WatchtowerClient
+
+	// channelsMu guards the ListChannels response, collectors run
+	// concurrently.
+	channelsMu  sync.Mutex
+	channels    []*lnrpc.Channel
+	channelsErr error
+	channelsOk  bool
+}
+
+// listChannels returns the open channels, calling ListChannels only once
+// per scrape.
+func (s *scrape) listChannels(ctx context.Context) ([]*lnrpc.Channel, error) {
+	s.channelsMu.Lock()
+	defer s.channelsMu.Unlock()
+
+	if !s.channelsOk {
+		resp, err := s.client.ListChannels(ctx, &lnrpc.ListChannelsRequest{})
+		s.channels, s.channelsErr, s.channelsOk = resp.GetChannels(), err, true
+	}
+	return s.channels, s.channelsErr
+}
+
 type LndExporter struct {
 	sync.Mutex
 	metrics map[string]*prometheus.Desc
@@ -23,70 +104,230 @@ type LndExporter struct {
 	rpcAddr      string
 	tlsCertPath  string
 	macaroonPath string
+	conn         *lndConn
 
 	timeout time.Duration
 
-	exportPeerMetrics    bool
-	exportPaymentMetrics bool
+	// shedThreshold is the remaining time until the scrape deadline below
+	// which low priority collectors are skipped.
+	shedThreshold time.Duration
+	shedCount     map[string]uint64
+
+	// replayDir is a directory of recorded RPC responses to serve metrics
+	// from instead of the node at rpcAddr.
+	replayDir string
+
+	collectors []enabledCollector
+
+	// concurrency is the number of collectors run at once in a scrape.
+	concurrency int
+
+	// collectorsMu guards disabled and panicCount, which are updated by
+	// the collectors running concurrently.
+	collectorsMu sync.Mutex
+
+	// disabled holds the collectors that stopped running because lnd denied
+	// one of their RPCs, until the exporter is restarted with a macaroon
+	// granting the missing permission.
+	disabled map[string]bool
+
+	// panicCount counts the scrapes each collector panicked in.
+	panicCount map[string]uint64
+
+	// health is the result of the last attempt to reach lnd, for /readyz.
+	health lndHealth
 }
 
+// channelLabels is the label set used by per-channel metrics, tag is the
+// peer's tag from the config file.
+var channelLabels = []string{"chan_id", "chan_point", "remote_pubkey", "tag"}
+
 func newGlobalMetric(namespace string, metricName string, docString string, labels []string) *prometheus.Desc {
-	return prometheus.NewDesc(namespace+"_"+metricName, docString, labels, nil)
+	return newTypedMetric(namespace, metricName, "gauge", docString, labels)
+}
+
+// newGlobalCounter is newGlobalMetric for metrics exported as counters.
+func newGlobalCounter(namespace string, metricName string, docString string, labels []string) *prometheus.Desc {
+	return newTypedMetric(namespace, metricName, "counter", docString, labels)
+}
+
+// newGlobalHistogram is newGlobalMetric for metrics exported as histograms.
+func newGlobalHistogram(namespace string, metricName string, docString string, labels []string) *prometheus.Desc {
+	return newTypedMetric(namespace, metricName, "histogram", docString, labels)
+}
+
+func newTypedMetric(namespace string, metricName string, metricType string, docString string, labels []string) *prometheus.Desc {
+	desc := prometheus.NewDesc(namespace+"_"+metricName, docString, labels, nil)
+	registerMetricMetadata(desc, namespace+"_"+metricName, metricType, docString, labels)
+	return desc
+}
+
+func describeMetrics(metrics map[string]*prometheus.Desc, ch chan<- *prometheus.Desc) {
+	for _, m := range metrics {
+		ch <- m
+	}
+}
+
+// LndExporterOpts configures what and how an LndExporter collects.
+type LndExporterOpts struct {
+	// Timeout is the deadline for all RPCs of a scrape, low priority
+	// collectors are skipped when less than ShedThreshold is left.
+	Timeout       time.Duration
+	ShedThreshold time.Duration
+
+	// Collectors holds whether each of lndCollectors is enabled.
+	Collectors map[string]bool
+
+	// Concurrency is the number of collectors, and so of their RPCs, run
+	// at once in a scrape. 1 runs them one after another.
+	Concurrency int
+
+	DustExposureThreshold int64
+	StaleThreshold        time.Duration
+
+	// ChannelSizeThresholds are the capacities above which channels are
+	// counted, ChannelConcentrationTop the numbers of largest channels
+	// whose share of the total capacity is exported.
+	ChannelSizeThresholds   []int64
+	ChannelConcentrationTop []int64
+
+	// PeerShareTop is the number of largest peers whose share of the
+	// total capacity is exported.
+	PeerShareTop int
+
+	// FeeConfTargets are the confirmation targets of the exported fee
+	// estimates.
+	FeeConfTargets []int64
+
+	// ChannelPairs is the number of busiest channel pairs to export the
+	// forwarded volume of, 0 disables the channel pair metric.
+	ChannelPairs int
+
+	// ForwardingLookback is how far back in the forwarding history the
+	// counters start when there is no checkpoint.
+	ForwardingLookback time.Duration
+
+	// ForwardingArchive gets the forwards counted by the forwarding
+	// collector, nil disables archiving.
+	ForwardingArchive *forwardingArchive
+
+	// FieldMetrics are the RPC response fields exported by the fields
+	// collector.
+	FieldMetrics []FieldMetricConfig
+
+	// PeerTags maps remote pubkeys to the tag label of channel and
+	// forwarding metrics.
+	PeerTags map[string]string
+
+	// PeerScoreWeights weigh the components of the peer stability score,
+	// see peerScore.
+	PeerScoreWeights map[string]float64
+
+	// GeoIP classifies peer addresses for the peers collector, nil
+	// disables it.
+	GeoIP *geoIP
+
+	// RebalanceWeights weigh the components of the rebalance priority,
+	// RebalanceMaxFeeAdjustment is the largest suggested fee rate change
+	// relative to the current fee rate.
+	RebalanceWeights          map[string]float64
+	RebalanceMaxFeeAdjustment float64
+
+	// State persists the position in the forwarding history, channel open
+	// costs and on-chain fees, it is kept in memory only if nil.
+	State *stateStore
 }
 
-func NewLightningExporter(namespace string, rpcAddr string, tlsCertPath string, macaroonPath string, timeout time.Duration, exportPeerMetrics bool) *LndExporter {
-	return &LndExporter{
+func NewLightningExporter(namespace string, rpcAddr string, tlsCertPath string, macaroonPath string, replayDir string, opts LndExporterOpts) *LndExporter {
+	if opts.State == nil {
+		opts.State, _ = newStateStore("")
+	}
+
+	c := &LndExporter{
 		rpcAddr:      rpcAddr,
 		tlsCertPath:  tlsCertPath,
 		macaroonPath: macaroonPath,
-		timeout:      timeout,
+		conn:         newLndConn(rpcAddr, tlsCertPath, macaroonPath),
+		replayDir:    replayDir,
+		timeout:      opts.Timeout,
+		concurrency:  opts.Concurrency,
+
+		shedThreshold: opts.ShedThreshold,
+		shedCount:     map[string]uint64{},
+		disabled:      map[string]bool{},
+		panicCount:    map[string]uint64{},
 
 		metrics: map[string]*prometheus.Desc{
-			"lnd_up": newGlobalMetric(namespace, "lnd_up", "up", []string{}),
-
-			"forwarding_history_info": newGlobalMetric(namespace, "forwarding_history_info", "forwarding_history_info",
-				[]string{
-					"peer_alias_in",
-					"peer_alias_out",
-					"amount_in",
-					"amount_out",
-					"fee",
-					"channel_id_in",
-					"channel_i_out",
-					"timestamp_ns",
-				}),
-
-			"network_capacity_satoshis_total": newGlobalMetric(namespace, "network_capacity_satoshis_total", "network_capacity_satoshis_total", []string{}),
-			"network_channels_total":          newGlobalMetric(namespace, "network_channels_total", "network_channels_total", []string{}),
-			"network_nodes_total":             newGlobalMetric(namespace, "network_nodes_total", "network_nodes_total", []string{}),
-
-			"instance_info": newGlobalMetric(namespace, "instance_info", "instance_info", []string{"alias", "pubkey", "version"}),
-
-			"wallet_balance_satoshis":         newGlobalMetric(namespace, "wallet_balance_satoshis", "The wallet balance.", []string{"status"}),
-			"peers":                           newGlobalMetric(namespace, "peers", "Number of currently connected peers.", []string{}),
-			"channels":                        newGlobalMetric(namespace, "channels", "Number of channels", []string{"status"}),
-			"block_height":                    newGlobalMetric(namespace, "block_height", "The node’s current view of the height of the best block", []string{}),
-			"synced_to_chain":                 newGlobalMetric(namespace, "synced_to_chain", "The node’s current view of the height of the best block", []string{}),
-			"channels_limbo_balance_satoshis": newGlobalMetric(namespace, "channel_limbo_balance_satoshis", "The balance in satoshis encumbered in pending channels", []string{}),
-			"channels_pending":                newGlobalMetric(namespace, "channel_pending", "The total pending channels", []string{"status", "forced"}),
-			"channels_waiting_close":          newGlobalMetric(namespace, "channel_waiting_close", "Channels waiting for closing tx to confirm", []string{}),
-			"channels_balance_satoshis":       newGlobalMetric(namespace, "channels_balance_satoshis", "Sum of all channel funds available", []string{}),
-			"channel_balance_satoshis":        newGlobalMetric(namespace, "channel_balance_satoshis", "The channel local balance", []string{"active", "remote_pubkey", "chan_point", "chan_id", "capacity", "commit_fee", "private", "initator"}),
-			"channel_balance_percentage":      newGlobalMetric(namespace, "channel_balance_percentage", "The channel local balance", []string{"active", "remote_pubkey", "chan_point", "chan_id", "capacity", "commit_fee", "private", "initator"}),
-
-			"peer_info":                      newGlobalMetric(namespace, "peer_info", "peer_info", []string{"addr", "remote_pubkey", "direction"}),
-			"peer_info_received_bytes_total": newGlobalMetric(namespace, "peer_info_received_bytes_total", "peer_info_received_bytes_total", []string{"addr"}),
-			"peer_info_sent_bytes_total":     newGlobalMetric(namespace, "peer_info_sent_bytes_total", "peer_info_sent_bytes_total", []string{"addr"}),
+			"lnd_up":       newGlobalMetric(namespace, "lnd_up", "up", []string{}),
+			"no_macaroons": newGlobalMetric(namespace, "no_macaroons", "Whether lnd was detected to run with --no-macaroons, the exporter connects without macaroon", []string{}),
+			"wallet_state": newGlobalMetric(namespace, "wallet_state", "Whether lnd's wallet is in the state, from the State service that answers while the wallet is locked", []string{"state"}),
+
+			"collector_shed":         newGlobalMetric(namespace, "collector_shed", "Whether the low priority collector was skipped in this scrape because the scrape deadline was close", []string{"collector"}),
+			"collector_shed_total":   newGlobalCounter(namespace, "collector_shed_total", "Number of scrapes the low priority collector was skipped in", []string{"collector"}),
+			"scrape_duration":        newGlobalMetric(namespace, "exporter_scrape_duration_seconds", "Time the collector took in this scrape", []string{"subsystem"}),
+			"scrape_success":         newGlobalMetric(namespace, "exporter_scrape_success", "Whether all RPCs of the collector succeeded in this scrape and it didn't panic", []string{"subsystem"}),
+			"collector_failed":       newGlobalMetric(namespace, "exporter_collector_failed", "Whether the collector panicked in this scrape, its metrics are incomplete", []string{"collector"}),
+			"collector_panics_total": newGlobalCounter(namespace, "exporter_collector_panics_total", "Number of scrapes the collector panicked in", []string{"collector"}),
+			"collector_disabled":     newGlobalMetric(namespace, "exporter_collector_disabled", "Collectors disabled until restart because the macaroon lacks a permission for one of their RPCs", []string{"collector", "reason"}),
 		},
+	}
 
-		exportPeerMetrics:    exportPeerMetrics,
-		exportPaymentMetrics: true,
+	for _, lc := range lndCollectors {
+		if !opts.Collectors[lc.name] {
+			continue
+		}
+
+		var collector lndCollector
+		switch lc.name {
+		case "info":
+			collector = newInfoCollector(namespace)
+		case "wallet":
+			collector = newWalletCollector(namespace, rpcAddr, opts.State)
+		case "wallet_accounts":
+			collector = newWalletAccountsCollector(namespace)
+		case "fee_estimates":
+			collector = newFeeEstimatesCollector(namespace, opts.FeeConfTargets)
+		case "sweeps":
+			collector = newSweepsCollector(namespace)
+		case "channels":
+			collector = newChannelsCollector(namespace, rpcAddr, opts.DustExposureThreshold, opts.ChannelSizeThresholds, opts.ChannelConcentrationTop, opts.PeerShareTop, opts.PeerTags, opts.State)
+		case "peers":
+			collector = newPeersCollector(namespace, opts.PeerTags, opts.PeerScoreWeights, opts.GeoIP)
+		case "consistency":
+			collector = newConsistencyCollector(namespace)
+		case "forwarding":
+			collector = newForwardingCollector(namespace, rpcAddr, opts.ChannelPairs, opts.ForwardingLookback, opts.ForwardingArchive, opts.PeerTags, opts.State)
+		case "closed_channels":
+			collector = newClosedChannelsCollector(namespace)
+		case "invoices":
+			collector = newInvoicesCollector(namespace)
+		case "payments":
+			collector = newPaymentsCollector(namespace)
+		case "network":
+			collector = newNetworkCollector(namespace, opts.StaleThreshold, opts.PeerTags)
+		case "mission_control":
+			collector = newMissionControlCollector(namespace, opts.PeerTags)
+		case "rebalance":
+			collector = newRebalanceCollector(namespace, opts.PeerTags, opts.RebalanceWeights, opts.PeerScoreWeights, opts.RebalanceMaxFeeAdjustment)
+		case "watchtower":
+			collector = newWatchtowerCollector(namespace)
+		case "fields":
+			collector = newFieldsCollector(namespace, opts.FieldMetrics)
+		}
+		setMetricCollector(collector, lc.name)
+		c.collectors = append(c.collectors, enabledCollector{
+			name:        lc.name,
+			lowPriority: lc.lowPriority,
+			collector:   collector,
+		})
 	}
+	return c
 }
 
 func (c *LndExporter) Describe(ch chan<- *prometheus.Desc) {
-	for _, m := range c.metrics {
-		ch <- m
+	describeMetrics(c.metrics, ch)
+	for _, ec := range c.collectors {
+		ec.collector.Describe(ch)
 	}
 }
 
@@ -105,196 +346,334 @@ func getGrpcClient(rpcAddr string, tlsCertPath string, macaroonPath string) (*gr
 		return nil, err
 	}
 
+	callOpts := []grpc.CallOption{maxMsgRecvSize}
+	if rpcCompression != "none" {
+		callOpts = append(callOpts, grpc.UseCompressor(rpcCompression))
+	}
+
+	opts := []grpc.DialOption{
+		grpc.WithTransportCredentials(tlsCreds),
+		grpc.WithDefaultCallOptions(callOpts...),
+		grpc.WithConnectParams(connectParams),
+		grpc.WithUnaryInterceptor(permissionInterceptor),
+		grpc.WithStreamInterceptor(streamErrorInterceptor),
+	}
+
+	if macaroonPath != "" {
+		macOpts, err := macaroonCredential(macaroonPath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(macOpts))
+	}
+
+	log.Printf("dialing rpcAddr: %s", rpcAddr)
+	conn, err := grpc.Dial(rpcAddr, opts...)
+	if err != nil {
+		log.Printf("grpc.Dial() err: %s", err)
+		return nil, err
+	}
+
+	if macaroonPath == "" {
+		if err := probeNoMacaroons(conn, rpcAddr); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// macaroonProbeTimeout bounds the GetInfo call detecting whether lnd runs
+// with --no-macaroons.
+var macaroonProbeTimeout = 10 * time.Second
+
+// probeNoMacaroons detects whether lnd runs with --no-macaroons when
+// connecting without macaroon, so that a missing macaroon path fails the
+// connection instead of every RPC. A node that can't be reached is
+// probed again on the next connection.
+func probeNoMacaroons(conn *grpc.ClientConn, rpcAddr string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), macaroonProbeTimeout)
+	defer cancel()
+
+	_, err := lnrpc.NewLightningClient(conn).GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	switch {
+	case err == nil:
+		log.Printf("%s runs with --no-macaroons, connecting without macaroon", rpcAddr)
+	case strings.Contains(err.Error(), "expected 1 macaroon"):
+		return fmt.Errorf("%s requires a macaroon, no macaroon path is configured", rpcAddr)
+	}
+	return nil
+}
+
+func macaroonCredential(macaroonPath string) (macaroons.MacaroonCredential, error) {
 	macaroonBytes, err := os.ReadFile(macaroonPath)
 	if err != nil {
 		log.Println("Cannot read macaroon file", err)
-		return nil, err
+		return macaroons.MacaroonCredential{}, err
 	}
 
 	mac := &macaroon.Macaroon{}
 	if err = mac.UnmarshalBinary(macaroonBytes); err != nil {
 		log.Println("Cannot unmarshal macaroon", err)
-		return nil, err
+		return macaroons.MacaroonCredential{}, err
+	}
+
+	return macaroons.NewMacaroonCredential(mac)
+}
+
+// shed reports whether the low priority collector should be skipped
+// because less than shedThreshold is left until the scrape deadline, and
+// exports whether it was.
+func (c *LndExporter) shed(ctx context.Context, ch chan<- prometheus.Metric, collector string) bool {
+	shed := false
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < c.shedThreshold {
+		log.Printf("shedding %s collector, %s left until scrape deadline", collector, time.Until(deadline))
+		c.shedCount[collector]++
+		shed = true
 	}
 
-	macOpts, err := macaroons.NewMacaroonCredential(mac)
+	ch <- prometheus.MustNewConstMetric(c.metrics["collector_shed"],
+		prometheus.GaugeValue, boolToFloat(shed), collector)
+	ch <- prometheus.MustNewConstMetric(c.metrics["collector_shed_total"],
+		prometheus.CounterValue, float64(c.shedCount[collector]), collector)
+	return shed
+}
+
+// lightningClient returns a client for the lnd node, or for the recorded
+// fixtures in replay mode.
+func (c *LndExporter) lightningClient() (lnrpc.LightningClient, error) {
+	if c.replayDir != "" {
+		return newReplayClient(c.replayDir), nil
+	}
+
+	con, err := c.conn.get()
 	if err != nil {
 		return nil, err
 	}
+	return lnrpc.NewLightningClient(con), nil
+}
 
-	opts := []grpc.DialOption{
-		grpc.WithTransportCredentials(tlsCreds),
-		grpc.WithPerRPCCredentials(macOpts),
-		grpc.WithDefaultCallOptions(maxMsgRecvSize),
+// newScrape returns the scrape with clients for the lnd node, the subserver
+// clients are nil in replay mode.
+func (c *LndExporter) newScrape(client lnrpc.LightningClient, info *lnrpc.GetInfoResponse) *scrape {
+	s := &scrape{client: client, info: info}
+	if c.replayDir != "" {
+		return s
 	}
 
-	log.Printf("dialing rpcAddr: %s", rpcAddr)
-	conn, err := grpc.Dial(rpcAddr, opts...)
+	if con, err := c.conn.get(); err == nil {
+		s.walletKit = walletrpc.NewWalletKitClient(con)
+		s.router = routerrpc.NewRouterClient(con)
+		s.watchtower = watchtowerrpc.NewWatchtowerClient(con)
+	}
+	return s
+}
+
+// refresh runs the named collector out of band, e.g. to catch up with the
+// forwarding history without waiting for the next scrape. Its metrics are
+// discarded.
+func (c *LndExporter) refresh(ctx context.Context, name string) error {
+	c.Lock()
+	defer c.Unlock()
+
+	var collector *enabledCollector
+	for i := range c.collectors {
+		if c.collectors[i].name == name {
+			collector = &c.collectors[i]
+		}
+	}
+	if collector == nil {
+		return fmt.Errorf("collector %s is not enabled", name)
+	}
+
+	rpcClient, err := c.lightningClient()
 	if err != nil {
-		log.Printf("grpc.Dial() err: %s", err)
-		return nil, err
+		return err
 	}
 
-	return conn, nil
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	info, err := rpcClient.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	if err != nil {
+		return err
+	}
+
+	ch := make(chan prometheus.Metric)
+	done := make(chan struct{})
+	go func() {
+		for range ch {
+		}
+		close(done)
+	}()
+	panicked := c.collectSafely(ctx, *collector, c.newScrape(rpcClient, info), ch)
+	close(ch)
+	<-done
+	if panicked {
+		return fmt.Errorf("collector %s panicked", name)
+	}
+	return nil
+}
+
+// walletStates are the states exported by wallet_state, in the order lnd
+// goes through them on startup.
+var walletStates = []lnrpc.WalletState{
+	lnrpc.WalletState_WAITING_TO_START,
+	lnrpc.WalletState_NON_EXISTING,
+	lnrpc.WalletState_LOCKED,
+	lnrpc.WalletState_UNLOCKED,
+	lnrpc.WalletState_RPC_ACTIVE,
+	lnrpc.WalletState_SERVER_ACTIVE,
+}
+
+// collectWalletState exports the wallet state, which tells a locked wallet
+// apart from lnd being down. Nothing is exported in replay mode or when lnd
+// can't be reached.
+func (c *LndExporter) collectWalletState(ctx context.Context, ch chan<- prometheus.Metric) (lnrpc.WalletState, bool) {
+	if c.replayDir != "" {
+		return 0, false
+	}
+
+	con, err := c.conn.get()
+	if err != nil {
+		return 0, false
+	}
+	resp, err := lnrpc.NewStateClient(con).GetState(ctx, &lnrpc.GetStateRequest{})
+	if err != nil {
+		log.Printf("GetState err: %s", err)
+		return 0, false
+	}
+
+	for _, state := range walletStates {
+		ch <- prometheus.MustNewConstMetric(c.metrics["wallet_state"],
+			prometheus.GaugeValue, boolToFloat(resp.State == state), strings.ToLower(state.String()))
+	}
+	return resp.State, true
+}
+
+// collectSafely runs the collector, recovering from a panic so that a
+// malformed RPC response only fails that collector instead of the whole
+// scrape. It reports whether the collector panicked.
+func (c *LndExporter) collectSafely(ctx context.Context, ec enabledCollector, s *scrape, ch chan<- prometheus.Metric) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("collector %s panicked: %v\n%s", ec.name, r, debug.Stack())
+			c.collectorsMu.Lock()
+			c.panicCount[ec.name]++
+			c.collectorsMu.Unlock()
+			panicked = true
+		}
+	}()
+
+	ec.collector.Collect(ctx, s, ch)
+	return false
 }
 
 func (c *LndExporter) Collect(ch chan<- prometheus.Metric) {
 	c.Lock()
 	defer c.Unlock()
 
-	con, err := getGrpcClient(c.rpcAddr, c.tlsCertPath, c.macaroonPath)
+	rpcClient, err := c.lightningClient()
 	if err != nil {
 		log.Printf("getGrpcClient() err: %s", err)
+		c.health.set(err)
 		ch <- prometheus.MustNewConstMetric(c.metrics["lnd_up"], prometheus.GaugeValue, 0)
 		return
 	}
-	defer func() {
-		log.Println("closing connection")
-		con.Close()
-	}()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
 	defer cancel()
 
-	rpcClient := lnrpc.NewLightningClient(con)
+	var walletState lnrpc.WalletState
+	var walletStateOk bool
+	walletStateDone := make(chan struct{})
+	go func() {
+		walletState, walletStateOk = c.collectWalletState(ctx, ch)
+		close(walletStateDone)
+	}()
 
 	stats, err := rpcClient.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	c.health.set(err)
+	<-walletStateDone
 	if err != nil {
 		log.Printf("rpcClient.GetInfo() err: %s", err)
+		if walletStateOk && walletState == lnrpc.WalletState_LOCKED {
+			log.Printf("the wallet of %s is locked and needs to be unlocked", c.rpcAddr)
+		}
 		ch <- prometheus.MustNewConstMetric(c.metrics["lnd_up"], prometheus.GaugeValue, 0.0)
 		return
 	}
 
-	ch <- prometheus.MustNewConstMetric(c.metrics["instance_info"],
-		prometheus.GaugeValue, 1.0,
-		stats.Alias,
-		stats.IdentityPubkey,
-		stats.Version,
-	)
-	ch <- prometheus.MustNewConstMetric(c.metrics["peers"],
-		prometheus.GaugeValue, float64(stats.NumPeers))
-	ch <- prometheus.MustNewConstMetric(c.metrics["channels"],
-		prometheus.GaugeValue, float64(stats.NumActiveChannels), "active")
-	ch <- prometheus.MustNewConstMetric(c.metrics["channels"],
-		prometheus.GaugeValue, float64(stats.NumPendingChannels), "pending")
-	ch <- prometheus.MustNewConstMetric(c.metrics["channels"],
-		prometheus.GaugeValue, float64(stats.NumInactiveChannels), "inactive")
-	ch <- prometheus.MustNewConstMetric(c.metrics["block_height"],
-		prometheus.GaugeValue, float64(stats.BlockHeight))
-	ch <- prometheus.MustNewConstMetric(c.metrics["synced_to_chain"],
-		prometheus.GaugeValue, boolToFloat(stats.SyncedToChain))
-
-	if walletStats, err := rpcClient.WalletBalance(ctx, &lnrpc.WalletBalanceRequest{}); err == nil {
-		ch <- prometheus.MustNewConstMetric(c.metrics["wallet_balance_satoshis"],
-			prometheus.GaugeValue, float64(walletStats.UnconfirmedBalance), "unconfirmed")
-		ch <- prometheus.MustNewConstMetric(c.metrics["wallet_balance_satoshis"],
-			prometheus.GaugeValue, float64(walletStats.ConfirmedBalance), "confirmed")
-	} else {
-		log.Printf("rpcClient.GetWalletStats err: %s", err)
-	}
-
-	if pendingChannelsStats, err := rpcClient.PendingChannels(ctx, &lnrpc.PendingChannelsRequest{}); err == nil {
-		ch <- prometheus.MustNewConstMetric(c.metrics["channels_limbo_balance_satoshis"],
-			prometheus.GaugeValue, float64(pendingChannelsStats.TotalLimboBalance))
-		ch <- prometheus.MustNewConstMetric(c.metrics["channels_pending"],
-			prometheus.GaugeValue, float64(len(pendingChannelsStats.PendingOpenChannels)), "opening", "false")
-		ch <- prometheus.MustNewConstMetric(c.metrics["channels_pending"],
-			prometheus.GaugeValue, float64(len(pendingChannelsStats.PendingClosingChannels)), "closing", "false")
-		ch <- prometheus.MustNewConstMetric(c.metrics["channels_pending"],
-			prometheus.GaugeValue, float64(len(pendingChannelsStats.PendingForceClosingChannels)), "closing", "true")
-		ch <- prometheus.MustNewConstMetric(c.metrics["channels_waiting_close"],
-			prometheus.GaugeValue, float64(len(pendingChannelsStats.WaitingCloseChannels)))
-	} else {
-		log.Printf("rpcClient.GetPendingChannelsStats err: %s", err)
-	}
+	c.collectAll(ctx, c.newScrape(rpcClient, stats), ch)
 
-	if channelsBalanceStats, err := rpcClient.ChannelBalance(ctx, &lnrpc.ChannelBalanceRequest{}); err == nil {
-		ch <- prometheus.MustNewConstMetric(c.metrics["channels_balance_satoshis"],
-			prometheus.GaugeValue, float64(channelsBalanceStats.Balance))
-	} else {
-		log.Printf("rpcClient.GetChannelsBalanceStats err: %s", err)
-	}
-
-	// todo: fix this
-	//
-	if c.exportPaymentMetrics {
-		fwdReq := &lnrpc.ForwardingHistoryRequest{}
-		if fwdHistoryStats, err := rpcClient.ForwardingHistory(ctx, fwdReq); err == nil {
-			for _, f := range fwdHistoryStats.GetForwardingEvents() {
-				ch <- prometheus.MustNewConstMetric(c.metrics["forwarding_history_info"],
-					prometheus.GaugeValue, float64(1.0),
-					f.PeerAliasIn,
-					f.PeerAliasOut,
-					strconv.FormatUint(f.AmtIn, 10),
-					strconv.FormatUint(f.AmtOut, 10),
-					strconv.FormatUint(f.Fee, 10),
-					strconv.FormatUint(f.ChanIdIn, 10),
-					strconv.FormatUint(f.ChanIdOut, 10),
-					strconv.FormatUint(f.TimestampNs, 10),
-				)
-			}
-		} else {
-			log.Printf("rpcClient.GetChannelsBalanceStats err: %s", err)
-		}
+	// lnd only answers GetInfo without macaroon if it runs with
+	// --no-macaroons.
+	if c.replayDir == "" {
+		ch <- prometheus.MustNewConstMetric(c.metrics["no_macaroons"],
+			prometheus.GaugeValue, boolToFloat(c.macaroonPath == ""))
 	}
 
-	if networkInfo, err := rpcClient.GetNetworkInfo(ctx, &lnrpc.NetworkInfoRequest{}); err == nil {
-		ch <- prometheus.MustNewConstMetric(c.metrics["network_capacity_satoshis_total"],
-			prometheus.GaugeValue, float64(networkInfo.TotalNetworkCapacity))
-		ch <- prometheus.MustNewConstMetric(c.metrics["network_channels_total"],
-			prometheus.GaugeValue, float64(networkInfo.NumChannels))
-		ch <- prometheus.MustNewConstMetric(c.metrics["network_nodes_total"],
-			prometheus.GaugeValue, float64(networkInfo.NumNodes))
-	}
-
-	if channelBalanceStats, err := rpcClient.ListChannels(ctx, &lnrpc.ListChannelsRequest{}); err == nil {
-		for _, channel := range channelBalanceStats.Channels {
-			lbls := []string{
-				strconv.FormatBool(channel.Active),
-				channel.RemotePubkey,
-				channel.ChannelPoint,
-				strconv.FormatUint(channel.ChanId, 10),
-				strconv.FormatInt(channel.Capacity, 10),
-				strconv.FormatInt(channel.CommitFee, 10),
-				strconv.FormatBool(channel.Private),
-				strconv.FormatBool(channel.Initiator),
-			}
-
-			realCapacity := float64(channel.Capacity) - float64(channel.CommitFee)
-			balancePercentage := float64(channel.LocalBalance) / realCapacity
-
-			ch <- prometheus.MustNewConstMetric(c.metrics["channel_balance_satoshis"],
-				prometheus.GaugeValue, float64(channel.LocalBalance), lbls...)
-			ch <- prometheus.MustNewConstMetric(c.metrics["channel_balance_percentage"],
-				prometheus.GaugeValue, float64(balancePercentage), lbls...)
-		}
-	} else {
-		log.Printf("rpcClient.GetChannelBalanceStats err: %s", err)
+	ch <- prometheus.MustNewConstMetric(c.metrics["lnd_up"], prometheus.GaugeValue, 1.0)
+}
+
+// collectAll runs the enabled collectors with up to concurrency of them at
+// once, so that a scrape takes about as long as its slowest RPCs instead of
+// the sum of all. Collectors are started in order, a low priority one is
+// shed if the deadline is close by the time it gets its turn.
+func (c *LndExporter) collectAll(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	concurrency := c.concurrency
+	if concurrency < 1 {
+		concurrency = 1
 	}
+	slots := make(chan struct{}, concurrency)
+
+	var wg sync.WaitGroup
+	for _, ec := range c.collectors {
+		c.collectorsMu.Lock()
+		disabled := c.disabled[ec.name]
+		c.collectorsMu.Unlock()
+		if disabled {
+			ch <- prometheus.MustNewConstMetric(c.metrics["collector_disabled"],
+				prometheus.GaugeValue, 1, ec.name, "permission")
+			continue
+		}
 
-	if c.exportPeerMetrics {
-		peers, err := rpcClient.ListPeers(ctx, &lnrpc.ListPeersRequest{})
-		if err != nil {
-			for _, peer := range peers.GetPeers() {
-				dir := "outbound"
-				if peer.Inbound {
-					dir = "inbound"
-				}
-				ch <- prometheus.MustNewConstMetric(c.metrics["peer_info"],
-					prometheus.GaugeValue, 1.0,
-					peer.Address,
-					peer.PubKey,
-					dir)
-
-				ch <- prometheus.MustNewConstMetric(c.metrics["peer_info_received_bytes_total"],
-					prometheus.CounterValue, float64(peer.BytesRecv), peer.Address)
-				ch <- prometheus.MustNewConstMetric(c.metrics["peer_info_sent_bytes_total"],
-					prometheus.CounterValue, float64(peer.BytesSent), peer.Address)
-			}
-		} else {
-			log.Printf("rpcClient.ListPeers err: %s", err)
+		slots <- struct{}{}
+		if ec.lowPriority && c.shed(ctx, ch, ec.name) {
+			<-slots
+			continue
 		}
+
+		wg.Add(1)
+		go func(ec enabledCollector) {
+			defer func() {
+				<-slots
+				wg.Done()
+			}()
+			c.collectOne(ctx, ec, s, ch)
+		}(ec)
 	}
+	wg.Wait()
+}
 
-	ch <- prometheus.MustNewConstMetric(c.metrics["lnd_up"], prometheus.GaugeValue, 1.0)
+// collectOne runs the collector and exports how it went.
+func (c *LndExporter) collectOne(ctx context.Context, ec enabledCollector, s *scrape, ch chan<- prometheus.Metric) {
+	collectCtx, rpcs := withCollectRPCs(ctx)
+	start := time.Now()
+	failed := c.collectSafely(collectCtx, ec, s, ch)
+	ch <- prometheus.MustNewConstMetric(c.metrics["scrape_duration"],
+		prometheus.GaugeValue, time.Since(start).Seconds(), ec.name)
+	ch <- prometheus.MustNewConstMetric(c.metrics["scrape_success"],
+		prometheus.GaugeValue, boolToFloat(!failed && rpcs.failures() == 0), ec.name)
+	ch <- prometheus.MustNewConstMetric(c.metrics["collector_failed"],
+		prometheus.GaugeValue, boolToFloat(failed), ec.name)
+
+	c.collectorsMu.Lock()
+	defer c.collectorsMu.Unlock()
+	ch <- prometheus.MustNewConstMetric(c.metrics["collector_panics_total"],
+		prometheus.CounterValue, float64(c.panicCount[ec.name]), ec.name)
+	if methods := rpcs.deniedMethods(); len(methods) > 0 {
+		log.Printf("disabling %s collector, the macaroon lacks the permission for %v", ec.name, methods)
+		c.disabled[ec.name] = true
+	}
 }
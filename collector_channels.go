@@ -0,0 +1,601 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// knownCommitmentTypes are exported even when no open channel has them so
+// that their series don't disappear.
+var knownCommitmentTypes = []lnrpc.CommitmentType{
+	lnrpc.CommitmentType_LEGACY,
+	lnrpc.CommitmentType_STATIC_REMOTE_KEY,
+	lnrpc.CommitmentType_ANCHORS,
+	lnrpc.CommitmentType_SCRIPT_ENFORCED_LEASE,
+	lnrpc.CommitmentType_SIMPLE_TAPROOT,
+}
+
+// channelsCollector exports pending and open channels, their balances,
+// constraints and our fee policies.
+type channelsCollector struct {
+	metrics map[string]*prometheus.Desc
+
+	dustExposureThreshold int64
+	peerTags              map[string]string
+
+	// sizeThresholds are the channel capacities in satoshis above which
+	// channels are counted, concentrationTop the numbers of largest
+	// channels whose share of the total capacity is exported.
+	sizeThresholds   []int64
+	concentrationTop []int64
+
+	// peerShareTop is the number of largest peers whose share of the
+	// total capacity is exported.
+	peerShareTop int
+
+	// policies holds the last seen fee policy of our side of each channel
+	// and how often it changed since the exporter started.
+	policies *lruCache[uint64, channelPolicyState]
+
+	// openCosts holds the funding transaction fee of open channels we
+	// initiated, checkpointed to state under stateKey since the funding
+	// transaction is only looked up once.
+	openCosts map[uint64]int64
+	state     *stateStore
+	stateKey  string
+
+	// openCostMisses holds when the funding transaction of a channel
+	// wasn't found in the wallet, e.g. for external PSBT funding, it is
+	// looked up again after openCostRetryInterval.
+	openCostMisses *lruCache[uint64, time.Time]
+
+	// closeFees holds the fee of the closing commitment of waiting-close
+	// channels by closing txid, lnd doesn't report it anymore once the
+	// channel is force closing. They are checkpointed to state under
+	// closeFeesKey.
+	closeFees    map[string]closeFee
+	closeFeesKey string
+
+	// noFeeEstimate is set once lnd turned out to lack the walletrpc
+	// subserver for the close cost fee estimate.
+	noFeeEstimate bool
+}
+
+type closeFee struct {
+	FeeSat int64 `json:"fee_sat"`
+}
+
+type channelPolicy struct {
+	baseFeeMsat int64
+	feePerMil   int64
+}
+
+type channelPolicyState struct {
+	policy  channelPolicy
+	changes uint64
+}
+
+func newChannelsCollector(namespace string, rpcAddr string, dustExposureThreshold int64, sizeThresholds []int64, concentrationTop []int64, peerShareTop int, peerTags map[string]string, state *stateStore) *channelsCollector {
+	c := &channelsCollector{
+		dustExposureThreshold: dustExposureThreshold,
+		peerTags:              peerTags,
+		sizeThresholds:        sizeThresholds,
+		concentrationTop:      concentrationTop,
+		peerShareTop:          peerShareTop,
+
+		policies: newLruCache[uint64, channelPolicyState]("channel_policies", rpcAddr),
+
+		openCosts:      map[uint64]int64{},
+		openCostMisses: newLruCache[uint64, time.Time]("channel_open_cost_misses", rpcAddr),
+		closeFees:      map[string]closeFee{},
+		state:          state,
+
+		closeFeesKey: "channel_close_fees/" + rpcAddr,
+		stateKey:     "channel_open_costs/" + rpcAddr,
+
+		metrics: map[string]*prometheus.Desc{
+			"channels_by_commitment_type":     newGlobalMetric(namespace, "channels_by_commitment_type", "Number of open channels by commitment type", []string{"commitment_type"}),
+			"channels_limbo_balance_satoshis": newGlobalMetric(namespace, "channel_limbo_balance_satoshis", "The balance in satoshis encumbered in pending channels", []string{}),
+			"channels_pending":                newGlobalMetric(namespace, "channel_pending", "The total pending channels", []string{"status", "forced"}),
+			"channels_waiting_close":          newGlobalMetric(namespace, "channel_waiting_close", "Channels waiting for closing tx to confirm", []string{}),
+			"channel_close_fee_rate":          newGlobalMetric(namespace, "channel_close_fee_rate_sat_per_vbyte", "Fee rate of the commitment transaction closing the waiting-close or force closing channel", []string{"chan_point", "remote_pubkey", "closing_txid"}),
+			"channel_close_pending_balance":   newGlobalMetric(namespace, "channel_close_pending_balance_satoshis", "The balance in satoshis stuck behind a pending close", []string{"chan_point", "remote_pubkey", "status"}),
+			"force_close_limbo_balance":       newGlobalMetric(namespace, "channel_force_close_limbo_balance_satoshis", "The balance in satoshis of a pending force close still locked in timelocks", []string{"chan_point", "remote_pubkey"}),
+			"force_close_recovered_balance":   newGlobalMetric(namespace, "channel_force_close_recovered_balance_satoshis", "The balance in satoshis of a pending force close already swept back to the wallet", []string{"chan_point", "remote_pubkey"}),
+			"force_close_maturity_height":     newGlobalMetric(namespace, "channel_force_close_maturity_height", "The block height at which the commitment output of a pending force close can be swept", []string{"chan_point", "remote_pubkey"}),
+			"force_close_blocks_til_maturity": newGlobalMetric(namespace, "channel_force_close_blocks_til_maturity", "Remaining blocks until the commitment output of a pending force close can be swept, negative if unconfirmed", []string{"chan_point", "remote_pubkey"}),
+			"channels_above_size":             newGlobalMetric(namespace, "channels_above_size", "Number of open channels with a capacity above the threshold from -channels.size-thresholds", []string{"threshold_sat"}),
+			"channels_above_size_capacity":    newGlobalMetric(namespace, "channels_above_size_capacity_satoshis", "Capacity of the open channels with a capacity above the threshold from -channels.size-thresholds", []string{"threshold_sat"}),
+			"channels_capacity_top_share":     newGlobalMetric(namespace, "channels_capacity_top_share", "Share of the capacity of all open channels in the largest channels, for the numbers of channels from -channels.concentration-top", []string{"top"}),
+			"peer_capacity_share":             newGlobalMetric(namespace, "peer_capacity_share", "Share of the capacity of all open channels held with the peer, for the largest peers up to -channels.peer-share-top", []string{"remote_pubkey", "tag"}),
+			"peer_capacity_concentration":     newGlobalMetric(namespace, "peer_capacity_concentration_index", "Herfindahl index of the channel capacity by peer, the sum of the squared capacity shares, 1 if all capacity is with one peer", []string{}),
+			"channels_inbound_capacity":       newGlobalMetric(namespace, "channels_inbound_capacity_satoshis", "Inbound capacity of active channels, the remote balance above the remote channel reserve, by visibility. Private channels are usable in route hints only", []string{"visibility"}),
+			"channels_balance_satoshis":       newGlobalMetric(namespace, "channels_balance_satoshis", "Sum of all channel funds available", []string{}),
+			"channel_balance_satoshis":        newGlobalMetric(namespace, "channel_balance_satoshis", "The channel local balance", []string{"active", "remote_pubkey", "chan_point", "chan_id", "capacity", "commit_fee", "private", "initator", "tag"}),
+			"channel_balance_percentage":      newGlobalMetric(namespace, "channel_balance_percentage", "The channel local balance", []string{"active", "remote_pubkey", "chan_point", "chan_id", "capacity", "commit_fee", "private", "initator", "tag"}),
+			"channel_commit_weight_estimate":  newGlobalMetric(namespace, "channel_commit_weight_estimate", "Estimated weight of the current commitment transaction based on channel type and pending HTLCs", channelLabels),
+			"channel_close_cost_estimate":     newGlobalMetric(namespace, "channel_close_cost_estimate_satoshis", "Projected on-chain cost of force closing the channel at lnd's current fee estimate for confirmation within 6 blocks, or at the commitment fee rate without the walletrpc subserver", channelLabels),
+			"channel_dust_htlc_exposure":      newGlobalMetric(namespace, "channel_dust_htlc_exposure_satoshis", "Sum of pending HTLCs trimmed as dust on the local or remote commitment", append(channelLabels, "commitment")),
+			"channel_dust_exposure_ratio":     newGlobalMetric(namespace, "channel_dust_exposure_ratio", "The larger of local and remote dust exposure relative to the configured dust exposure threshold", channelLabels),
+			"channel_constraint_reserve":      newGlobalMetric(namespace, "channel_constraint_reserve_satoshis", "The channel reserve the constrained side has to keep", append(channelLabels, "imposed_by")),
+			"channel_constraint_max_pending":  newGlobalMetric(namespace, "channel_constraint_max_pending_amount_msat", "The maximum amount allowed to be pending in HTLCs", append(channelLabels, "imposed_by")),
+			"channel_constraint_max_htlcs":    newGlobalMetric(namespace, "channel_constraint_max_accepted_htlcs", "The maximum number of HTLCs that can be offered", append(channelLabels, "imposed_by")),
+			"channel_constraint_min_htlc":     newGlobalMetric(namespace, "channel_constraint_min_htlc_msat", "The smallest HTLC that can be offered", append(channelLabels, "imposed_by")),
+			"channel_constraint_csv_delay":    newGlobalMetric(namespace, "channel_constraint_csv_delay", "The CSV delay the constrained side's funds are locked for on force close", append(channelLabels, "imposed_by")),
+			"channel_policy_changes_total":    newGlobalCounter(namespace, "channel_policy_changes_total", "Number of changes to our channel fee policy observed between collections", []string{"chan_id", "chan_point"}),
+			"channel_policy_info":             newGlobalMetric(namespace, "channel_policy_info", "The latest fee policy of our side of the channel", []string{"chan_id", "chan_point", "base_fee_msat", "fee_per_mil"}),
+			"channel_remote_balance":          newGlobalMetric(namespace, "channel_remote_balance_satoshis", "The channel balance of the peer", channelLabels),
+			"channel_unsettled_balance":       newGlobalMetric(namespace, "channel_unsettled_balance_satoshis", "The channel balance in pending HTLCs", channelLabels),
+			"channel_pending_htlcs":           newGlobalMetric(namespace, "channel_pending_htlcs", "Number of pending HTLCs in the channel", channelLabels),
+			"channel_commit_weight":           newGlobalMetric(namespace, "channel_commit_weight", "Weight of the current commitment transaction as reported by lnd", channelLabels),
+			"channel_num_updates":             newGlobalMetric(namespace, "channel_num_updates", "Number of updates to the channel state", channelLabels),
+			"channel_total_satoshis_sent":     newGlobalMetric(namespace, "channel_total_satoshis_sent", "Amount sent through the channel over its lifetime", channelLabels),
+			"channel_total_satoshis_received": newGlobalMetric(namespace, "channel_total_satoshis_received", "Amount received through the channel over its lifetime", channelLabels),
+			"channel_uptime_seconds":          newGlobalMetric(namespace, "channel_uptime_seconds", "Time the peer was connected during the channel's lifetime as monitored by lnd", channelLabels),
+			"channel_lifetime_seconds":        newGlobalMetric(namespace, "channel_lifetime_seconds", "Time lnd has monitored the channel since it was opened or lnd started", channelLabels),
+			"channel_htlc_slots_used":         newGlobalMetric(namespace, "channel_htlc_slots_used", "Number of pending HTLCs offered by us (outgoing) or the peer (incoming)", append(channelLabels, "direction")),
+			"channel_htlc_slot_utilization":   newGlobalMetric(namespace, "channel_htlc_slot_utilization", "Pending HTLCs relative to the max_accepted_htlcs limit of their direction, at 1 no more HTLCs can be added", append(channelLabels, "direction")),
+			"fee_revenue_msat":                newGlobalMetric(namespace, "fee_revenue_msat", "Forwarding fees earned over the last day, week or month as reported by lnd, with satoshi precision", []string{"window"}),
+			"dust_exposure_threshold":         newGlobalMetric(namespace, "dust_exposure_threshold_satoshis", "The dust exposure threshold configured for lnd", []string{}),
+			"channel_open_cost":               newGlobalMetric(namespace, "channel_open_cost_satoshis", "On-chain fee of the funding transaction of channels we initiated, split between the channels of a batch open", channelLabels),
+		},
+	}
+
+	if _, err := state.get(c.stateKey, &c.openCosts); err != nil {
+		log.Printf("invalid channel open costs, looking them up again: %s", err)
+	}
+	if _, err := state.get(c.closeFeesKey, &c.closeFees); err != nil {
+		log.Printf("invalid channel close fees, ignoring them: %s", err)
+	}
+	return c
+}
+
+func (c *channelsCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *channelsCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if pendingChannelsStats, err := s.client.PendingChannels(ctx, &lnrpc.PendingChannelsRequest{}); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_limbo_balance_satoshis"],
+			prometheus.GaugeValue, float64(pendingChannelsStats.TotalLimboBalance))
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_pending"],
+			prometheus.GaugeValue, float64(len(pendingChannelsStats.PendingOpenChannels)), "opening", "false")
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_pending"],
+			prometheus.GaugeValue, float64(len(pendingChannelsStats.PendingClosingChannels)), "closing", "false")
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_pending"],
+			prometheus.GaugeValue, float64(len(pendingChannelsStats.PendingForceClosingChannels)), "closing", "true")
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_waiting_close"],
+			prometheus.GaugeValue, float64(len(pendingChannelsStats.WaitingCloseChannels)))
+
+		closingTxids := map[string]bool{}
+		closeFeesChanged := false
+		for _, wc := range pendingChannelsStats.WaitingCloseChannels {
+			if wc.Channel == nil {
+				continue
+			}
+			closingTxids[wc.ClosingTxid] = true
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_close_pending_balance"],
+				prometheus.GaugeValue, float64(wc.LimboBalance),
+				wc.Channel.ChannelPoint, wc.Channel.RemoteNodePub, "waiting_close")
+
+			if feeSat, ok := closingCommitFee(wc); ok {
+				if cf := (closeFee{FeeSat: feeSat}); c.closeFees[wc.ClosingTxid] != cf {
+					c.closeFees[wc.ClosingTxid] = cf
+					closeFeesChanged = true
+				}
+				// lnd doesn't report the HTLCs of waiting-close
+				// channels, they are weighed in once the channel is
+				// force closing.
+				weight := estimateCommitWeight(wc.Channel.CommitmentType, 0)
+				ch <- prometheus.MustNewConstMetric(c.metrics["channel_close_fee_rate"],
+					prometheus.GaugeValue, feeRateSatPerVbyte(feeSat, weight),
+					wc.Channel.ChannelPoint, wc.Channel.RemoteNodePub, wc.ClosingTxid)
+			}
+		}
+
+		for _, fc := range pendingChannelsStats.PendingForceClosingChannels {
+			if fc.Channel == nil {
+				continue
+			}
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_close_pending_balance"],
+				prometheus.GaugeValue, float64(fc.LimboBalance),
+				fc.Channel.ChannelPoint, fc.Channel.RemoteNodePub, "force_closing")
+
+			// The fee is only known if the channel was seen waiting
+			// to close, the weight estimate counts the HTLCs still
+			// pending on the commitment.
+			closingTxids[fc.ClosingTxid] = true
+			if cf, ok := c.closeFees[fc.ClosingTxid]; ok {
+				weight := estimateCommitWeight(fc.Channel.CommitmentType, len(fc.PendingHtlcs))
+				ch <- prometheus.MustNewConstMetric(c.metrics["channel_close_fee_rate"],
+					prometheus.GaugeValue, feeRateSatPerVbyte(cf.FeeSat, weight),
+					fc.Channel.ChannelPoint, fc.Channel.RemoteNodePub, fc.ClosingTxid)
+			}
+
+			for name, value := range map[string]int64{
+				"force_close_limbo_balance":       fc.LimboBalance,
+				"force_close_recovered_balance":   fc.RecoveredBalance,
+				"force_close_maturity_height":     int64(fc.MaturityHeight),
+				"force_close_blocks_til_maturity": int64(fc.BlocksTilMaturity),
+			} {
+				ch <- prometheus.MustNewConstMetric(c.metrics[name],
+					prometheus.GaugeValue, float64(value),
+					fc.Channel.ChannelPoint, fc.Channel.RemoteNodePub)
+			}
+		}
+
+		for txid := range c.closeFees {
+			if !closingTxids[txid] {
+				delete(c.closeFees, txid)
+				closeFeesChanged = true
+			}
+		}
+		if closeFeesChanged {
+			if err := c.state.put(c.closeFeesKey, c.closeFees); err != nil {
+				log.Printf("saving channel close fees err: %s", err)
+			}
+		}
+	} else {
+		log.Printf("s.client.GetPendingChannelsStats err: %s", err)
+	}
+
+	if channelsBalanceStats, err := s.client.ChannelBalance(ctx, &lnrpc.ChannelBalanceRequest{}); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_balance_satoshis"],
+			prometheus.GaugeValue, float64(channelsBalanceStats.Balance))
+	} else {
+		log.Printf("s.client.GetChannelsBalanceStats err: %s", err)
+	}
+
+	if feeReport, err := s.client.FeeReport(ctx, &lnrpc.FeeReportRequest{}); err == nil {
+		// lnd reports the fee sums in satoshis.
+		for window, sum := range map[string]uint64{
+			"day":   feeReport.DayFeeSum,
+			"week":  feeReport.WeekFeeSum,
+			"month": feeReport.MonthFeeSum,
+		} {
+			ch <- prometheus.MustNewConstMetric(c.metrics["fee_revenue_msat"],
+				prometheus.GaugeValue, float64(sum*1000), window)
+		}
+
+		for _, fee := range feeReport.ChannelFees {
+			policy := channelPolicy{baseFeeMsat: fee.BaseFeeMsat, feePerMil: fee.FeePerMil}
+			policyState, ok := c.policies.get(fee.ChanId)
+			if ok && policyState.policy != policy {
+				policyState.changes++
+			}
+			policyState.policy = policy
+			c.policies.put(fee.ChanId, policyState)
+
+			chanId := strconv.FormatUint(fee.ChanId, 10)
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_policy_changes_total"],
+				prometheus.CounterValue, float64(policyState.changes),
+				chanId, fee.ChannelPoint)
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_policy_info"],
+				prometheus.GaugeValue, 1.0,
+				chanId, fee.ChannelPoint,
+				strconv.FormatInt(fee.BaseFeeMsat, 10),
+				strconv.FormatInt(fee.FeePerMil, 10))
+		}
+	} else {
+		log.Printf("s.client.FeeReport err: %s", err)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["dust_exposure_threshold"],
+		prometheus.GaugeValue, float64(c.dustExposureThreshold))
+
+	if channels, err := s.listChannels(ctx); err == nil {
+		commitmentTypes := map[string]int{}
+		for _, commitmentType := range knownCommitmentTypes {
+			commitmentTypes[strings.ToLower(commitmentType.String())] = 0
+		}
+		for _, channel := range channels {
+			commitmentTypes[strings.ToLower(channel.CommitmentType.String())]++
+		}
+		for commitmentType, n := range commitmentTypes {
+			ch <- prometheus.MustNewConstMetric(c.metrics["channels_by_commitment_type"],
+				prometheus.GaugeValue, float64(n), commitmentType)
+		}
+
+		inbound := map[string]int64{"private": 0, "public": 0}
+		for _, channel := range channels {
+			if !channel.Active {
+				continue
+			}
+			visibility := "public"
+			if channel.Private {
+				visibility = "private"
+			}
+			if usable := channel.RemoteBalance - int64(channel.RemoteConstraints.GetChanReserveSat()); usable > 0 {
+				inbound[visibility] += usable
+			}
+		}
+		for visibility, sat := range inbound {
+			ch <- prometheus.MustNewConstMetric(c.metrics["channels_inbound_capacity"],
+				prometheus.GaugeValue, float64(sat), visibility)
+		}
+
+		c.collectSizeExposure(ch, channels)
+		c.collectPeerConcentration(ch, channels)
+		c.updateOpenCosts(ctx, s, channels)
+		closeFeePerKw, closeFeeOk := c.closeFeeEstimate(ctx, s)
+
+		for _, channel := range channels {
+			lbls := []string{
+				strconv.FormatBool(channel.Active),
+				channel.RemotePubkey,
+				channel.ChannelPoint,
+				strconv.FormatUint(channel.ChanId, 10),
+				strconv.FormatInt(channel.Capacity, 10),
+				strconv.FormatInt(channel.CommitFee, 10),
+				strconv.FormatBool(channel.Private),
+				strconv.FormatBool(channel.Initiator),
+				c.peerTags[channel.RemotePubkey],
+			}
+
+			realCapacity := float64(channel.Capacity) - float64(channel.CommitFee)
+			balancePercentage := float64(channel.LocalBalance) / realCapacity
+
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_balance_satoshis"],
+				prometheus.GaugeValue, float64(channel.LocalBalance), lbls...)
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_balance_percentage"],
+				prometheus.GaugeValue, float64(balancePercentage), lbls...)
+
+			chanLbls := []string{
+				strconv.FormatUint(channel.ChanId, 10),
+				channel.ChannelPoint,
+				channel.RemotePubkey,
+				c.peerTags[channel.RemotePubkey],
+			}
+
+			// Without a fee estimate fee_per_kw, the fee rate lnd keeps the
+			// commitment at, is used. It lags behind the estimate and is
+			// capped for anchor channels.
+			commitWeight := estimateCommitWeight(channel.CommitmentType, len(channel.PendingHtlcs))
+			feePerKw := channel.FeePerKw
+			if closeFeeOk {
+				feePerKw = closeFeePerKw
+			}
+			closeCost := float64(commitWeight) * float64(feePerKw) / 1000
+
+			if cost, ok := c.openCosts[channel.ChanId]; ok {
+				ch <- prometheus.MustNewConstMetric(c.metrics["channel_open_cost"],
+					prometheus.GaugeValue, float64(cost), chanLbls...)
+			}
+
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_commit_weight_estimate"],
+				prometheus.GaugeValue, float64(commitWeight), chanLbls...)
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_close_cost_estimate"],
+				prometheus.GaugeValue, closeCost, chanLbls...)
+
+			// Local constraints are imposed on us by the peer, remote
+			// constraints are the ones we impose on the peer.
+			for imposedBy, constraints := range map[string]*lnrpc.ChannelConstraints{
+				"remote": channel.LocalConstraints,
+				"local":  channel.RemoteConstraints,
+			} {
+				if constraints == nil {
+					continue
+				}
+				sideLbls := append(chanLbls[:len(chanLbls):len(chanLbls)], imposedBy)
+				ch <- prometheus.MustNewConstMetric(c.metrics["channel_constraint_reserve"],
+					prometheus.GaugeValue, float64(constraints.ChanReserveSat), sideLbls...)
+				ch <- prometheus.MustNewConstMetric(c.metrics["channel_constraint_max_pending"],
+					prometheus.GaugeValue, float64(constraints.MaxPendingAmtMsat), sideLbls...)
+				ch <- prometheus.MustNewConstMetric(c.metrics["channel_constraint_max_htlcs"],
+					prometheus.GaugeValue, float64(constraints.MaxAcceptedHtlcs), sideLbls...)
+				ch <- prometheus.MustNewConstMetric(c.metrics["channel_constraint_min_htlc"],
+					prometheus.GaugeValue, float64(constraints.MinHtlcMsat), sideLbls...)
+				ch <- prometheus.MustNewConstMetric(c.metrics["channel_constraint_csv_delay"],
+					prometheus.GaugeValue, float64(constraints.CsvDelay), sideLbls...)
+			}
+
+			for name, v := range map[string]int64{
+				"channel_remote_balance":          channel.RemoteBalance,
+				"channel_unsettled_balance":       channel.UnsettledBalance,
+				"channel_pending_htlcs":           int64(len(channel.PendingHtlcs)),
+				"channel_commit_weight":           channel.CommitWeight,
+				"channel_num_updates":             int64(channel.NumUpdates),
+				"channel_total_satoshis_sent":     channel.TotalSatoshisSent,
+				"channel_total_satoshis_received": channel.TotalSatoshisReceived,
+				"channel_uptime_seconds":          channel.Uptime,
+				"channel_lifetime_seconds":        channel.Lifetime,
+			} {
+				ch <- prometheus.MustNewConstMetric(c.metrics[name],
+					prometheus.GaugeValue, float64(v), chanLbls...)
+			}
+
+			c.collectHtlcSlots(ch, channel, chanLbls)
+
+			localDust, remoteDust := dustExposure(channel)
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_dust_htlc_exposure"],
+				prometheus.GaugeValue, float64(localDust), append(chanLbls, "local")...)
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_dust_htlc_exposure"],
+				prometheus.GaugeValue, float64(remoteDust), append(chanLbls, "remote")...)
+			if c.dustExposureThreshold > 0 {
+				maxDust := localDust
+				if remoteDust > maxDust {
+					maxDust = remoteDust
+				}
+				ch <- prometheus.MustNewConstMetric(c.metrics["channel_dust_exposure_ratio"],
+					prometheus.GaugeValue, float64(maxDust)/float64(c.dustExposureThreshold), chanLbls...)
+			}
+		}
+	} else {
+		log.Printf("s.client.GetChannelBalanceStats err: %s", err)
+	}
+}
+
+// collectHtlcSlots exports how many of the HTLC slots of each direction
+// are in use. Outgoing HTLCs are limited by the max_accepted_htlcs the
+// peer imposes on us, incoming ones by the limit we impose on the peer.
+func (c *channelsCollector) collectHtlcSlots(ch chan<- prometheus.Metric, channel *lnrpc.Channel, chanLbls []string) {
+	var incoming, outgoing int
+	for _, htlc := range channel.PendingHtlcs {
+		if htlc.Incoming {
+			incoming++
+		} else {
+			outgoing++
+		}
+	}
+
+	for direction, slots := range map[string]struct {
+		used        int
+		constraints *lnrpc.ChannelConstraints
+	}{
+		"incoming": {incoming, channel.RemoteConstraints},
+		"outgoing": {outgoing, channel.LocalConstraints},
+	} {
+		dirLbls := append(chanLbls[:len(chanLbls):len(chanLbls)], direction)
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_htlc_slots_used"],
+			prometheus.GaugeValue, float64(slots.used), dirLbls...)
+		if slots.constraints != nil && slots.constraints.MaxAcceptedHtlcs > 0 {
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_htlc_slot_utilization"],
+				prometheus.GaugeValue, float64(slots.used)/float64(slots.constraints.MaxAcceptedHtlcs), dirLbls...)
+		}
+	}
+}
+
+// openCostRetryInterval is how long to wait before looking up a funding
+// transaction again that wasn't found in the wallet.
+var openCostRetryInterval = 24 * time.Hour
+
+// updateOpenCosts looks up the funding transaction fee of channels we
+// initiated that aren't known yet in the wallet's transactions, limited to
+// the blocks the channels were confirmed in.
+func (c *channelsCollector) updateOpenCosts(ctx context.Context, s *scrape, channels []*lnrpc.Channel) {
+	updated := false
+	defer func() {
+		if !updated {
+			return
+		}
+		if err := c.state.put(c.stateKey, c.openCosts); err != nil {
+			log.Printf("saving channel open costs err: %s", err)
+		}
+	}()
+
+	// The costs of closed channels are dropped.
+	open := map[uint64]bool{}
+	for _, channel := range channels {
+		open[channel.ChanId] = true
+	}
+	for chanId := range c.openCosts {
+		if !open[chanId] {
+			delete(c.openCosts, chanId)
+			updated = true
+		}
+	}
+
+	var startHeight, endHeight int32
+	missing := false
+	fundingChannels := map[string]int64{}
+	for _, channel := range channels {
+		if !channel.Initiator {
+			continue
+		}
+		txid, _, _ := strings.Cut(channel.ChannelPoint, ":")
+		fundingChannels[txid]++
+
+		if _, ok := c.openCosts[channel.ChanId]; ok {
+			continue
+		}
+		if missed, ok := c.openCostMisses.get(channel.ChanId); ok && time.Since(missed) < openCostRetryInterval {
+			continue
+		}
+		// The block height is encoded in the upper 3 bytes of the
+		// short channel id.
+		height := int32(channel.ChanId >> 40)
+		if !missing || height < startHeight {
+			startHeight = height
+		}
+		if !missing || height > endHeight {
+			endHeight = height
+		}
+		missing = true
+	}
+	if !missing {
+		return
+	}
+
+	txs, err := s.client.GetTransactions(ctx, &lnrpc.GetTransactionsRequest{
+		StartHeight: startHeight,
+		EndHeight:   endHeight,
+	})
+	if err != nil {
+		log.Printf("s.client.GetTransactions err: %s", err)
+		return
+	}
+
+	fees := map[string]int64{}
+	for _, tx := range txs.Transactions {
+		fees[tx.TxHash] = tx.TotalFees
+	}
+
+	now := time.Now()
+	for _, channel := range channels {
+		if _, ok := c.openCosts[channel.ChanId]; ok || !channel.Initiator {
+			continue
+		}
+		if missed, ok := c.openCostMisses.get(channel.ChanId); ok && now.Sub(missed) < openCostRetryInterval {
+			continue
+		}
+		txid, _, _ := strings.Cut(channel.ChannelPoint, ":")
+		if fee, ok := fees[txid]; ok {
+			c.openCosts[channel.ChanId] = fee / fundingChannels[txid]
+			updated = true
+		} else {
+			c.openCostMisses.put(channel.ChanId, now)
+		}
+	}
+}
+
+// closeCostConfTarget is the confirmation target in blocks of the fee
+// estimate the close cost of channels is projected with.
+const closeCostConfTarget = 6
+
+// closeFeeEstimate returns lnd's current fee estimate for closing channels
+// in sat/kw. It needs the walletrpc subserver, without it the estimate is
+// not asked for again.
+func (c *channelsCollector) closeFeeEstimate(ctx context.Context, s *scrape) (int64, bool) {
+	if s.walletKit == nil || c.noFeeEstimate {
+		return 0, false
+	}
+	resp, err := s.walletKit.EstimateFee(withoutCollectRPCs(ctx), &walletrpc.EstimateFeeRequest{ConfTarget: closeCostConfTarget})
+	if status.Code(err) == codes.Unimplemented {
+		log.Printf("walletrpc subserver not available, projecting close costs at the commitment fee rate")
+		c.noFeeEstimate = true
+		return 0, false
+	}
+	if err != nil {
+		log.Printf("s.walletKit.EstimateFee err: %s", err)
+		return 0, false
+	}
+	return resp.SatPerKw, true
+}
+
+// closingCommitFee returns the fee of the commitment transaction that is
+// being used to close a waiting-close channel, if it is one of the known
+// commitments.
+func closingCommitFee(wc *lnrpc.PendingChannelsResponse_WaitingCloseChannel) (int64, bool) {
+	cm := wc.Commitments
+	if cm == nil || wc.ClosingTxid == "" {
+		return 0, false
+	}
+	switch wc.ClosingTxid {
+	case cm.LocalTxid:
+		return int64(cm.LocalCommitFeeSat), true
+	case cm.RemoteTxid:
+		return int64(cm.RemoteCommitFeeSat), true
+	case cm.RemotePendingTxid:
+		return int64(cm.RemotePendingCommitFeeSat), true
+	}
+	return 0, false
+}
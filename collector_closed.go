@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// closeTypes are exported even when no channel was closed that way so
+// that their series don't disappear.
+var closeTypes = []lnrpc.ChannelCloseSummary_ClosureType{
+	lnrpc.ChannelCloseSummary_COOPERATIVE_CLOSE,
+	lnrpc.ChannelCloseSummary_LOCAL_FORCE_CLOSE,
+	lnrpc.ChannelCloseSummary_REMOTE_FORCE_CLOSE,
+	lnrpc.ChannelCloseSummary_BREACH_CLOSE,
+	lnrpc.ChannelCloseSummary_FUNDING_CANCELED,
+	lnrpc.ChannelCloseSummary_ABANDONED,
+}
+
+// closedChannelsCollector exports the closed channels by how they were
+// closed, to track channel churn and force closes.
+type closedChannelsCollector struct {
+	metrics map[string]*prometheus.Desc
+}
+
+func newClosedChannelsCollector(namespace string) *closedChannelsCollector {
+	return &closedChannelsCollector{
+		metrics: map[string]*prometheus.Desc{
+			"closed_channels":                    newGlobalMetric(namespace, "closed_channels", "Number of closed channels by close type", []string{"close_type"}),
+			"closed_channels_settled_balance":    newGlobalMetric(namespace, "closed_channels_settled_balance_satoshis", "Our balance settled to the wallet by closed channels, by close type", []string{"close_type"}),
+			"closed_channels_timelocked_balance": newGlobalMetric(namespace, "closed_channels_time_locked_balance_satoshis", "Our balance of closed channels still time locked in force close outputs, by close type", []string{"close_type"}),
+		},
+	}
+}
+
+func (c *closedChannelsCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *closedChannelsCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	closed, err := s.client.ClosedChannels(ctx, &lnrpc.ClosedChannelsRequest{})
+	if err != nil {
+		log.Printf("s.client.ClosedChannels err: %s", err)
+		return
+	}
+
+	counts := map[lnrpc.ChannelCloseSummary_ClosureType]int{}
+	settled := map[lnrpc.ChannelCloseSummary_ClosureType]int64{}
+	timeLocked := map[lnrpc.ChannelCloseSummary_ClosureType]int64{}
+	for _, channel := range closed.Channels {
+		counts[channel.CloseType]++
+		settled[channel.CloseType] += channel.SettledBalance
+		timeLocked[channel.CloseType] += channel.TimeLockedBalance
+	}
+
+	for _, closeType := range closeTypes {
+		label := strings.TrimSuffix(strings.ToLower(closeType.String()), "_close")
+		ch <- prometheus.MustNewConstMetric(c.metrics["closed_channels"],
+			prometheus.GaugeValue, float64(counts[closeType]), label)
+		ch <- prometheus.MustNewConstMetric(c.metrics["closed_channels_settled_balance"],
+			prometheus.GaugeValue, float64(settled[closeType]), label)
+		ch <- prometheus.MustNewConstMetric(c.metrics["closed_channels_timelocked_balance"],
+			prometheus.GaugeValue, float64(timeLocked[closeType]), label)
+	}
+}
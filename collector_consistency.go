@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"log"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// consistencyCollector cross-checks totals lnd reports in one RPC against
+// the sum of the items of another, e.g. the channel balance against the
+// balances of the listed channels. A mismatch points at a pagination or
+// parsing bug in the exporter or an inconsistency in lnd. As the RPCs are
+// not atomic, a channel or peer changing between them causes a mismatch
+// for a single scrape, alerts should require it to persist.
+type consistencyCollector struct {
+	metrics map[string]*prometheus.Desc
+}
+
+func newConsistencyCollector(namespace string) *consistencyCollector {
+	return &consistencyCollector{
+		metrics: map[string]*prometheus.Desc{
+			"consistency_mismatch":   newGlobalMetric(namespace, "consistency_check_mismatch", "Whether the check found the total reported by lnd to differ from the sum of the listed items", []string{"check"}),
+			"consistency_difference": newGlobalMetric(namespace, "consistency_check_difference", "Total reported by lnd minus the sum of the listed items, in satoshis for balance checks", []string{"check"}),
+		},
+	}
+}
+
+func (c *consistencyCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *consistencyCollector) check(ch chan<- prometheus.Metric, check string, reported, listed int64) {
+	ch <- prometheus.MustNewConstMetric(c.metrics["consistency_mismatch"],
+		prometheus.GaugeValue, boolToFloat(reported != listed), check)
+	ch <- prometheus.MustNewConstMetric(c.metrics["consistency_difference"],
+		prometheus.GaugeValue, float64(reported-listed), check)
+}
+
+func (c *consistencyCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	channels, err := s.listChannels(ctx)
+	if err != nil {
+		log.Printf("s.client.ListChannels err: %s", err)
+		return
+	}
+
+	var active, inactive, localBalance, remoteBalance int64
+	for _, channel := range channels {
+		if channel.Active {
+			active++
+		} else {
+			inactive++
+		}
+		localBalance += channel.LocalBalance
+		remoteBalance += channel.RemoteBalance
+	}
+	c.check(ch, "active_channels", int64(s.info.NumActiveChannels), active)
+	c.check(ch, "inactive_channels", int64(s.info.NumInactiveChannels), inactive)
+
+	if balance, err := s.client.ChannelBalance(ctx, &lnrpc.ChannelBalanceRequest{}); err == nil {
+		c.check(ch, "local_balance", int64(balance.LocalBalance.GetSat()), localBalance)
+		c.check(ch, "remote_balance", int64(balance.RemoteBalance.GetSat()), remoteBalance)
+	} else {
+		log.Printf("s.client.ChannelBalance err: %s", err)
+	}
+
+	// lnd counts only pending open channels in GetInfo.
+	if pending, err := s.client.PendingChannels(ctx, &lnrpc.PendingChannelsRequest{}); err == nil {
+		c.check(ch, "pending_channels", int64(s.info.NumPendingChannels), int64(len(pending.PendingOpenChannels)))
+	} else {
+		log.Printf("s.client.PendingChannels err: %s", err)
+	}
+
+	if peers, err := s.client.ListPeers(ctx, &lnrpc.ListPeersRequest{}); err == nil {
+		c.check(ch, "peers", int64(s.info.NumPeers), int64(len(peers.Peers)))
+	} else {
+		log.Printf("s.client.ListPeers err: %s", err)
+	}
+}
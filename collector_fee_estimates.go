@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// feeEstimatesCollector exports the on-chain fee rates lnd's fee estimator
+// returns for the confirmation targets from -fees.conf-targets, the rates
+// lnd uses for channel opens, closes and sweeps.
+type feeEstimatesCollector struct {
+	metrics     map[string]*prometheus.Desc
+	confTargets []int64
+}
+
+func newFeeEstimatesCollector(namespace string, confTargets []int64) *feeEstimatesCollector {
+	return &feeEstimatesCollector{
+		confTargets: confTargets,
+		metrics: map[string]*prometheus.Desc{
+			"fee_estimate": newGlobalMetric(namespace, "fee_estimate_sat_per_vbyte", "lnd's on-chain fee rate estimate for confirmation within the target number of blocks", []string{"conf_target"}),
+		},
+	}
+}
+
+func (c *feeEstimatesCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *feeEstimatesCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if s.walletKit == nil {
+		return
+	}
+
+	for _, target := range c.confTargets {
+		resp, err := s.walletKit.EstimateFee(ctx, &walletrpc.EstimateFeeRequest{ConfTarget: int32(target)})
+		if err != nil {
+			log.Printf("s.walletKit.EstimateFee err: %s", err)
+			return
+		}
+		// lnd returns sat/kw, a vbyte is four weight units.
+		ch <- prometheus.MustNewConstMetric(c.metrics["fee_estimate"],
+			prometheus.GaugeValue, float64(resp.SatPerKw)*4/1000, strconv.FormatInt(target, 10))
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// fieldMetricRpcs are the RPCs whose response fields can be exported with
+// field_metrics in the config file.
+var fieldMetricRpcs = map[string]bool{
+	"GetInfo":      true,
+	"ListChannels": true,
+}
+
+// fieldsCollector exports numeric and boolean fields of RPC responses as
+// configured in the config file, e.g. GetInfo's num_peers.
+type fieldsCollector struct {
+	fields []fieldMetric
+}
+
+type fieldMetric struct {
+	config     FieldMetricConfig
+	desc       *prometheus.Desc
+	labelNames []string
+}
+
+func newFieldsCollector(namespace string, configs []FieldMetricConfig) *fieldsCollector {
+	c := &fieldsCollector{}
+	for _, config := range configs {
+		var labelNames []string
+		for name := range config.Labels {
+			labelNames = append(labelNames, name)
+		}
+		sort.Strings(labelNames)
+
+		help := config.Help
+		if help == "" {
+			help = config.Rpc + " " + config.Field
+		}
+		desc := newGlobalMetric(namespace, config.Name, help, labelNames)
+		markFieldMetric(desc)
+		c.fields = append(c.fields, fieldMetric{
+			config:     config,
+			desc:       desc,
+			labelNames: labelNames,
+		})
+	}
+	return c
+}
+
+func (c *fieldsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, f := range c.fields {
+		ch <- f.desc
+	}
+}
+
+func (c *fieldsCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	responses := map[string][]map[string]interface{}{}
+	for _, f := range c.fields {
+		items, ok := responses[f.config.Rpc]
+		if !ok {
+			var err error
+			if items, err = fieldItems(ctx, s, f.config.Rpc); err != nil {
+				log.Printf("field metrics %s err: %s", f.config.Rpc, err)
+			}
+			responses[f.config.Rpc] = items
+		}
+
+		for _, item := range items {
+			v, ok := fieldValue(item, f.config.Field)
+			if !ok {
+				continue
+			}
+			labels := make([]string, len(f.labelNames))
+			for i, name := range f.labelNames {
+				labels[i] = fieldString(item, f.config.Labels[name])
+			}
+			ch <- prometheus.MustNewConstMetric(f.desc, prometheus.GaugeValue, v, labels...)
+		}
+	}
+}
+
+// fieldItems returns the RPC response as generic JSON objects with lnd's
+// field names, one per channel for ListChannels.
+func fieldItems(ctx context.Context, s *scrape, rpc string) ([]map[string]interface{}, error) {
+	var messages [][]byte
+	switch rpc {
+	case "GetInfo":
+		data, err := lnrpc.ProtoJSONMarshalOpts.Marshal(s.info)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, data)
+
+	case "ListChannels":
+		channels, err := s.listChannels(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, channel := range channels {
+			data, err := lnrpc.ProtoJSONMarshalOpts.Marshal(channel)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, data)
+		}
+	}
+
+	var items []map[string]interface{}
+	for _, data := range messages {
+		item := map[string]interface{}{}
+		if err := json.Unmarshal(data, &item); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// lookupField resolves a dot separated path like "chains.0.network" in a
+// JSON object.
+func lookupField(item map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = item
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if v, ok = node[key]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			v = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// fieldValue returns the numeric or boolean field at path. 64 bit integers
+// are encoded as strings in lnd's JSON.
+func fieldValue(item map[string]interface{}, path string) (float64, bool) {
+	v, ok := lookupField(item, path)
+	if !ok {
+		return 0, false
+	}
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case bool:
+		return boolToFloat(v), true
+	case string:
+		f, err := strconv.ParseFloat(v, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+func fieldString(item map[string]interface{}, path string) string {
+	v, ok := lookupField(item, path)
+	if !ok {
+		return ""
+	}
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	return ""
+}
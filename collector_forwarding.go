@@ -0,0 +1,374 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// forwardingPageSize is the number of events requested per
+	// ForwardingHistory call.
+	forwardingPageSize uint32 = 10000
+
+	// forwardingSlice is the time range requested per ForwardingHistory
+	// call, so that catching up with a long history is done in bounded
+	// steps.
+	forwardingSlice = 24 * time.Hour
+)
+
+// unknownPeer is the remote_pubkey label of forwards through channels that
+// are neither open nor closed, e.g. not yet confirmed.
+const unknownPeer = "unknown"
+
+// forwardingCheckpoint is the position in the forwarding history persisted
+// in the state store.
+type forwardingCheckpoint struct {
+	StartTime int64  `json:"start_time"`
+	Offset    uint32 `json:"offset"`
+}
+
+// forwardingCollector exports lnd's forwarding history.
+type forwardingCollector struct {
+	metrics map[string]*prometheus.Desc
+
+	// startTime and offset are the position in the forwarding history
+	// processed so far, offset counts the events since the start of the
+	// time slice at startTime. They are checkpointed to state under
+	// stateKey. After a restart the counters start from zero again but
+	// continue with the forwards made while the exporter was down.
+	startTime time.Time
+	offset    uint32
+	state     *stateStore
+	stateKey  string
+
+	// chanPeers caches the peer pubkey of open and closed channels, peers,
+	// channels and pairs hold the counters of peers, channels and channel
+	// pairs. Counters evicted from the bounded caches start counting from
+	// zero again when they forward next.
+	chanPeers *lruCache[uint64, string]
+	peers     *lruCache[string, *peerForwards]
+	channels  *lruCache[uint64, *channelForwards]
+
+	pairs *lruCache[channelPair, *pairForwards]
+
+	// channelPairs is the number of busiest channel pairs exported by
+	// forwarded amount.
+	channelPairs int
+
+	peerTags map[string]string
+
+	// hourly holds the forwarded amount by UTC hour of day.
+	hourly [24]uint64
+
+	// archive gets the forwards of rpcAddr before they are counted, nil
+	// unless -forwarding.archive-dir is set.
+	archive *forwardingArchive
+	rpcAddr string
+}
+
+type channelPair struct {
+	chanIdIn  uint64
+	chanIdOut uint64
+}
+
+// channelForwards holds the forwarding counters of a channel, fees are
+// attributed to the outgoing channel whose policy set them.
+type channelForwards struct {
+	alias       string
+	forwardsIn  uint64
+	forwardsOut uint64
+	feesMsat    uint64
+}
+
+type pairForwards struct {
+	forwards      uint64
+	forwardedMsat uint64
+	feesMsat      uint64
+}
+
+// peerForwards holds the forwarding counters of a peer. Traffic entering
+// the node from the peer counts as in, traffic leaving towards it as out, so
+// sources and sinks of routed volume can be told apart.
+type peerForwards struct {
+	alias    string
+	inMsat   uint64
+	outMsat  uint64
+	feesMsat uint64
+}
+
+func newForwardingCollector(namespace string, rpcAddr string, channelPairs int, lookback time.Duration, archive *forwardingArchive, peerTags map[string]string, state *stateStore) *forwardingCollector {
+	c := &forwardingCollector{
+		archive:   archive,
+		rpcAddr:   rpcAddr,
+		startTime: time.Now().Add(-lookback),
+		state:     state,
+		stateKey:  "forwarding/" + rpcAddr,
+		chanPeers: newLruCache[uint64, string]("forwarding_channel_peers", rpcAddr),
+		peers:     newLruCache[string, *peerForwards]("forwarding_peers", rpcAddr),
+		channels:  newLruCache[uint64, *channelForwards]("forwarding_channels", rpcAddr),
+
+		pairs:        newLruCache[channelPair, *pairForwards]("forwarding_channel_pairs", rpcAddr),
+		channelPairs: channelPairs,
+		peerTags:     peerTags,
+
+		metrics: map[string]*prometheus.Desc{
+			"forwards_total":          newGlobalCounter(namespace, "forwards_total", "Number of settled forwards from the incoming to the outgoing channel", []string{"chan_id_in", "chan_id_out"}),
+			"forwarded_msat_total":    newGlobalCounter(namespace, "forwarded_msat_total", "Amount forwarded from the incoming to the outgoing channel", []string{"chan_id_in", "chan_id_out"}),
+			"forward_fees_msat_total": newGlobalCounter(namespace, "forward_fees_msat_total", "Fees earned forwarding from the incoming to the outgoing channel", []string{"chan_id_in", "chan_id_out"}),
+
+			"channel_forwards_total":         newGlobalCounter(namespace, "channel_forwards_total", "Number of settled forwards through the channel, by whether it was the incoming (in) or outgoing (out) channel", []string{"chan_id", "peer_alias", "tag", "direction"}),
+			"channel_fee_revenue_msat_total": newGlobalCounter(namespace, "channel_fee_revenue_msat_total", "Fees earned forwarding out through the channel", []string{"chan_id", "peer_alias", "tag"}),
+
+			"peer_routed_msat_total":       newGlobalCounter(namespace, "peer_routed_msat_total", "Amount forwarded through channels with the peer, by whether the peer was the incoming (in) or outgoing (out) leg of the forward", []string{"remote_pubkey", "peer_alias", "tag", "direction"}),
+			"peer_forward_fees_msat_total": newGlobalCounter(namespace, "peer_forward_fees_msat_total", "Fees earned forwarding to the peer, attributed to the outgoing peer whose channel policy set them", []string{"remote_pubkey", "peer_alias", "tag"}),
+			"forwarded_msat_by_hour":       newGlobalMetric(namespace, "forwarded_msat_by_hour", "Amount forwarded since the exporter started by UTC hour of day the forward settled in", []string{"hour"}),
+			"channel_pair_forwarded_msat":  newGlobalMetric(namespace, "channel_pair_forwarded_msat", "Amount forwarded from the incoming to the outgoing channel since the exporter started, for the busiest pairs with the rest summed up as other", []string{"chan_id_in", "chan_id_out"}),
+		},
+	}
+
+	var checkpoint forwardingCheckpoint
+	if ok, err := state.get(c.stateKey, &checkpoint); err != nil {
+		log.Printf("invalid forwarding checkpoint, starting from now: %s", err)
+	} else if ok {
+		c.startTime = time.Unix(checkpoint.StartTime, 0)
+		c.offset = checkpoint.Offset
+	}
+	return c
+}
+
+func (c *forwardingCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *forwardingCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if err := c.update(ctx, s); err != nil {
+		log.Printf("updating forwarding counters err: %s", err)
+	}
+
+	c.pairs.each(func(pair channelPair, f *pairForwards) {
+		chanIdIn := strconv.FormatUint(pair.chanIdIn, 10)
+		chanIdOut := strconv.FormatUint(pair.chanIdOut, 10)
+		ch <- prometheus.MustNewConstMetric(c.metrics["forwards_total"],
+			prometheus.CounterValue, float64(f.forwards), chanIdIn, chanIdOut)
+		ch <- prometheus.MustNewConstMetric(c.metrics["forwarded_msat_total"],
+			prometheus.CounterValue, float64(f.forwardedMsat), chanIdIn, chanIdOut)
+		ch <- prometheus.MustNewConstMetric(c.metrics["forward_fees_msat_total"],
+			prometheus.CounterValue, float64(f.feesMsat), chanIdIn, chanIdOut)
+	})
+
+	c.channels.each(func(chanId uint64, f *channelForwards) {
+		id := strconv.FormatUint(chanId, 10)
+		pubkey, _ := c.chanPeers.get(chanId)
+		tag := c.peerTags[pubkey]
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_forwards_total"],
+			prometheus.CounterValue, float64(f.forwardsIn), id, f.alias, tag, "in")
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_forwards_total"],
+			prometheus.CounterValue, float64(f.forwardsOut), id, f.alias, tag, "out")
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_fee_revenue_msat_total"],
+			prometheus.CounterValue, float64(f.feesMsat), id, f.alias, tag)
+	})
+
+	c.peers.each(func(pubkey string, p *peerForwards) {
+		tag := c.peerTags[pubkey]
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_routed_msat_total"],
+			prometheus.CounterValue, float64(p.inMsat), pubkey, p.alias, tag, "in")
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_routed_msat_total"],
+			prometheus.CounterValue, float64(p.outMsat), pubkey, p.alias, tag, "out")
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_forward_fees_msat_total"],
+			prometheus.CounterValue, float64(p.feesMsat), pubkey, p.alias, tag)
+	})
+
+	for hour, msat := range c.hourly {
+		ch <- prometheus.MustNewConstMetric(c.metrics["forwarded_msat_by_hour"],
+			prometheus.GaugeValue, float64(msat), strconv.Itoa(hour))
+	}
+
+	if c.channelPairs > 0 {
+		c.collectChannelPairs(ch)
+	}
+}
+
+// collectChannelPairs exports the channelPairs busiest channel pairs. Pairs
+// move in and out of the top as traffic shifts, so the metric is a gauge.
+func (c *forwardingCollector) collectChannelPairs(ch chan<- prometheus.Metric) {
+	forwards := c.pairs.items()
+	pairs := make([]channelPair, 0, len(forwards))
+	for pair := range forwards {
+		pairs = append(pairs, pair)
+	}
+	sort.Slice(pairs, func(i, j int) bool {
+		if a, b := forwards[pairs[i]].forwardedMsat, forwards[pairs[j]].forwardedMsat; a != b {
+			return a > b
+		}
+		if pairs[i].chanIdIn != pairs[j].chanIdIn {
+			return pairs[i].chanIdIn < pairs[j].chanIdIn
+		}
+		return pairs[i].chanIdOut < pairs[j].chanIdOut
+	})
+
+	var other uint64
+	for i, pair := range pairs {
+		if i >= c.channelPairs {
+			other += forwards[pair].forwardedMsat
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_pair_forwarded_msat"],
+			prometheus.GaugeValue, float64(forwards[pair].forwardedMsat),
+			strconv.FormatUint(pair.chanIdIn, 10), strconv.FormatUint(pair.chanIdOut, 10))
+	}
+	if len(pairs) > c.channelPairs {
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_pair_forwarded_msat"],
+			prometheus.GaugeValue, float64(other), "other", "other")
+	}
+}
+
+// update adds the forwards since the last update to the counters.
+func (c *forwardingCollector) update(ctx context.Context, s *scrape) error {
+	if channels, err := s.listChannels(ctx); err == nil {
+		for _, channel := range channels {
+			c.chanPeers.put(channel.ChanId, channel.RemotePubkey)
+		}
+	}
+
+	closedLoaded := false
+	for {
+		// Events are requested page by page within one time slice at a
+		// time and added to the counters right away, so that only a
+		// page of events is held in memory however far behind we are.
+		now := time.Now()
+		sliceEnd := c.startTime.Add(forwardingSlice)
+		lastSlice := !sliceEnd.Before(now)
+		if lastSlice {
+			sliceEnd = now
+		}
+
+		resp, err := s.client.ForwardingHistory(ctx, &lnrpc.ForwardingHistoryRequest{
+			StartTime:       uint64(c.startTime.Unix()),
+			EndTime:         uint64(sliceEnd.Unix()),
+			IndexOffset:     c.offset,
+			NumMaxEvents:    forwardingPageSize,
+			PeerAliasLookup: true,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Archived first, a failed write is retried with the page at
+		// the next update without counting its forwards twice.
+		if c.archive != nil && len(resp.ForwardingEvents) > 0 {
+			if err := c.archive.write(c.rpcAddr, resp.ForwardingEvents); err != nil {
+				return err
+			}
+		}
+
+		for _, f := range resp.ForwardingEvents {
+			// Forwards through channels closed since the last update
+			// need the closed channels for their peer.
+			if !closedLoaded && (!c.knownChannel(f.ChanIdIn) || !c.knownChannel(f.ChanIdOut)) {
+				closedLoaded = true
+				if err := c.loadClosedChannels(ctx, s); err != nil {
+					log.Printf("rpcClient.ClosedChannels err: %s", err)
+				}
+			}
+
+			in := c.peer(f.ChanIdIn, f.PeerAliasIn)
+			in.inMsat += f.AmtInMsat
+
+			out := c.peer(f.ChanIdOut, f.PeerAliasOut)
+			out.outMsat += f.AmtOutMsat
+			out.feesMsat += f.FeeMsat
+
+			c.channel(f.ChanIdIn, f.PeerAliasIn).forwardsIn++
+			chanOut := c.channel(f.ChanIdOut, f.PeerAliasOut)
+			chanOut.forwardsOut++
+			chanOut.feesMsat += f.FeeMsat
+
+			c.hourly[time.Unix(0, int64(f.TimestampNs)).UTC().Hour()] += f.AmtOutMsat
+
+			pair := channelPair{chanIdIn: f.ChanIdIn, chanIdOut: f.ChanIdOut}
+			pf, ok := c.pairs.get(pair)
+			if !ok {
+				pf = &pairForwards{}
+				c.pairs.put(pair, pf)
+			}
+			pf.forwards++
+			pf.forwardedMsat += f.AmtOutMsat
+			pf.feesMsat += f.FeeMsat
+		}
+
+		if resp.LastOffsetIndex != c.offset {
+			c.offset = resp.LastOffsetIndex
+			c.saveCheckpoint()
+		}
+		if uint32(len(resp.ForwardingEvents)) == forwardingPageSize {
+			continue
+		}
+		if lastSlice {
+			return nil
+		}
+
+		// The slice is done, continue with the next one.
+		c.startTime, c.offset = sliceEnd, 0
+		c.saveCheckpoint()
+	}
+}
+
+func (c *forwardingCollector) saveCheckpoint() {
+	checkpoint := forwardingCheckpoint{StartTime: c.startTime.Unix(), Offset: c.offset}
+	if err := c.state.put(c.stateKey, checkpoint); err != nil {
+		log.Printf("saving forwarding checkpoint err: %s", err)
+	}
+}
+
+func (c *forwardingCollector) knownChannel(chanId uint64) bool {
+	_, ok := c.chanPeers.get(chanId)
+	return ok
+}
+
+func (c *forwardingCollector) loadClosedChannels(ctx context.Context, s *scrape) error {
+	closed, err := s.client.ClosedChannels(ctx, &lnrpc.ClosedChannelsRequest{})
+	if err != nil {
+		return err
+	}
+	for _, channel := range closed.Channels {
+		c.chanPeers.put(channel.ChanId, channel.RemotePubkey)
+	}
+	return nil
+}
+
+// peer returns the counters of the peer of the channel, updating its alias.
+func (c *forwardingCollector) peer(chanId uint64, alias string) *peerForwards {
+	pubkey, ok := c.chanPeers.get(chanId)
+	if !ok {
+		pubkey = unknownPeer
+	}
+
+	p, ok := c.peers.get(pubkey)
+	if !ok {
+		p = &peerForwards{}
+		c.peers.put(pubkey, p)
+	}
+	if alias != "" {
+		p.alias = alias
+	}
+	return p
+}
+
+// channel returns the counters of the channel, updating its peer alias.
+func (c *forwardingCollector) channel(chanId uint64, alias string) *channelForwards {
+	f, ok := c.channels.get(chanId)
+	if !ok {
+		f = &channelForwards{}
+		c.channels.put(chanId, f)
+	}
+	if alias != "" {
+		f.alias = alias
+	}
+	return f
+}
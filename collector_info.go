@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// infoCollector exports the node info, chain sync state and channel counts
+// reported by GetInfo, and the progress of a wallet recovery.
+type infoCollector struct {
+	metrics map[string]*prometheus.Desc
+
+	// recoveryStart is the first progress seen during the current wallet
+	// recovery, the completion time is extrapolated from it.
+	recoveryStart *recoverySample
+}
+
+type recoverySample struct {
+	at       time.Time
+	progress float64
+}
+
+func newInfoCollector(namespace string) *infoCollector {
+	return &infoCollector{
+		metrics: map[string]*prometheus.Desc{
+			"instance_info":         newGlobalMetric(namespace, "instance_info", "instance_info", []string{"alias", "pubkey", "version"}),
+			"peers":                 newGlobalMetric(namespace, "peers", "Number of currently connected peers.", []string{}),
+			"channels":              newGlobalMetric(namespace, "channels", "Number of channels", []string{"status"}),
+			"block_height":          newGlobalMetric(namespace, "block_height", "The node’s current view of the height of the best block", []string{}),
+			"synced_to_chain":       newGlobalMetric(namespace, "synced_to_chain", "The node’s current view of the height of the best block", []string{}),
+			"synced_to_graph":       newGlobalMetric(namespace, "synced_to_graph", "Whether the node finished the initial sync of the channel graph", []string{}),
+			"best_header_timestamp": newGlobalMetric(namespace, "best_header_timestamp_seconds", "Unix time of the best block header the node knows of", []string{}),
+			"chain_sync_lag":        newGlobalMetric(namespace, "chain_sync_lag_seconds", "Seconds since the timestamp of the best block header the node knows of", []string{}),
+
+			"recovery_mode":       newGlobalMetric(namespace, "wallet_recovery_mode", "Whether the wallet was started in recovery mode to rescan the chain", []string{}),
+			"recovery_finished":   newGlobalMetric(namespace, "wallet_recovery_finished", "Whether the wallet recovery rescan finished", []string{}),
+			"recovery_progress":   newGlobalMetric(namespace, "wallet_recovery_progress_ratio", "Progress of the wallet recovery rescan from the wallet birthday to the chain tip, between 0 and 1", []string{}),
+			"recovery_completion": newGlobalMetric(namespace, "wallet_recovery_estimated_completion_timestamp_seconds", "Unix time the wallet recovery rescan is estimated to finish, extrapolated from the progress since the exporter first saw it", []string{}),
+		},
+	}
+}
+
+func (c *infoCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *infoCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	stats := s.info
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["instance_info"],
+		prometheus.GaugeValue, 1.0,
+		stats.Alias,
+		stats.IdentityPubkey,
+		stats.Version,
+	)
+	ch <- prometheus.MustNewConstMetric(c.metrics["peers"],
+		prometheus.GaugeValue, float64(stats.NumPeers))
+	ch <- prometheus.MustNewConstMetric(c.metrics["channels"],
+		prometheus.GaugeValue, float64(stats.NumActiveChannels), "active")
+	ch <- prometheus.MustNewConstMetric(c.metrics["channels"],
+		prometheus.GaugeValue, float64(stats.NumPendingChannels), "pending")
+	ch <- prometheus.MustNewConstMetric(c.metrics["channels"],
+		prometheus.GaugeValue, float64(stats.NumInactiveChannels), "inactive")
+	ch <- prometheus.MustNewConstMetric(c.metrics["block_height"],
+		prometheus.GaugeValue, float64(stats.BlockHeight))
+	ch <- prometheus.MustNewConstMetric(c.metrics["synced_to_chain"],
+		prometheus.GaugeValue, boolToFloat(stats.SyncedToChain))
+	ch <- prometheus.MustNewConstMetric(c.metrics["synced_to_graph"],
+		prometheus.GaugeValue, boolToFloat(stats.SyncedToGraph))
+	ch <- prometheus.MustNewConstMetric(c.metrics["best_header_timestamp"],
+		prometheus.GaugeValue, float64(stats.BestHeaderTimestamp))
+	ch <- prometheus.MustNewConstMetric(c.metrics["chain_sync_lag"],
+		prometheus.GaugeValue, time.Since(time.Unix(stats.BestHeaderTimestamp, 0)).Seconds())
+
+	c.collectRecovery(ctx, s, ch)
+}
+
+// collectRecovery exports the progress of a wallet recovery. lnd reports it
+// as a ratio only, not the height the rescan reached.
+func (c *infoCollector) collectRecovery(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	recovery, err := s.client.GetRecoveryInfo(ctx, &lnrpc.GetRecoveryInfoRequest{})
+	if err != nil {
+		log.Printf("s.client.GetRecoveryInfo err: %s", err)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["recovery_mode"],
+		prometheus.GaugeValue, boolToFloat(recovery.RecoveryMode))
+	if !recovery.RecoveryMode {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["recovery_finished"],
+		prometheus.GaugeValue, boolToFloat(recovery.RecoveryFinished))
+	ch <- prometheus.MustNewConstMetric(c.metrics["recovery_progress"],
+		prometheus.GaugeValue, recovery.Progress)
+
+	now := time.Now()
+	if recovery.RecoveryFinished || (c.recoveryStart != nil && recovery.Progress < c.recoveryStart.progress) {
+		c.recoveryStart = nil
+	}
+	if recovery.RecoveryFinished {
+		return
+	}
+	if c.recoveryStart == nil {
+		c.recoveryStart = &recoverySample{at: now, progress: recovery.Progress}
+		return
+	}
+
+	done := recovery.Progress - c.recoveryStart.progress
+	if done <= 0 {
+		return
+	}
+	elapsed := now.Sub(c.recoveryStart.at)
+	remaining := time.Duration(float64(elapsed) * (1 - recovery.Progress) / done)
+	ch <- prometheus.MustNewConstMetric(c.metrics["recovery_completion"],
+		prometheus.GaugeValue, float64(now.Add(remaining).Unix()))
+}
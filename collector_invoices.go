@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// invoicePageSize is the number of invoices requested per ListInvoices
+// call.
+var invoicePageSize uint64 = 1000
+
+// invoiceStates are exported even when there are no invoices in them so
+// that their series don't disappear.
+var invoiceStates = []lnrpc.Invoice_InvoiceState{
+	lnrpc.Invoice_OPEN,
+	lnrpc.Invoice_SETTLED,
+	lnrpc.Invoice_CANCELED,
+	lnrpc.Invoice_ACCEPTED,
+}
+
+// invoicesCollector exports the number and amount of all invoices of the
+// node by state, paging through ListInvoices on every scrape.
+type invoicesCollector struct {
+	metrics map[string]*prometheus.Desc
+}
+
+func newInvoicesCollector(namespace string) *invoicesCollector {
+	return &invoicesCollector{
+		metrics: map[string]*prometheus.Desc{
+			"invoices":               newGlobalMetric(namespace, "invoices", "Number of invoices by state", []string{"state"}),
+			"invoices_value_msat":    newGlobalMetric(namespace, "invoices_value_msat", "Requested amount of the invoices by state", []string{"state"}),
+			"invoices_settled_msat":  newGlobalCounter(namespace, "invoices_settled_msat_total", "Amount paid to settled invoices", []string{}),
+			"invoices_settled_count": newGlobalCounter(namespace, "invoices_settled_total", "Number of settled invoices", []string{}),
+		},
+	}
+}
+
+func (c *invoicesCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *invoicesCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	counts := map[lnrpc.Invoice_InvoiceState]int{}
+	values := map[lnrpc.Invoice_InvoiceState]int64{}
+	var paidMsat int64
+
+	var offset uint64
+	for {
+		resp, err := s.client.ListInvoices(ctx, &lnrpc.ListInvoiceRequest{
+			IndexOffset:    offset,
+			NumMaxInvoices: invoicePageSize,
+		})
+		if err != nil {
+			log.Printf("s.client.ListInvoices err: %s", err)
+			return
+		}
+
+		for _, invoice := range resp.Invoices {
+			counts[invoice.State]++
+			values[invoice.State] += invoice.ValueMsat
+			if invoice.State == lnrpc.Invoice_SETTLED {
+				paidMsat += invoice.AmtPaidMsat
+			}
+		}
+
+		if uint64(len(resp.Invoices)) < invoicePageSize {
+			break
+		}
+		offset = resp.LastIndexOffset
+	}
+
+	for _, state := range invoiceStates {
+		label := strings.ToLower(state.String())
+		ch <- prometheus.MustNewConstMetric(c.metrics["invoices"],
+			prometheus.GaugeValue, float64(counts[state]), label)
+		ch <- prometheus.MustNewConstMetric(c.metrics["invoices_value_msat"],
+			prometheus.GaugeValue, float64(values[state]), label)
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["invoices_settled_msat"],
+		prometheus.CounterValue, float64(paidMsat))
+	ch <- prometheus.MustNewConstMetric(c.metrics["invoices_settled_count"],
+		prometheus.CounterValue, float64(counts[lnrpc.Invoice_SETTLED]))
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// missionControlCollector exports lnd's mission control, the payment
+// results pathfinding uses to avoid node pairs. The history is exported for
+// the pairs from our node to its peers only, as mission control tracks
+// pairs anywhere in the network.
+type missionControlCollector struct {
+	metrics  map[string]*prometheus.Desc
+	peerTags map[string]string
+}
+
+func newMissionControlCollector(namespace string, peerTags map[string]string) *missionControlCollector {
+	labels := []string{"remote_pubkey", "tag"}
+	return &missionControlCollector{
+		peerTags: peerTags,
+		metrics: map[string]*prometheus.Desc{
+			"mission_control_pairs":        newGlobalMetric(namespace, "mission_control_pairs", "Number of node pairs tracked by mission control, by whether the last payment attempt over them succeeded or failed", []string{"result"}),
+			"mission_control_success_msat": newGlobalMetric(namespace, "mission_control_peer_success_amount_msat", "Largest amount mission control last saw succeed from our node to the peer", labels),
+			"mission_control_fail_msat":    newGlobalMetric(namespace, "mission_control_peer_fail_amount_msat", "Smallest amount mission control last saw fail from our node to the peer", labels),
+			"mission_control_success_time": newGlobalMetric(namespace, "mission_control_peer_success_timestamp_seconds", "Unix time of the last successful payment attempt from our node to the peer", labels),
+			"mission_control_fail_time":    newGlobalMetric(namespace, "mission_control_peer_fail_timestamp_seconds", "Unix time of the last failed payment attempt from our node to the peer", labels),
+		},
+	}
+}
+
+func (c *missionControlCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *missionControlCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if s.router == nil {
+		return
+	}
+
+	mc, err := s.router.QueryMissionControl(ctx, &routerrpc.QueryMissionControlRequest{})
+	if err != nil {
+		log.Printf("s.router.QueryMissionControl err: %s", err)
+		return
+	}
+
+	results := map[string]int{"success": 0, "failure": 0}
+	for _, pair := range mc.Pairs {
+		history := pair.History
+		if history == nil {
+			continue
+		}
+		if history.FailTime > history.SuccessTime {
+			results["failure"]++
+		} else {
+			results["success"]++
+		}
+
+		if hex.EncodeToString(pair.NodeFrom) != s.info.IdentityPubkey {
+			continue
+		}
+		pubkey := hex.EncodeToString(pair.NodeTo)
+		lbls := []string{pubkey, c.peerTags[pubkey]}
+		if history.SuccessTime > 0 {
+			ch <- prometheus.MustNewConstMetric(c.metrics["mission_control_success_msat"],
+				prometheus.GaugeValue, float64(history.SuccessAmtMsat), lbls...)
+			ch <- prometheus.MustNewConstMetric(c.metrics["mission_control_success_time"],
+				prometheus.GaugeValue, float64(history.SuccessTime), lbls...)
+		}
+		if history.FailTime > 0 {
+			ch <- prometheus.MustNewConstMetric(c.metrics["mission_control_fail_msat"],
+				prometheus.GaugeValue, float64(history.FailAmtMsat), lbls...)
+			ch <- prometheus.MustNewConstMetric(c.metrics["mission_control_fail_time"],
+				prometheus.GaugeValue, float64(history.FailTime), lbls...)
+		}
+	}
+
+	for result, n := range results {
+		ch <- prometheus.MustNewConstMetric(c.metrics["mission_control_pairs"],
+			prometheus.GaugeValue, float64(n), result)
+	}
+}
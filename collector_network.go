@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// networkCollector exports the size of the network graph, the policies of
+// our channels in it and how recently their peers updated them.
+type networkCollector struct {
+	metrics map[string]*prometheus.Desc
+
+	// staleThreshold is the age of the peer's last channel update after
+	// which a channel is considered stale.
+	staleThreshold time.Duration
+	peerTags       map[string]string
+}
+
+func newNetworkCollector(namespace string, staleThreshold time.Duration, peerTags map[string]string) *networkCollector {
+	return &networkCollector{
+		staleThreshold: staleThreshold,
+		peerTags:       peerTags,
+
+		metrics: map[string]*prometheus.Desc{
+			"network_capacity_satoshis_total": newGlobalMetric(namespace, "network_capacity_satoshis_total", "network_capacity_satoshis_total", []string{}),
+			"network_channels_total":          newGlobalMetric(namespace, "network_channels_total", "network_channels_total", []string{}),
+			"network_nodes_total":             newGlobalMetric(namespace, "network_nodes_total", "network_nodes_total", []string{}),
+
+			"channels_by_peer_implementation": newGlobalMetric(namespace, "channels_by_peer_implementation", "Number of open channels by the peer's implementation (lnd, cln, eclair or unknown), guessed from its node announcement", []string{"implementation"}),
+			"channels_stale":                  newGlobalMetric(namespace, "channels_stale", "Number of channels whose peer hasn't updated its channel policy within the stale threshold", []string{}),
+			"channel_peer_last_update":        newGlobalMetric(namespace, "channel_peer_last_update_timestamp_seconds", "Unix time of the peer's last channel policy update in the graph", channelLabels),
+
+			"channel_fee_base_msat":   newGlobalMetric(namespace, "channel_fee_base_msat", "Base fee of the channel policy advertised by us (local) or the peer (remote)", append(channelLabels, "side")),
+			"channel_fee_rate_ppm":    newGlobalMetric(namespace, "channel_fee_rate_ppm", "Proportional fee rate of the channel policy advertised by us (local) or the peer (remote)", append(channelLabels, "side")),
+			"channel_time_lock_delta": newGlobalMetric(namespace, "channel_time_lock_delta", "Time lock delta of the channel policy advertised by us (local) or the peer (remote)", append(channelLabels, "side")),
+			"channel_disabled":        newGlobalMetric(namespace, "channel_disabled", "Whether the channel is disabled in the policy advertised by us (local) or the peer (remote)", append(channelLabels, "side")),
+		},
+	}
+}
+
+func (c *networkCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *networkCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if networkInfo, err := s.client.GetNetworkInfo(ctx, &lnrpc.NetworkInfoRequest{}); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.metrics["network_capacity_satoshis_total"],
+			prometheus.GaugeValue, float64(networkInfo.TotalNetworkCapacity))
+		ch <- prometheus.MustNewConstMetric(c.metrics["network_channels_total"],
+			prometheus.GaugeValue, float64(networkInfo.NumChannels))
+		ch <- prometheus.MustNewConstMetric(c.metrics["network_nodes_total"],
+			prometheus.GaugeValue, float64(networkInfo.NumNodes))
+	} else {
+		log.Printf("rpcClient.GetNetworkInfo err: %s", err)
+	}
+
+	if channels, err := s.listChannels(ctx); err == nil {
+		c.collectStaleChannels(ctx, ch, s.client, s.info.IdentityPubkey, channels)
+		c.collectPeerImplementations(ctx, ch, s.client, channels)
+	} else {
+		log.Printf("rpcClient.ListChannels err: %s", err)
+	}
+}
+
+// collectStaleChannels exports when the peer of each channel last updated
+// its channel policy in the graph and how many channels haven't seen an
+// update within staleThreshold, which is a sign of a dead peer.
+func (c *networkCollector) collectStaleChannels(ctx context.Context, ch chan<- prometheus.Metric, rpcClient lnrpc.LightningClient, ownPubkey string, channels []*lnrpc.Channel) {
+	stale := 0
+	for _, channel := range channels {
+		edge, err := rpcClient.GetChanInfo(ctx, &lnrpc.ChanInfoRequest{ChanId: channel.ChanId})
+		if err != nil {
+			log.Printf("rpcClient.GetChanInfo(%d) err: %s", channel.ChanId, err)
+			continue
+		}
+
+		ownPolicy, peerPolicy := edge.Node2Policy, edge.Node1Policy
+		if edge.Node1Pub == ownPubkey {
+			ownPolicy, peerPolicy = edge.Node1Policy, edge.Node2Policy
+		}
+
+		lbls := []string{
+			strconv.FormatUint(channel.ChanId, 10),
+			channel.ChannelPoint,
+			channel.RemotePubkey,
+			c.peerTags[channel.RemotePubkey],
+		}
+		c.collectPolicy(ch, ownPolicy, append(lbls[:len(lbls):len(lbls)], "local"))
+		c.collectPolicy(ch, peerPolicy, append(lbls[:len(lbls):len(lbls)], "remote"))
+
+		var lastUpdate time.Time
+		if peerPolicy != nil && peerPolicy.LastUpdate > 0 {
+			lastUpdate = time.Unix(int64(peerPolicy.LastUpdate), 0)
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_peer_last_update"],
+				prometheus.GaugeValue, float64(lastUpdate.Unix()), lbls...)
+		}
+
+		// A peer that never announced a policy counts as stale as well.
+		if time.Since(lastUpdate) > c.staleThreshold {
+			stale++
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["channels_stale"],
+		prometheus.GaugeValue, float64(stale))
+}
+
+// collectPeerImplementations exports the number of channels by the
+// implementation of their peer, see peerImplementation.
+func (c *networkCollector) collectPeerImplementations(ctx context.Context, ch chan<- prometheus.Metric, rpcClient lnrpc.LightningClient, channels []*lnrpc.Channel) {
+	implementations := map[string]string{}
+	counts := map[string]int{"lnd": 0, "cln": 0, "eclair": 0, "unknown": 0}
+	for _, channel := range channels {
+		implementation, ok := implementations[channel.RemotePubkey]
+		if !ok {
+			info, err := rpcClient.GetNodeInfo(ctx, &lnrpc.NodeInfoRequest{PubKey: channel.RemotePubkey})
+			if err != nil {
+				log.Printf("rpcClient.GetNodeInfo(%s) err: %s", channel.RemotePubkey, err)
+			}
+			implementation = peerImplementation(info.GetNode())
+			implementations[channel.RemotePubkey] = implementation
+		}
+		counts[implementation]++
+	}
+
+	for implementation, n := range counts {
+		ch <- prometheus.MustNewConstMetric(c.metrics["channels_by_peer_implementation"],
+			prometheus.GaugeValue, float64(n), implementation)
+	}
+}
+
+// collectPolicy exports a channel policy from the graph, if it was
+// announced.
+func (c *networkCollector) collectPolicy(ch chan<- prometheus.Metric, policy *lnrpc.RoutingPolicy, lbls []string) {
+	if policy == nil {
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["channel_fee_base_msat"],
+		prometheus.GaugeValue, float64(policy.FeeBaseMsat), lbls...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["channel_fee_rate_ppm"],
+		prometheus.GaugeValue, float64(policy.FeeRateMilliMsat), lbls...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["channel_time_lock_delta"],
+		prometheus.GaugeValue, float64(policy.TimeLockDelta), lbls...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["channel_disabled"],
+		prometheus.GaugeValue, boolToFloat(policy.Disabled), lbls...)
+}
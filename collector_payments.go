@@ -0,0 +1,163 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// paymentPageSize is the number of payments requested per ListPayments
+// call.
+var paymentPageSize uint64 = 1000
+
+// paymentStuckAge is how long a payment may be in flight before the offset
+// moves past it, so that a stuck payment doesn't pin it.
+var paymentStuckAge = 24 * time.Hour
+
+// paymentsCollector exports counters of the payments sent by the node.
+// Payments are read incrementally with ListPayments, each payment is
+// counted once it succeeded or failed.
+type paymentsCollector struct {
+	metrics map[string]*prometheus.Desc
+
+	// offset is the payment index up to which all payments are final and
+	// counted, counted holds the final payments after it that were counted
+	// while an earlier one was still in flight.
+	offset  uint64
+	counted map[uint64]bool
+
+	// stuck holds the payments before offset that were in flight for
+	// longer than paymentStuckAge, they are looked up one by one until
+	// they are final.
+	stuck map[uint64]bool
+
+	payments map[lnrpc.Payment_PaymentStatus]uint64
+	sentMsat uint64
+	feesMsat uint64
+}
+
+func newPaymentsCollector(namespace string) *paymentsCollector {
+	return &paymentsCollector{
+		counted: map[uint64]bool{},
+		stuck:   map[uint64]bool{},
+		payments: map[lnrpc.Payment_PaymentStatus]uint64{
+			lnrpc.Payment_SUCCEEDED: 0,
+			lnrpc.Payment_FAILED:    0,
+		},
+
+		metrics: map[string]*prometheus.Desc{
+			"payments_total":               newGlobalCounter(namespace, "payments_total", "Number of sent payments by final status (succeeded, failed)", []string{"status"}),
+			"payments_sent_msat_total":     newGlobalCounter(namespace, "payments_sent_msat_total", "Amount sent with succeeded payments, excluding fees", []string{}),
+			"payment_fees_paid_msat_total": newGlobalCounter(namespace, "payment_fees_paid_msat_total", "Routing fees paid for succeeded payments", []string{}),
+		},
+	}
+}
+
+func (c *paymentsCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *paymentsCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if err := c.update(ctx, s); err != nil {
+		log.Printf("updating payment counters err: %s", err)
+	}
+
+	for status, n := range c.payments {
+		ch <- prometheus.MustNewConstMetric(c.metrics["payments_total"],
+			prometheus.CounterValue, float64(n), strings.ToLower(status.String()))
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["payments_sent_msat_total"],
+		prometheus.CounterValue, float64(c.sentMsat))
+	ch <- prometheus.MustNewConstMetric(c.metrics["payment_fees_paid_msat_total"],
+		prometheus.CounterValue, float64(c.feesMsat))
+}
+
+// update counts the payments that became final since the last update.
+// Progress is kept page by page, so a scrape that runs out of time
+// continues where it stopped on the next one.
+func (c *paymentsCollector) update(ctx context.Context, s *scrape) error {
+	if err := c.updateStuck(ctx, s); err != nil {
+		return err
+	}
+
+	indexOffset := c.offset
+	final := true
+	for {
+		resp, err := s.client.ListPayments(ctx, &lnrpc.ListPaymentsRequest{
+			IncludeIncomplete: true,
+			IndexOffset:       indexOffset,
+			MaxPayments:       paymentPageSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, payment := range resp.Payments {
+			if payment.Status != lnrpc.Payment_SUCCEEDED && payment.Status != lnrpc.Payment_FAILED {
+				if final && time.Since(time.Unix(0, payment.CreationTimeNs)) > paymentStuckAge {
+					c.stuck[payment.PaymentIndex] = true
+					c.offset = payment.PaymentIndex
+					continue
+				}
+				final = false
+				continue
+			}
+			if !c.counted[payment.PaymentIndex] {
+				c.count(payment)
+			}
+
+			// Move the offset past the payments that are final, the
+			// ones after an in-flight payment are read again on the
+			// next update and must not be counted twice.
+			if final {
+				c.offset = payment.PaymentIndex
+				delete(c.counted, payment.PaymentIndex)
+			} else {
+				c.counted[payment.PaymentIndex] = true
+			}
+		}
+
+		if uint64(len(resp.Payments)) < paymentPageSize {
+			return nil
+		}
+		indexOffset = resp.LastIndexOffset
+	}
+}
+
+// updateStuck counts the stuck payments that became final.
+func (c *paymentsCollector) updateStuck(ctx context.Context, s *scrape) error {
+	for index := range c.stuck {
+		resp, err := s.client.ListPayments(ctx, &lnrpc.ListPaymentsRequest{
+			IncludeIncomplete: true,
+			IndexOffset:       index - 1,
+			MaxPayments:       1,
+		})
+		if err != nil {
+			return err
+		}
+
+		// The payment is gone if it was deleted.
+		if len(resp.Payments) == 0 || resp.Payments[0].PaymentIndex != index {
+			delete(c.stuck, index)
+			continue
+		}
+		payment := resp.Payments[0]
+		if payment.Status == lnrpc.Payment_SUCCEEDED || payment.Status == lnrpc.Payment_FAILED {
+			c.count(payment)
+			delete(c.stuck, index)
+		}
+	}
+	return nil
+}
+
+func (c *paymentsCollector) count(payment *lnrpc.Payment) {
+	c.payments[payment.Status]++
+	if payment.Status == lnrpc.Payment_SUCCEEDED {
+		c.sentMsat += uint64(payment.ValueMsat)
+		c.feesMsat += uint64(payment.FeeMsat)
+	}
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// closeFeatures are the feature bits, as optional/required pairs, that
+// decide how a channel can be closed cooperatively.
+var closeFeatures = map[string][2]uint32{
+	// The peer accepts a close address committed to when the channel was
+	// opened.
+	"upfront_shutdown_script": {4, 5},
+	// The peer accepts closing to any segwit version, e.g. a taproot cold
+	// wallet address.
+	"shutdown_any_segwit": {26, 27},
+}
+
+// peersCollector exports the connected peers and their traffic.
+type peersCollector struct {
+	metrics      map[string]*prometheus.Desc
+	peerTags     map[string]string
+	scoreWeights map[string]float64
+
+	// geoIP classifies clearnet peers by country and AS if configured.
+	geoIP *geoIP
+}
+
+func newPeersCollector(namespace string, peerTags map[string]string, scoreWeights map[string]float64, geoIP *geoIP) *peersCollector {
+	return &peersCollector{
+		peerTags:     peerTags,
+		scoreWeights: scoreWeights,
+		geoIP:        geoIP,
+		metrics: map[string]*prometheus.Desc{
+			"peers_by_country":               newGlobalMetric(namespace, "peers_by_country", "Number of connected clearnet peers by country of their IP address", []string{"country"}),
+			"peers_by_asn":                   newGlobalMetric(namespace, "peers_by_asn", "Number of connected clearnet peers by autonomous system of their IP address", []string{"asn", "as_org"}),
+			"peer_stability_score":           newGlobalMetric(namespace, "peer_stability_score", "Stability of the channel peer from 0 (unstable) to 1 (stable), combining flaps, ping time, channel uptime and recent HTLC failures", []string{"remote_pubkey", "tag"}),
+			"channel_peer_close_feature":     newGlobalMetric(namespace, "channel_peer_close_feature", "Whether the connected channel peer advertises the close related feature", append(channelLabels, "feature")),
+			"channel_upfront_shutdown":       newGlobalMetric(namespace, "channel_upfront_shutdown_address_set", "Whether the channel commits to a close address set when it was opened", channelLabels),
+			"peer_info":                      newGlobalMetric(namespace, "peer_info", "peer_info", []string{"addr", "remote_pubkey", "direction"}),
+			"peer_info_received_bytes_total": newGlobalCounter(namespace, "peer_info_received_bytes_total", "peer_info_received_bytes_total", []string{"addr"}),
+			"peer_info_sent_bytes_total":     newGlobalCounter(namespace, "peer_info_sent_bytes_total", "peer_info_sent_bytes_total", []string{"addr"}),
+			"peer_ping_time_seconds":         newGlobalMetric(namespace, "peer_ping_time_seconds", "Ping time to the connected peer", []string{"remote_pubkey", "tag"}),
+			"peer_sent_satoshis_total":       newGlobalCounter(namespace, "peer_sent_satoshis_total", "Satoshis sent to the connected peer", []string{"remote_pubkey", "tag"}),
+			"peer_received_satoshis_total":   newGlobalCounter(namespace, "peer_received_satoshis_total", "Satoshis received from the connected peer", []string{"remote_pubkey", "tag"}),
+			"peer_flaps_total":               newGlobalCounter(namespace, "peer_flaps_total", "Number of times lnd saw the peer disconnect and reconnect", []string{"remote_pubkey", "tag"}),
+			"peer_last_flap_timestamp":       newGlobalMetric(namespace, "peer_last_flap_timestamp_seconds", "Unix time the peer last disconnected or reconnected", []string{"remote_pubkey", "tag"}),
+			"peer_errors":                    newGlobalMetric(namespace, "peer_errors", "Number of recent errors from the peer kept by lnd", []string{"remote_pubkey", "tag"}),
+			"peer_last_error_timestamp":      newGlobalMetric(namespace, "peer_last_error_timestamp_seconds", "Unix time of the most recent error from the peer", []string{"remote_pubkey", "tag"}),
+			"peer_feature":                   newGlobalMetric(namespace, "peer_feature", "Feature bits advertised by the connected peer, feature is empty for bits unknown to lnd", []string{"remote_pubkey", "tag", "bit", "feature", "required"}),
+			"peer_sync_type":                 newGlobalMetric(namespace, "peer_sync_type", "The graph sync type of the connected peer", []string{"remote_pubkey", "tag", "sync_type"}),
+		},
+	}
+}
+
+func (c *peersCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *peersCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	peers, err := s.client.ListPeers(ctx, &lnrpc.ListPeersRequest{})
+	if err != nil {
+		log.Printf("rpcClient.ListPeers err: %s", err)
+		return
+	}
+
+	for _, peer := range peers.GetPeers() {
+		dir := "outbound"
+		if peer.Inbound {
+			dir = "inbound"
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_info"],
+			prometheus.GaugeValue, 1.0,
+			peer.Address,
+			peer.PubKey,
+			dir)
+
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_info_received_bytes_total"],
+			prometheus.CounterValue, float64(peer.BytesRecv), peer.Address)
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_info_sent_bytes_total"],
+			prometheus.CounterValue, float64(peer.BytesSent), peer.Address)
+
+		c.collectPeer(ch, peer)
+	}
+	if c.geoIP != nil {
+		c.collectGeoIP(ch, peers.GetPeers())
+	}
+
+	channels, err := s.listChannels(ctx)
+	if err != nil {
+		log.Printf("rpcClient.ListChannels err: %s", err)
+		return
+	}
+	c.collectCloseFeatures(ch, peers.GetPeers(), channels)
+	c.collectStabilityScores(ch, peers.GetPeers(), channels)
+}
+
+// collectPeer exports the connection quality and misbehaviour of the peer.
+func (c *peersCollector) collectPeer(ch chan<- prometheus.Metric, peer *lnrpc.Peer) {
+	lbls := []string{peer.PubKey, c.peerTags[peer.PubKey]}
+
+	// lnd reports the ping time in microseconds.
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_ping_time_seconds"],
+		prometheus.GaugeValue, float64(peer.PingTime)/1e6, lbls...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_sent_satoshis_total"],
+		prometheus.CounterValue, float64(peer.SatSent), lbls...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_received_satoshis_total"],
+		prometheus.CounterValue, float64(peer.SatRecv), lbls...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_flaps_total"],
+		prometheus.CounterValue, float64(peer.FlapCount), lbls...)
+	if peer.LastFlapNs > 0 {
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_last_flap_timestamp"],
+			prometheus.GaugeValue, float64(peer.LastFlapNs)/1e9, lbls...)
+	}
+
+	var lastError uint64
+	for _, e := range peer.Errors {
+		if e.Timestamp > lastError {
+			lastError = e.Timestamp
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_errors"],
+		prometheus.GaugeValue, float64(len(peer.Errors)), lbls...)
+	if lastError > 0 {
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_last_error_timestamp"],
+			prometheus.GaugeValue, float64(lastError), lbls...)
+	}
+
+	for bit, feature := range peer.Features {
+		name := ""
+		if feature.IsKnown {
+			name = feature.Name
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_feature"],
+			prometheus.GaugeValue, 1.0,
+			append(lbls[:len(lbls):len(lbls)], strconv.FormatUint(uint64(bit), 10), name, strconv.FormatBool(feature.IsRequired))...)
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_sync_type"],
+		prometheus.GaugeValue, 1.0,
+		append(lbls, strings.ToLower(strings.TrimSuffix(peer.SyncType.String(), "_SYNC")))...)
+}
+
+// collectGeoIP exports the number of clearnet peers by country and AS,
+// without per peer labels.
+func (c *peersCollector) collectGeoIP(ch chan<- prometheus.Metric, peers []*lnrpc.Peer) {
+	type as struct{ number, org string }
+	countries := map[string]int{}
+	systems := map[as]int{}
+	for _, peer := range peers {
+		ip := peerIP(peer.Address)
+		if ip == nil {
+			continue
+		}
+		if c.geoIP.country != nil {
+			country := c.geoIP.countryCode(ip)
+			if country == "" {
+				country = "unknown"
+			}
+			countries[country]++
+		}
+		if c.geoIP.asn != nil {
+			number, org := c.geoIP.as(ip)
+			if number == "" {
+				number = "unknown"
+			}
+			systems[as{number, org}]++
+		}
+	}
+
+	for country, n := range countries {
+		ch <- prometheus.MustNewConstMetric(c.metrics["peers_by_country"],
+			prometheus.GaugeValue, float64(n), country)
+	}
+	for system, n := range systems {
+		ch <- prometheus.MustNewConstMetric(c.metrics["peers_by_asn"],
+			prometheus.GaugeValue, float64(n), system.number, system.org)
+	}
+}
+
+// collectCloseFeatures exports for each channel which close related
+// features its peer supports, so that channels that can be closed to a cold
+// wallet directly can be told apart. Features are only known for connected
+// peers.
+func (c *peersCollector) collectCloseFeatures(ch chan<- prometheus.Metric, peers []*lnrpc.Peer, channels []*lnrpc.Channel) {
+	features := map[string]map[uint32]*lnrpc.Feature{}
+	for _, peer := range peers {
+		features[peer.PubKey] = peer.Features
+	}
+
+	for _, channel := range channels {
+		lbls := []string{
+			strconv.FormatUint(channel.ChanId, 10),
+			channel.ChannelPoint,
+			channel.RemotePubkey,
+			c.peerTags[channel.RemotePubkey],
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_upfront_shutdown"],
+			prometheus.GaugeValue, boolToFloat(channel.CloseAddress != ""), lbls...)
+
+		peerFeatures, ok := features[channel.RemotePubkey]
+		if !ok {
+			continue
+		}
+		for name, bits := range closeFeatures {
+			_, optional := peerFeatures[bits[0]]
+			_, required := peerFeatures[bits[1]]
+			ch <- prometheus.MustNewConstMetric(c.metrics["channel_peer_close_feature"],
+				prometheus.GaugeValue, boolToFloat(optional || required),
+				append(lbls[:len(lbls):len(lbls)], name)...)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"strconv"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// rebalanceWeights weigh what makes restoring a channel's balance
+// worthwhile, set with -rebalance.weights.
+var rebalanceWeights = map[string]float64{
+	"velocity":     1,
+	"fee_yield":    1,
+	"peer_quality": 1,
+}
+
+// rebalanceScales are the values of the components at which they count as
+// half, e.g. routing a tenth of the capacity per day or a fee rate of 500
+// ppm.
+var rebalanceScales = map[string]float64{
+	"velocity":  0.1,
+	"fee_yield": 500,
+}
+
+// parseRebalanceWeights parses weights in the form "velocity=1,...",
+// components that are not given keep their weight.
+func parseRebalanceWeights(s string) (map[string]float64, error) {
+	return parseWeights(s, rebalanceWeights)
+}
+
+// rebalanceCollector exports per channel heuristics for automation that
+// rebalances channels or adjusts their fees.
+type rebalanceCollector struct {
+	metrics map[string]*prometheus.Desc
+
+	peerTags         map[string]string
+	weights          map[string]float64
+	scoreWeights     map[string]float64
+	maxFeeAdjustment float64
+}
+
+func newRebalanceCollector(namespace string, peerTags map[string]string, weights map[string]float64, scoreWeights map[string]float64, maxFeeAdjustment float64) *rebalanceCollector {
+	return &rebalanceCollector{
+		peerTags:         peerTags,
+		weights:          weights,
+		scoreWeights:     scoreWeights,
+		maxFeeAdjustment: maxFeeAdjustment,
+		metrics: map[string]*prometheus.Desc{
+			"channel_rebalance_priority":        newGlobalMetric(namespace, "channel_rebalance_priority", "How worthwhile rebalancing the channel is from 0 to 1, its imbalance times the weighted mean of routing velocity, fee yield and peer quality", channelLabels),
+			"channel_fee_adjustment_suggestion": newGlobalMetric(namespace, "channel_fee_adjustment_suggestion_ppm", "Suggested change of the channel's fee rate, positive when the local balance is depleted and negative when it is in excess", channelLabels),
+		},
+	}
+}
+
+func (c *rebalanceCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *rebalanceCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	channels, err := s.listChannels(ctx)
+	if err != nil {
+		log.Printf("s.client.ListChannels err: %s", err)
+		return
+	}
+	peers, err := s.client.ListPeers(ctx, &lnrpc.ListPeersRequest{})
+	if err != nil {
+		log.Printf("s.client.ListPeers err: %s", err)
+		return
+	}
+	feeReport, err := s.client.FeeReport(ctx, &lnrpc.FeeReportRequest{})
+	if err != nil {
+		log.Printf("s.client.FeeReport err: %s", err)
+		return
+	}
+
+	feeRates := map[uint64]int64{}
+	for _, fee := range feeReport.ChannelFees {
+		feeRates[fee.ChanId] = fee.FeePerMil
+	}
+	scores := peerScores(peers.GetPeers(), channels)
+
+	for _, channel := range channels {
+		if channel.LocalBalance+channel.RemoteBalance == 0 {
+			continue
+		}
+		lbls := []string{
+			strconv.FormatUint(channel.ChanId, 10),
+			channel.ChannelPoint,
+			channel.RemotePubkey,
+			c.peerTags[channel.RemotePubkey],
+		}
+
+		// localRatio is 0 when all funds are on the remote side.
+		localRatio := float64(channel.LocalBalance) / float64(channel.LocalBalance+channel.RemoteBalance)
+		imbalance := math.Abs(localRatio-0.5) * 2
+
+		var velocity float64
+		if days := float64(channel.Lifetime) / 86400; days > 0 && channel.Capacity > 0 {
+			velocity = float64(channel.TotalSatoshisSent+channel.TotalSatoshisReceived) / float64(channel.Capacity) / days
+		}
+		components := map[string]float64{
+			"velocity":     saturate(velocity, rebalanceScales["velocity"]),
+			"fee_yield":    saturate(float64(feeRates[channel.ChanId]), rebalanceScales["fee_yield"]),
+			"peer_quality": scores[channel.RemotePubkey].score(c.scoreWeights),
+		}
+
+		var sum, total float64
+		for component, w := range c.weights {
+			sum += w * components[component]
+			total += w
+		}
+		priority := 0.0
+		if total > 0 {
+			priority = imbalance * sum / total
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_rebalance_priority"],
+			prometheus.GaugeValue, priority, lbls...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_fee_adjustment_suggestion"],
+			prometheus.GaugeValue, math.Round(float64(feeRates[channel.ChanId])*c.maxFeeAdjustment*(0.5-localRatio)*2), lbls...)
+	}
+}
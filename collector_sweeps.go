@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// sweepsCollector exports the outputs lnd's sweeper is trying to spend back
+// into the wallet, e.g. anchors and the outputs of force closed channels.
+// Sweeps that keep failing to confirm show up as growing broadcast attempts.
+type sweepsCollector struct {
+	metrics map[string]*prometheus.Desc
+}
+
+func newSweepsCollector(namespace string) *sweepsCollector {
+	labels := []string{"outpoint", "witness_type"}
+	return &sweepsCollector{
+		metrics: map[string]*prometheus.Desc{
+			"pending_sweeps":                   newGlobalMetric(namespace, "pending_sweeps", "Number of outputs waiting to be swept by witness type", []string{"witness_type"}),
+			"pending_sweeps_value":             newGlobalMetric(namespace, "pending_sweeps_value_satoshis", "Value of the outputs waiting to be swept by witness type", []string{"witness_type"}),
+			"pending_sweep_broadcast_attempts": newGlobalMetric(namespace, "pending_sweep_broadcast_attempts", "Number of times a sweep of the output was broadcast", labels),
+			"pending_sweep_next_broadcast":     newGlobalMetric(namespace, "pending_sweep_next_broadcast_height", "Block height at which the sweep of the output is broadcast next", labels),
+			"pending_sweep_fee_rate":           newGlobalMetric(namespace, "pending_sweep_fee_rate_sat_per_vbyte", "Fee rate of the last broadcast sweep of the output", labels),
+		},
+	}
+}
+
+func (c *sweepsCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *sweepsCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if s.walletKit == nil {
+		return
+	}
+
+	resp, err := s.walletKit.PendingSweeps(ctx, &walletrpc.PendingSweepsRequest{})
+	if err != nil {
+		log.Printf("s.walletKit.PendingSweeps err: %s", err)
+		return
+	}
+
+	counts := map[string]int{}
+	values := map[string]int64{}
+	for _, sweep := range resp.PendingSweeps {
+		witnessType := strings.ToLower(sweep.WitnessType.String())
+		counts[witnessType]++
+		values[witnessType] += int64(sweep.AmountSat)
+
+		outpoint := fmt.Sprintf("%s:%d", sweep.Outpoint.GetTxidStr(), sweep.Outpoint.GetOutputIndex())
+		ch <- prometheus.MustNewConstMetric(c.metrics["pending_sweep_broadcast_attempts"],
+			prometheus.GaugeValue, float64(sweep.BroadcastAttempts), outpoint, witnessType)
+		ch <- prometheus.MustNewConstMetric(c.metrics["pending_sweep_next_broadcast"],
+			prometheus.GaugeValue, float64(sweep.NextBroadcastHeight), outpoint, witnessType)
+		ch <- prometheus.MustNewConstMetric(c.metrics["pending_sweep_fee_rate"],
+			prometheus.GaugeValue, float64(sweep.SatPerVbyte), outpoint, witnessType)
+	}
+
+	for witnessType, count := range counts {
+		ch <- prometheus.MustNewConstMetric(c.metrics["pending_sweeps"],
+			prometheus.GaugeValue, float64(count), witnessType)
+		ch <- prometheus.MustNewConstMetric(c.metrics["pending_sweeps_value"],
+			prometheus.GaugeValue, float64(values[witnessType]), witnessType)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"log"
+	"math"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// onchainFeesCheckpoint is the block height up to which the fees of wallet
+// transactions were added up, persisted in the state store with the sums so
+// that the counter stays monotonic across restarts.
+type onchainFeesCheckpoint struct {
+	Height  int32            `json:"height"`
+	FeesSat map[string]int64 `json:"fees_sat"`
+}
+
+// utxoAddressTypes are the address types of wallet outputs, exported even
+// when the wallet has no outputs of a type so that their series don't
+// disappear.
+var utxoAddressTypes = []lnrpc.AddressType{
+	lnrpc.AddressType_WITNESS_PUBKEY_HASH,
+	lnrpc.AddressType_NESTED_PUBKEY_HASH,
+	lnrpc.AddressType_TAPROOT_PUBKEY,
+}
+
+// txTypes are the transaction types lnd labels its transactions with, see
+// txType, whose fee counters start at zero.
+var txTypes = []string{"openchannel", "closechannel", "justicetx", "sweep", "other"}
+
+// walletCollector exports the on-chain wallet balance and UTXOs.
+type walletCollector struct {
+	metrics map[string]*prometheus.Desc
+
+	fees     onchainFeesCheckpoint
+	state    *stateStore
+	stateKey string
+}
+
+func newWalletCollector(namespace string, rpcAddr string, state *stateStore) *walletCollector {
+	c := &walletCollector{
+		fees:     onchainFeesCheckpoint{FeesSat: map[string]int64{}},
+		state:    state,
+		stateKey: "onchain_fees/" + rpcAddr,
+
+		metrics: map[string]*prometheus.Desc{
+			"onchain_fees_paid_sats_total": newGlobalCounter(namespace, "onchain_fees_paid_sats_total", "On-chain fees paid by confirmed wallet transactions, by transaction type from lnd's transaction label (e.g. openchannel, closechannel, sweep) or other", []string{"tx_type"}),
+			"wallet_balance_satoshis":      newGlobalMetric(namespace, "wallet_balance_satoshis", "The wallet balance by status, locked (leased outputs) and reserved_anchor (kept for fee bumping anchor channels) overlap with confirmed and must not be summed with it.", []string{"status"}),
+			"utxos_by_address_type":        newGlobalMetric(namespace, "utxos_by_address_type", "Number of wallet UTXOs by address type", []string{"address_type"}),
+			"utxos":                        newGlobalMetric(namespace, "utxos", "Number of wallet UTXOs by confirmation status and address type", []string{"status", "address_type"}),
+			"utxos_value_satoshis":         newGlobalMetric(namespace, "utxos_value_satoshis", "Value of the wallet UTXOs by confirmation status and address type", []string{"status", "address_type"}),
+		},
+	}
+
+	if _, err := state.get(c.stateKey, &c.fees); err != nil {
+		log.Printf("invalid on-chain fees checkpoint, adding up all transactions again: %s", err)
+	}
+	if c.fees.FeesSat == nil {
+		c.fees.FeesSat = map[string]int64{}
+	}
+	for _, txType := range txTypes {
+		if _, ok := c.fees.FeesSat[txType]; !ok {
+			c.fees.FeesSat[txType] = 0
+		}
+	}
+	return c
+}
+
+func (c *walletCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *walletCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if walletStats, err := s.client.WalletBalance(ctx, &lnrpc.WalletBalanceRequest{}); err == nil {
+		ch <- prometheus.MustNewConstMetric(c.metrics["wallet_balance_satoshis"],
+			prometheus.GaugeValue, float64(walletStats.UnconfirmedBalance), "unconfirmed")
+		ch <- prometheus.MustNewConstMetric(c.metrics["wallet_balance_satoshis"],
+			prometheus.GaugeValue, float64(walletStats.ConfirmedBalance), "confirmed")
+		ch <- prometheus.MustNewConstMetric(c.metrics["wallet_balance_satoshis"],
+			prometheus.GaugeValue, float64(walletStats.LockedBalance), "locked")
+		ch <- prometheus.MustNewConstMetric(c.metrics["wallet_balance_satoshis"],
+			prometheus.GaugeValue, float64(walletStats.ReservedBalanceAnchorChan), "reserved_anchor")
+	} else {
+		log.Printf("s.client.GetWalletStats err: %s", err)
+	}
+
+	if utxos, err := s.client.ListUnspent(ctx, &lnrpc.ListUnspentRequest{MaxConfs: math.MaxInt32}); err == nil {
+		type utxoGroup struct{ status, addressType string }
+		addressTypes := map[string]int{}
+		counts := map[utxoGroup]int{}
+		values := map[utxoGroup]int64{}
+		for _, addressType := range utxoAddressTypes {
+			label := strings.ToLower(addressType.String())
+			addressTypes[label] = 0
+			for _, status := range []string{"confirmed", "unconfirmed"} {
+				counts[utxoGroup{status: status, addressType: label}] = 0
+			}
+		}
+		for _, utxo := range utxos.Utxos {
+			addressType := strings.ToLower(utxo.AddressType.String())
+			addressTypes[addressType]++
+
+			group := utxoGroup{status: "confirmed", addressType: addressType}
+			if utxo.Confirmations == 0 {
+				group.status = "unconfirmed"
+			}
+			counts[group]++
+			values[group] += utxo.AmountSat
+		}
+		for addressType, n := range addressTypes {
+			ch <- prometheus.MustNewConstMetric(c.metrics["utxos_by_address_type"],
+				prometheus.GaugeValue, float64(n), addressType)
+		}
+		for group, n := range counts {
+			ch <- prometheus.MustNewConstMetric(c.metrics["utxos"],
+				prometheus.GaugeValue, float64(n), group.status, group.addressType)
+			ch <- prometheus.MustNewConstMetric(c.metrics["utxos_value_satoshis"],
+				prometheus.GaugeValue, float64(values[group]), group.status, group.addressType)
+		}
+	} else {
+		log.Printf("s.client.ListUnspent err: %s", err)
+	}
+
+	if err := c.updateFees(ctx, s); err != nil {
+		log.Printf("updating on-chain fees err: %s", err)
+	}
+	for txType, fees := range c.fees.FeesSat {
+		ch <- prometheus.MustNewConstMetric(c.metrics["onchain_fees_paid_sats_total"],
+			prometheus.CounterValue, float64(fees), txType)
+	}
+}
+
+// updateFees adds the fees of the wallet transactions confirmed since the
+// last update. lnd only reports fees of transactions the wallet funded.
+func (c *walletCollector) updateFees(ctx context.Context, s *scrape) error {
+	height := int32(s.info.BlockHeight)
+	if height <= c.fees.Height {
+		return nil
+	}
+
+	txs, err := s.client.GetTransactions(ctx, &lnrpc.GetTransactionsRequest{
+		StartHeight: c.fees.Height + 1,
+		EndHeight:   height,
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, tx := range txs.Transactions {
+		if tx.BlockHeight <= c.fees.Height || tx.BlockHeight > height || tx.TotalFees == 0 {
+			continue
+		}
+		c.fees.FeesSat[txType(tx.Label)] += tx.TotalFees
+	}
+	c.fees.Height = height
+	return c.state.put(c.stateKey, c.fees)
+}
+
+// txType returns the type of a transaction from lnd's label in the format
+// "0:<type>:<details>", or other for unlabeled transactions.
+func txType(label string) string {
+	parts := strings.SplitN(label, ":", 3)
+	if len(parts) < 2 || parts[0] != "0" || parts[1] == "" {
+		return "other"
+	}
+	return parts[1]
+}
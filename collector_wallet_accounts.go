@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/walletrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// walletAccountsCollector exports the on-chain balance per wallet account,
+// e.g. the default and imported accounts. lnd reports the confirmed and
+// unconfirmed balance by account name only, the balance by address type is
+// summed up from the addresses of each account.
+type walletAccountsCollector struct {
+	metrics map[string]*prometheus.Desc
+}
+
+func newWalletAccountsCollector(namespace string) *walletAccountsCollector {
+	return &walletAccountsCollector{
+		metrics: map[string]*prometheus.Desc{
+			"wallet_account_balance":         newGlobalMetric(namespace, "wallet_account_balance_satoshis", "The wallet balance of an account by status", []string{"account", "status"}),
+			"wallet_account_address_balance": newGlobalMetric(namespace, "wallet_account_address_balance_satoshis", "Sum of the balances of an account's addresses of an address type", []string{"account", "address_type"}),
+			"wallet_account_addresses":       newGlobalMetric(namespace, "wallet_account_addresses", "Number of addresses of an account of an address type", []string{"account", "address_type"}),
+			"wallet_account_info":            newGlobalMetric(namespace, "wallet_account_info", "Wallet accounts and whether they are watch only", []string{"account", "address_type", "watch_only"}),
+		},
+	}
+}
+
+func (c *walletAccountsCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *walletAccountsCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if balance, err := s.client.WalletBalance(ctx, &lnrpc.WalletBalanceRequest{}); err == nil {
+		for account, b := range balance.AccountBalance {
+			ch <- prometheus.MustNewConstMetric(c.metrics["wallet_account_balance"],
+				prometheus.GaugeValue, float64(b.ConfirmedBalance), account, "confirmed")
+			ch <- prometheus.MustNewConstMetric(c.metrics["wallet_account_balance"],
+				prometheus.GaugeValue, float64(b.UnconfirmedBalance), account, "unconfirmed")
+		}
+	} else {
+		log.Printf("s.client.WalletBalance err: %s", err)
+	}
+
+	if s.walletKit == nil {
+		return
+	}
+
+	if accounts, err := s.walletKit.ListAccounts(ctx, &walletrpc.ListAccountsRequest{}); err == nil {
+		for _, account := range accounts.Accounts {
+			ch <- prometheus.MustNewConstMetric(c.metrics["wallet_account_info"],
+				prometheus.GaugeValue, 1,
+				account.Name, strings.ToLower(account.AddressType.String()), strconv.FormatBool(account.WatchOnly))
+		}
+	} else {
+		log.Printf("s.walletKit.ListAccounts err: %s", err)
+	}
+
+	if addresses, err := s.walletKit.ListAddresses(ctx, &walletrpc.ListAddressesRequest{ShowCustomAccounts: true}); err == nil {
+		for _, account := range addresses.AccountWithAddresses {
+			var balance int64
+			for _, address := range account.Addresses {
+				balance += address.Balance
+			}
+			addressType := strings.ToLower(account.AddressType.String())
+			ch <- prometheus.MustNewConstMetric(c.metrics["wallet_account_address_balance"],
+				prometheus.GaugeValue, float64(balance), account.Name, addressType)
+			ch <- prometheus.MustNewConstMetric(c.metrics["wallet_account_addresses"],
+				prometheus.GaugeValue, float64(len(account.Addresses)), account.Name, addressType)
+		}
+	} else {
+		log.Printf("s.walletKit.ListAddresses err: %s", err)
+	}
+}
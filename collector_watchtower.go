@@ -0,0 +1,54 @@
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"log"
+
+	"github.com/lightningnetwork/lnd/lnrpc/watchtowerrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// watchtowerCollector exports the state of the watchtower server when lnd
+// runs as a tower for other nodes. lnd needs to be built with the
+// watchtowerrpc tag and run with --watchtower.active.
+type watchtowerCollector struct {
+	metrics map[string]*prometheus.Desc
+}
+
+func newWatchtowerCollector(namespace string) *watchtowerCollector {
+	return &watchtowerCollector{
+		metrics: map[string]*prometheus.Desc{
+			"watchtower_active":    newGlobalMetric(namespace, "watchtower_server_active", "Whether the watchtower server is running, 0 if lnd reports it inactive or lacks the watchtowerrpc subserver", []string{}),
+			"watchtower_info":      newGlobalMetric(namespace, "watchtower_server_info", "Watchtower server identity", []string{"pubkey"}),
+			"watchtower_listeners": newGlobalMetric(namespace, "watchtower_server_listeners", "Number of addresses the watchtower server listens on", []string{}),
+			"watchtower_uri":       newGlobalMetric(namespace, "watchtower_server_uri", "URIs clients can reach the watchtower server at", []string{"uri"}),
+		},
+	}
+}
+
+func (c *watchtowerCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *watchtowerCollector) Collect(ctx context.Context, s *scrape, ch chan<- prometheus.Metric) {
+	if s.watchtower == nil {
+		return
+	}
+
+	info, err := s.watchtower.GetInfo(ctx, &watchtowerrpc.GetInfoRequest{})
+	if err != nil {
+		log.Printf("s.watchtower.GetInfo err: %s", err)
+		ch <- prometheus.MustNewConstMetric(c.metrics["watchtower_active"], prometheus.GaugeValue, 0)
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["watchtower_active"], prometheus.GaugeValue, 1)
+	ch <- prometheus.MustNewConstMetric(c.metrics["watchtower_info"],
+		prometheus.GaugeValue, 1, hex.EncodeToString(info.Pubkey))
+	ch <- prometheus.MustNewConstMetric(c.metrics["watchtower_listeners"],
+		prometheus.GaugeValue, float64(len(info.Listeners)))
+	for _, uri := range info.Uris {
+		ch <- prometheus.MustNewConstMetric(c.metrics["watchtower_uri"], prometheus.GaugeValue, 1, uri)
+	}
+}
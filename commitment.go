@@ -0,0 +1,83 @@
+package main
+
+import "github.com/lightningnetwork/lnd/lnrpc"
+
+// Commitment transaction weights (in weight units) as specified by BOLT 3
+// and used by lnd's input package. These are estimates, the real weight
+// also depends on signature sizes.
+const (
+	commitWeightLegacy  = 724
+	commitWeightAnchors = 1124
+	commitWeightTaproot = 968
+
+	htlcOutputWeight  = 172
+	htlcTimeoutWeight = 663
+	htlcSuccessWeight = 703
+
+	witnessScaleFactor = 4
+)
+
+// estimateCommitWeight returns the estimated weight of a commitment
+// transaction of the given type carrying numHtlcs HTLC outputs.
+func estimateCommitWeight(commitmentType lnrpc.CommitmentType, numHtlcs int) int64 {
+	base := int64(commitWeightLegacy)
+	switch commitmentType {
+	case lnrpc.CommitmentType_ANCHORS, lnrpc.CommitmentType_SCRIPT_ENFORCED_LEASE:
+		base = commitWeightAnchors
+	case lnrpc.CommitmentType_SIMPLE_TAPROOT:
+		base = commitWeightTaproot
+	}
+	return base + int64(numHtlcs)*htlcOutputWeight
+}
+
+// isZeroFeeHtlcCommitment returns true if second level HTLC transactions of
+// the commitment type pay no fee, which is the case for anchor channels.
+func isZeroFeeHtlcCommitment(commitmentType lnrpc.CommitmentType) bool {
+	switch commitmentType {
+	case lnrpc.CommitmentType_ANCHORS, lnrpc.CommitmentType_SCRIPT_ENFORCED_LEASE,
+		lnrpc.CommitmentType_SIMPLE_TAPROOT:
+		return true
+	}
+	return false
+}
+
+// dustExposure returns the sum of the pending HTLCs of the channel that are
+// trimmed as dust on the local and on the remote commitment transaction.
+// Trimmed HTLCs have no output and are burned to fees on force close.
+func dustExposure(channel *lnrpc.Channel) (local int64, remote int64) {
+	localDust := int64(channel.GetLocalConstraints().GetDustLimitSat())
+	remoteDust := int64(channel.GetRemoteConstraints().GetDustLimitSat())
+
+	secondLevelFee := func(weight int64) int64 {
+		if isZeroFeeHtlcCommitment(channel.CommitmentType) {
+			return 0
+		}
+		return weight * channel.FeePerKw / 1000
+	}
+
+	for _, htlc := range channel.PendingHtlcs {
+		// An incoming HTLC is claimed with a success transaction on our
+		// commitment and with a timeout transaction on theirs.
+		localWeight, remoteWeight := int64(htlcTimeoutWeight), int64(htlcSuccessWeight)
+		if htlc.Incoming {
+			localWeight, remoteWeight = htlcSuccessWeight, htlcTimeoutWeight
+		}
+
+		if htlc.Amount < localDust+secondLevelFee(localWeight) {
+			local += htlc.Amount
+		}
+		if htlc.Amount < remoteDust+secondLevelFee(remoteWeight) {
+			remote += htlc.Amount
+		}
+	}
+	return local, remote
+}
+
+// feeRateSatPerVbyte converts a fee paid by a transaction of the given
+// weight to a fee rate in sat/vbyte.
+func feeRateSatPerVbyte(feeSat int64, weight int64) float64 {
+	if weight <= 0 {
+		return 0
+	}
+	return float64(feeSat) / (float64(weight) / witnessScaleFactor)
+}
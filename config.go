@@ -0,0 +1,162 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Config is the optional exporter config file given with -config.file.
+type Config struct {
+	// Nodes are the lnd nodes to scrape. When set, they replace the node
+	// given with -rpc.addr and all their metrics carry a node label.
+	Nodes []NodeConfig `yaml:"nodes"`
+
+	// Modules select the collectors used by the /probe endpoint.
+	Modules map[string]ModuleConfig `yaml:"modules"`
+
+	// FieldMetrics export fields of RPC responses not covered by the
+	// built-in collectors.
+	FieldMetrics []FieldMetricConfig `yaml:"field_metrics"`
+
+	// PeerTags assign a tag like "exchange" or "lsp" to peers by remote
+	// pubkey, exported as the tag label of channel and forwarding metrics.
+	PeerTags map[string]string `yaml:"peer_tags"`
+}
+
+type NodeConfig struct {
+	Name        string `yaml:"name"`
+	RpcAddr     string `yaml:"rpc_addr"`
+	TLSCertPath string `yaml:"tls_cert_path"`
+	// MacaroonPath is empty for nodes running with --no-macaroons, which
+	// is checked when connecting.
+	MacaroonPath string `yaml:"macaroon_path"`
+
+	// ProbeOnly nodes are only scraped through /probe, not on /metrics.
+	ProbeOnly bool `yaml:"probe_only"`
+}
+
+type ModuleConfig struct {
+	Collectors []string `yaml:"collectors"`
+}
+
+// FieldMetricConfig maps a numeric or boolean field of an RPC response to
+// a gauge. Field and label paths are dot separated lnd JSON field names as
+// printed by lncli, e.g. "num_peers" for GetInfo or "local_constraints.csv_delay"
+// for ListChannels, where each channel is one sample. ListChannels fields
+// get a chan_id label unless a label maps to chan_id or channel_point.
+type FieldMetricConfig struct {
+	Rpc    string            `yaml:"rpc"`
+	Field  string            `yaml:"field"`
+	Name   string            `yaml:"name"`
+	Help   string            `yaml:"help"`
+	Labels map[string]string `yaml:"labels"`
+}
+
+func loadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("invalid config file %s: %w", path, err)
+	}
+
+	names := map[string]bool{}
+	for i, node := range config.Nodes {
+		if node.Name == "" || node.RpcAddr == "" {
+			return nil, fmt.Errorf("node %d: name and rpc_addr are required", i)
+		}
+		if names[node.Name] {
+			return nil, fmt.Errorf("duplicate node name %q", node.Name)
+		}
+		names[node.Name] = true
+	}
+
+	for name, module := range config.Modules {
+		for _, collector := range module.Collectors {
+			if !isLndCollector(collector) {
+				return nil, fmt.Errorf("module %s: unknown collector %q", name, collector)
+			}
+		}
+	}
+	fieldNames := map[string]bool{}
+	for i, field := range config.FieldMetrics {
+		if !fieldMetricRpcs[field.Rpc] {
+			return nil, fmt.Errorf("field_metrics %d: unsupported rpc %q", i, field.Rpc)
+		}
+		if field.Field == "" || !metricNameRE.MatchString(field.Name) {
+			return nil, fmt.Errorf("field_metrics %d: field and a valid name are required", i)
+		}
+		if fieldNames[field.Name] {
+			return nil, fmt.Errorf("field_metrics %d: duplicate name %q", i, field.Name)
+		}
+		fieldNames[field.Name] = true
+		for label := range field.Labels {
+			if !labelNameRE.MatchString(label) {
+				return nil, fmt.Errorf("field_metrics %d: invalid label name %q", i, label)
+			}
+		}
+
+		// Each channel is a sample of a ListChannels field, without a
+		// label telling them apart the series would be duplicates.
+		if field.Rpc == "ListChannels" && !hasChannelLabel(field.Labels) {
+			if _, ok := field.Labels["chan_id"]; ok {
+				return nil, fmt.Errorf("field_metrics %d: label chan_id must map to chan_id or channel_point", i)
+			}
+			labels := map[string]string{"chan_id": "chan_id"}
+			for name, path := range field.Labels {
+				labels[name] = path
+			}
+			config.FieldMetrics[i].Labels = labels
+		}
+	}
+	for pubkey, tag := range config.PeerTags {
+		if !pubkeyRE.MatchString(pubkey) {
+			return nil, fmt.Errorf("peer_tags: invalid pubkey %q", pubkey)
+		}
+		if tag == "" {
+			return nil, fmt.Errorf("peer_tags: empty tag for %s", pubkey)
+		}
+	}
+	return config, nil
+}
+
+var (
+	pubkeyRE     = regexp.MustCompile(`^(02|03)[0-9a-f]{64}$`)
+	metricNameRE = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRE  = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+// hasChannelLabel reports whether one of the labels identifies a channel.
+func hasChannelLabel(labels map[string]string) bool {
+	for _, path := range labels {
+		if path == "chan_id" || path == "channel_point" {
+			return true
+		}
+	}
+	return false
+}
+
+// node returns the node with the given name or RPC address.
+func (c *Config) node(target string) (NodeConfig, bool) {
+	for _, node := range c.Nodes {
+		if node.Name == target || node.RpcAddr == target {
+			return node, true
+		}
+	}
+	return NodeConfig{}, false
+}
+
+func isLndCollector(name string) bool {
+	for _, lc := range lndCollectors {
+		if lc.name == name {
+			return true
+		}
+	}
+	return false
+}
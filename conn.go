@@ -0,0 +1,78 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/encoding/gzip"
+)
+
+// rpcCompressors are the supported values of -rpc.compression.
+var rpcCompressors = map[string]bool{"none": true, gzip.Name: true}
+
+// rpcCompression is the compressor used for RPCs to lnd, "none" disables
+// compression. The responses are compressed the same way.
+var rpcCompression = "none"
+
+// connectParams makes grpc retry a lost connection to lnd quickly enough
+// for the next scrape to succeed, instead of grpc's default of backing off
+// for up to two minutes.
+var connectParams = grpc.ConnectParams{
+	Backoff: backoff.Config{
+		BaseDelay:  1 * time.Second,
+		Multiplier: 1.6,
+		Jitter:     0.2,
+		MaxDelay:   30 * time.Second,
+	},
+	MinConnectTimeout: 5 * time.Second,
+}
+
+// lndConn is a long-lived connection to lnd shared across scrapes. grpc
+// reconnects it in the background, it is only re-dialed, re-reading TLS
+// certificate and macaroon, once it entered a failed state.
+type lndConn struct {
+	sync.Mutex
+
+	rpcAddr      string
+	tlsCertPath  string
+	macaroonPath string
+
+	conn *grpc.ClientConn
+}
+
+func newLndConn(rpcAddr string, tlsCertPath string, macaroonPath string) *lndConn {
+	return &lndConn{
+		rpcAddr:      rpcAddr,
+		tlsCertPath:  tlsCertPath,
+		macaroonPath: macaroonPath,
+	}
+}
+
+// get returns the connection, dialing it if there is none yet or the
+// previous one failed.
+func (l *lndConn) get() (*grpc.ClientConn, error) {
+	l.Lock()
+	defer l.Unlock()
+
+	if l.conn != nil {
+		switch state := l.conn.GetState(); state {
+		case connectivity.TransientFailure, connectivity.Shutdown:
+			log.Printf("connection to %s in state %s, re-dialing", l.rpcAddr, state)
+			l.conn.Close()
+			l.conn = nil
+		default:
+			return l.conn, nil
+		}
+	}
+
+	conn, err := getGrpcClient(l.rpcAddr, l.tlsCertPath, l.macaroonPath)
+	if err != nil {
+		return nil, err
+	}
+	l.conn = conn
+	return conn, nil
+}
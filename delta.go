@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+)
+
+// seriesSnapshot maps every series of a scrape, formatted like in the text
+// exposition, to its value. Histograms and summaries contribute their _count
+// and _sum series.
+type seriesSnapshot struct {
+	at     time.Time
+	series map[string]float64
+}
+
+func newSeriesSnapshot(families []*dto.MetricFamily) *seriesSnapshot {
+	s := &seriesSnapshot{at: time.Now(), series: map[string]float64{}}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			labels := seriesLabels(m.GetLabel())
+			switch {
+			case m.Gauge != nil:
+				s.series[f.GetName()+labels] = m.GetGauge().GetValue()
+			case m.Counter != nil:
+				s.series[f.GetName()+labels] = m.GetCounter().GetValue()
+			case m.Untyped != nil:
+				s.series[f.GetName()+labels] = m.GetUntyped().GetValue()
+			case m.Histogram != nil:
+				s.series[f.GetName()+"_count"+labels] = float64(m.GetHistogram().GetSampleCount())
+				s.series[f.GetName()+"_sum"+labels] = m.GetHistogram().GetSampleSum()
+			case m.Summary != nil:
+				s.series[f.GetName()+"_count"+labels] = float64(m.GetSummary().GetSampleCount())
+				s.series[f.GetName()+"_sum"+labels] = m.GetSummary().GetSampleSum()
+			}
+		}
+	}
+	return s
+}
+
+func seriesLabels(pairs []*dto.LabelPair) string {
+	if len(pairs) == 0 {
+		return ""
+	}
+	var labels []string
+	for _, p := range pairs {
+		labels = append(labels, fmt.Sprintf("%s=%q", p.GetName(), p.GetValue()))
+	}
+	sort.Strings(labels)
+	return "{" + strings.Join(labels, ",") + "}"
+}
+
+// metricName returns the metric name of a series from a snapshot.
+func metricName(series string) string {
+	if i := strings.IndexByte(series, '{'); i >= 0 {
+		return series[:i]
+	}
+	return series
+}
+
+// serveDelta shows which series appeared, disappeared or changed value
+// between the last two scrapes of /metrics, with a per metric summary of
+// the churn first. Changed values are left out with ?changed=false, as
+// counters change on most scrapes.
+func (r *MetadataRegistry) serveDelta(w http.ResponseWriter, req *http.Request) {
+	r.Lock()
+	previous, current := r.previous, r.current
+	r.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if previous == nil || current == nil {
+		fmt.Fprintln(w, "# need two scrapes of the metrics endpoint to compute a delta")
+		return
+	}
+	showChanged := req.URL.Query().Get("changed") != "false"
+
+	type churn struct {
+		appeared, disappeared, changed int
+	}
+	perMetric := map[string]*churn{}
+	metricChurn := func(series string) *churn {
+		name := metricName(series)
+		if perMetric[name] == nil {
+			perMetric[name] = &churn{}
+		}
+		return perMetric[name]
+	}
+
+	var appeared, disappeared, changed []string
+	for series, value := range current.series {
+		old, ok := previous.series[series]
+		switch {
+		case !ok:
+			appeared = append(appeared, fmt.Sprintf("+ %s %g", series, value))
+			metricChurn(series).appeared++
+		case old != value:
+			changed = append(changed, fmt.Sprintf("~ %s %g -> %g", series, old, value))
+			metricChurn(series).changed++
+		}
+	}
+	for series, value := range previous.series {
+		if _, ok := current.series[series]; !ok {
+			disappeared = append(disappeared, fmt.Sprintf("- %s %g", series, value))
+			metricChurn(series).disappeared++
+		}
+	}
+	sort.Strings(appeared)
+	sort.Strings(disappeared)
+	sort.Strings(changed)
+
+	var names []string
+	for name := range perMetric {
+		names = append(names, name)
+	}
+	// Metrics with the most appeared and disappeared series first.
+	sort.Slice(names, func(i, j int) bool {
+		a, b := perMetric[names[i]], perMetric[names[j]]
+		if a.appeared+a.disappeared != b.appeared+b.disappeared {
+			return a.appeared+a.disappeared > b.appeared+b.disappeared
+		}
+		return names[i] < names[j]
+	})
+
+	fmt.Fprintf(w, "# delta between scrapes at %s and %s\n", previous.at.Format(time.RFC3339), current.at.Format(time.RFC3339))
+	fmt.Fprintf(w, "# series: %d -> %d, appeared: %d, disappeared: %d, changed: %d\n",
+		len(previous.series), len(current.series), len(appeared), len(disappeared), len(changed))
+	for _, name := range names {
+		c := perMetric[name]
+		fmt.Fprintf(w, "# %s appeared=%d disappeared=%d changed=%d\n", name, c.appeared, c.disappeared, c.changed)
+	}
+	for _, lines := range [][]string{appeared, disappeared} {
+		for _, line := range lines {
+			fmt.Fprintln(w, line)
+		}
+	}
+	if showChanged {
+		for _, line := range changed {
+			fmt.Fprintln(w, line)
+		}
+	}
+}
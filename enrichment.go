@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// enrichmentCheckInterval is how often the channel peers are checked for
+// missing or expired metadata.
+var enrichmentCheckInterval = 10 * time.Minute
+
+const peerMetadataStateKey = "peer_metadata"
+
+// peerMetadata is the metadata of a peer fetched from the external source,
+// cached in the state store.
+type peerMetadata struct {
+	Alias     string    `json:"alias"`
+	Rank      float64   `json:"rank"`
+	HasRank   bool      `json:"has_rank"`
+	FetchedAt time.Time `json:"fetched_at"`
+}
+
+// PeerMetadataExporter enriches channel peers with metadata like the
+// community alias and rank from an external API such as 1ml.com. Requests
+// are made in the background, at most one per minInterval, and results are
+// cached for ttl. In offline mode only the cached metadata is exported.
+type PeerMetadataExporter struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	rpcAddr      string
+	tlsCertPath  string
+	macaroonPath string
+
+	// url is the API endpoint with {pubkey} replaced by the peer's pubkey,
+	// aliasField and rankField are dot separated paths into its JSON
+	// response.
+	url        string
+	aliasField string
+	rankField  string
+
+	ttl         time.Duration
+	minInterval time.Duration
+	offline     bool
+	timeout     time.Duration
+	httpClient  *http.Client
+
+	// refreshMu serializes the background loop and /api/v1/refresh, so
+	// that requests stay minInterval apart.
+	refreshMu sync.Mutex
+
+	state    *stateStore
+	peers    *lruCache[string, peerMetadata]
+	fetches  uint64
+	failures uint64
+}
+
+func NewPeerMetadataExporter(namespace string, rpcAddr string, tlsCertPath string, macaroonPath string, url string, aliasField string, rankField string, ttl time.Duration, minInterval time.Duration, offline bool, timeout time.Duration, state *stateStore) *PeerMetadataExporter {
+	c := &PeerMetadataExporter{
+		rpcAddr:      rpcAddr,
+		tlsCertPath:  tlsCertPath,
+		macaroonPath: macaroonPath,
+
+		url:         url,
+		aliasField:  aliasField,
+		rankField:   rankField,
+		ttl:         ttl,
+		minInterval: minInterval,
+		offline:     offline,
+		timeout:     timeout,
+		httpClient:  &http.Client{Timeout: timeout},
+
+		state: state,
+		peers: newLruCache[string, peerMetadata]("peer_metadata", rpcAddr),
+
+		metrics: map[string]*prometheus.Desc{
+			"peer_metadata_info":           newGlobalMetric(namespace, "peer_metadata_info", "Metadata of the channel peer from the external enrichment source", []string{"remote_pubkey", "community_alias"}),
+			"peer_metadata_rank":           newGlobalMetric(namespace, "peer_metadata_rank", "Rank of the channel peer from the external enrichment source", []string{"remote_pubkey"}),
+			"peer_metadata_age_seconds":    newGlobalMetric(namespace, "peer_metadata_age_seconds", "Time since the peer's metadata was fetched", []string{"remote_pubkey"}),
+			"peer_metadata_fetches_total":  newGlobalCounter(namespace, "peer_metadata_fetches_total", "Number of requests made to the enrichment source", []string{}),
+			"peer_metadata_failures_total": newGlobalCounter(namespace, "peer_metadata_failures_total", "Number of failed requests to the enrichment source", []string{}),
+			"peer_metadata_offline":        newGlobalMetric(namespace, "peer_metadata_offline", "Whether only cached metadata is exported without requests to the enrichment source", []string{}),
+		},
+	}
+
+	cached := map[string]peerMetadata{}
+	if _, err := state.get(peerMetadataStateKey, &cached); err != nil {
+		log.Printf("invalid cached peer metadata, ignoring it: %s", err)
+	}
+	for pubkey, m := range cached {
+		c.peers.put(pubkey, m)
+	}
+	return c
+}
+
+func (c *PeerMetadataExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *PeerMetadataExporter) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.peers.each(func(pubkey string, m peerMetadata) {
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_metadata_info"],
+			prometheus.GaugeValue, 1.0, pubkey, m.Alias)
+		if m.HasRank {
+			ch <- prometheus.MustNewConstMetric(c.metrics["peer_metadata_rank"],
+				prometheus.GaugeValue, m.Rank, pubkey)
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_metadata_age_seconds"],
+			prometheus.GaugeValue, time.Since(m.FetchedAt).Seconds(), pubkey)
+	})
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_metadata_fetches_total"],
+		prometheus.CounterValue, float64(c.fetches))
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_metadata_failures_total"],
+		prometheus.CounterValue, float64(c.failures))
+	ch <- prometheus.MustNewConstMetric(c.metrics["peer_metadata_offline"],
+		prometheus.GaugeValue, boolToFloat(c.offline))
+}
+
+// Start refreshes the metadata of channel peers in the background until
+// ctx is canceled. It does nothing in offline mode.
+func (c *PeerMetadataExporter) Start(ctx context.Context) {
+	if c.offline {
+		return
+	}
+
+	registerRefresh("peer_metadata", func(ctx context.Context) error {
+		c.refresh(ctx)
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(enrichmentCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			c.refresh(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// refresh fetches the metadata of all channel peers that have none or
+// whose metadata is older than ttl, waiting minInterval between requests.
+func (c *PeerMetadataExporter) refresh(ctx context.Context) {
+	c.refreshMu.Lock()
+	defer c.refreshMu.Unlock()
+
+	pubkeys, err := c.channelPeers(ctx)
+	if err != nil {
+		log.Printf("peer metadata channel peers err: %s", err)
+		return
+	}
+
+	for _, pubkey := range pubkeys {
+		m, ok := c.peers.get(pubkey)
+		if ok && time.Since(m.FetchedAt) < c.ttl {
+			continue
+		}
+
+		m, err := c.fetch(ctx, pubkey)
+		c.Lock()
+		c.fetches++
+		if err != nil {
+			log.Printf("peer metadata fetch %s err: %s", pubkey, err)
+			c.failures++
+		} else {
+			c.peers.put(pubkey, m)
+			if err := c.state.put(peerMetadataStateKey, c.peers.items()); err != nil {
+				log.Printf("saving peer metadata err: %s", err)
+			}
+		}
+		c.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.minInterval):
+		}
+	}
+}
+
+func (c *PeerMetadataExporter) channelPeers(ctx context.Context) ([]string, error) {
+	con, err := getGrpcClient(c.rpcAddr, c.tlsCertPath, c.macaroonPath)
+	if err != nil {
+		return nil, err
+	}
+	defer con.Close()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	resp, err := lnrpc.NewLightningClient(con).ListChannels(ctx, &lnrpc.ListChannelsRequest{})
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	var pubkeys []string
+	for _, channel := range resp.Channels {
+		if !seen[channel.RemotePubkey] {
+			seen[channel.RemotePubkey] = true
+			pubkeys = append(pubkeys, channel.RemotePubkey)
+		}
+	}
+	return pubkeys, nil
+}
+
+func (c *PeerMetadataExporter) fetch(ctx context.Context, pubkey string) (peerMetadata, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.ReplaceAll(c.url, "{pubkey}", pubkey), nil)
+	if err != nil {
+		return peerMetadata{}, err
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return peerMetadata{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return peerMetadata{}, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return peerMetadata{}, err
+	}
+	item := map[string]interface{}{}
+	if err := json.Unmarshal(data, &item); err != nil {
+		return peerMetadata{}, err
+	}
+
+	m := peerMetadata{
+		Alias:     fieldString(item, c.aliasField),
+		FetchedAt: time.Now(),
+	}
+	m.Rank, m.HasRank = fieldValue(item, c.rankField)
+	return m, nil
+}
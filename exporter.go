@@ -1,16 +1,19 @@
 package main
 
 import (
+	"context"
 	"flag"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/collectors"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"google.golang.org/grpc"
 )
 
@@ -22,6 +25,25 @@ func getEnv(key, defaultValue string) string {
 	return value
 }
 
+// flagEnvRE matches the environment variable named in the usage of a flag.
+var flagEnvRE = regexp.MustCompile(`overwritten by ([A-Z0-9_]+) environment(al)? variable`)
+
+// explicitlySetFlags returns the flags set on the command line or through the
+// environment variable named in their usage, which profiles like
+// -low-memory and -preset keep.
+func explicitlySetFlags() map[string]bool {
+	explicit := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	flag.VisitAll(func(f *flag.Flag) {
+		if m := flagEnvRE.FindStringSubmatch(f.Usage); m != nil {
+			if _, ok := os.LookupEnv(m[1]); ok {
+				explicit[f.Name] = true
+			}
+		}
+	})
+	return explicit
+}
+
 var (
 	// Set during go build
 	version   string
@@ -36,13 +58,85 @@ func main() {
 
 	// Defaults values
 	var (
-		defaultNamespace     = getEnv("NAMESPACE", "lnd")
-		defaultListenAddress = getEnv("LISTEN_ADDRESS", ":9113")
-		defaultMetricsPath   = getEnv("TELEMETRY_PATH", "/metrics")
-		defaultRpcAddr       = getEnv("RPC_ADDR", "localhost:10009")
-		defaultTLSCertPath   = getEnv("TLS_CERT_PATH", "/root/.lnd")
-		defaultMacaroonPath  = getEnv("MACAROON_PATH", "")
-		defaultGoMetrics, _  = strconv.ParseBool(getEnv("GO_METRICS", "false"))
+		defaultNamespace          = getEnv("NAMESPACE", "lnd")
+		defaultListenAddress      = getEnv("LISTEN_ADDRESS", ":9113")
+		defaultMetricsPath        = getEnv("TELEMETRY_PATH", "/metrics")
+		defaultRpcAddr            = getEnv("RPC_ADDR", "localhost:10009")
+		defaultTLSCertPath        = getEnv("TLS_CERT_PATH", "/root/.lnd")
+		defaultMacaroonPath       = getEnv("MACAROON_PATH", "")
+		defaultActiveMacaroon     = getEnv("ACTIVE_MACAROON_PATH", "")
+		defaultGoMetrics, _       = strconv.ParseBool(getEnv("GO_METRICS", "false"))
+		defaultReplayDir          = getEnv("REPLAY_FIXTURES_DIR", "")
+		defaultRpcTimeout, _      = time.ParseDuration(getEnv("RPC_TIMEOUT", "15s"))
+		defaultShedThreshold, _   = time.ParseDuration(getEnv("SHED_THRESHOLD", "3s"))
+		defaultRpcConcurrency, _  = strconv.Atoi(getEnv("RPC_CONCURRENCY", "4"))
+		defaultPollInterval, _    = time.ParseDuration(getEnv("SCRAPE_POLL_INTERVAL", "0s"))
+		defaultRpcCompression     = getEnv("RPC_COMPRESSION", "none")
+		defaultHistogramBuckets   = getEnv("HISTOGRAM_BUCKETS", "")
+		defaultStatePath          = getEnv("STATE_PATH", "")
+		defaultStateExport        = getEnv("STATE_EXPORT", "")
+		defaultStateImport        = getEnv("STATE_IMPORT", "")
+		defaultCacheMaxEntries, _ = strconv.Atoi(getEnv("CACHE_MAX_ENTRIES", "10000"))
+		defaultLowMemory, _       = strconv.ParseBool(getEnv("LOW_MEMORY", "false"))
+		defaultPreset             = getEnv("PRESET", "")
+		defaultConfigFile         = getEnv("CONFIG_FILE", "")
+		defaultRefreshToken       = getEnv("REFRESH_TOKEN", "")
+		defaultWebTLSCertFile     = getEnv("WEB_TLS_CERT_FILE", "")
+		defaultWebTLSKeyFile      = getEnv("WEB_TLS_KEY_FILE", "")
+		defaultWebTLSClientCAFile = getEnv("WEB_TLS_CLIENT_CA_FILE", "")
+		defaultBasicAuthUser      = getEnv("WEB_BASIC_AUTH_USER", "")
+		defaultBasicAuthPassword  = getEnv("WEB_BASIC_AUTH_PASSWORD_FILE", "")
+
+		defaultLeaderLockFile         = getEnv("LEADER_ELECTION_LOCK_FILE", "")
+		defaultLeaderKubernetesLease  = getEnv("LEADER_ELECTION_KUBERNETES_LEASE", "")
+		defaultLeaderIdentity         = getEnv("LEADER_ELECTION_IDENTITY", "")
+		defaultLeaderLeaseDuration, _ = time.ParseDuration(getEnv("LEADER_ELECTION_LEASE_DURATION", "15s"))
+
+		defaultHtlcEvents, _    = strconv.ParseBool(getEnv("HTLC_EVENTS", "false"))
+		defaultIdleTimeout, _   = time.ParseDuration(getEnv("SUBSCRIPTION_IDLE_TIMEOUT", "1h"))
+		defaultInvoiceEvents, _ = strconv.ParseBool(getEnv("INVOICE_EVENTS", "false"))
+		defaultPaymentEvents, _ = strconv.ParseBool(getEnv("PAYMENT_EVENTS", "false"))
+		defaultPeerEvents, _    = strconv.ParseBool(getEnv("PEER_EVENTS", "false"))
+		defaultLargeInvoice, _  = strconv.ParseInt(getEnv("INVOICE_LARGE_THRESHOLD_SAT", "0"), 10, 64)
+
+		defaultLspProbeTargets      = getEnv("PROBE_LSP", "")
+		defaultLspProbeAmount, _    = strconv.ParseInt(getEnv("PROBE_LSP_AMOUNT_SAT", "1000"), 10, 64)
+		defaultLspProbePayment, _   = strconv.ParseBool(getEnv("PROBE_LSP_PAYMENT", "false"))
+		defaultLspProbeInterval, _  = time.ParseDuration(getEnv("PROBE_LSP_INTERVAL", "5m"))
+		defaultProbeBudgetAmount, _ = strconv.ParseInt(getEnv("PROBE_BUDGET_AMOUNT_SAT", "0"), 10, 64)
+		defaultProbeBudgetFee, _    = strconv.ParseInt(getEnv("PROBE_BUDGET_FEE_SAT", "0"), 10, 64)
+		defaultActiveDryRun, _      = strconv.ParseBool(getEnv("ACTIVE_DRY_RUN", "false"))
+
+		defaultDustExposureThreshold, _ = strconv.ParseInt(getEnv("DUST_EXPOSURE_THRESHOLD", "500000"), 10, 64)
+		defaultChannelSizeThresholds    = getEnv("CHANNEL_SIZE_THRESHOLDS", strconv.Itoa(wumboThreshold))
+		defaultConcentrationTop         = getEnv("CHANNEL_CONCENTRATION_TOP", "1,5,10")
+		defaultPeerShareTop, _          = strconv.Atoi(getEnv("CHANNEL_PEER_SHARE_TOP", "10"))
+		defaultFeeConfTargets           = getEnv("FEE_CONF_TARGETS", "2,6,144")
+		defaultStaleThreshold, _        = time.ParseDuration(getEnv("STALE_CHANNEL_THRESHOLD", "336h"))
+		defaultChannelPairs, _          = strconv.Atoi(getEnv("FORWARDING_CHANNEL_PAIRS", "0"))
+		defaultPeerScoreWeights         = getEnv("PEER_SCORE_WEIGHTS", "")
+		defaultRebalanceWeights         = getEnv("REBALANCE_WEIGHTS", "")
+		defaultGeoIPCountryDb           = getEnv("GEOIP_COUNTRY_DB", "")
+		defaultGeoIPAsnDb               = getEnv("GEOIP_ASN_DB", "")
+		defaultRebalanceMaxFee, _       = strconv.ParseFloat(getEnv("REBALANCE_MAX_FEE_ADJUSTMENT", "0.5"), 64)
+		defaultForwardingLookback, _    = time.ParseDuration(getEnv("FORWARDING_LOOKBACK", "0s"))
+		defaultForwardingSlice, _       = time.ParseDuration(getEnv("FORWARDING_SLICE", "24h"))
+		defaultForwardingArchiveDir     = getEnv("FORWARDING_ARCHIVE_DIR", "")
+		defaultForwardingMaxQuery, _    = time.ParseDuration(getEnv("FORWARDING_MAX_QUERY_LOOKBACK", "168h"))
+
+		defaultEnrichmentUrl            = getEnv("ENRICHMENT_URL", "")
+		defaultEnrichmentAliasField     = getEnv("ENRICHMENT_ALIAS_FIELD", "alias")
+		defaultEnrichmentRankField      = getEnv("ENRICHMENT_RANK_FIELD", "noderank.capacity")
+		defaultEnrichmentTtl, _         = time.ParseDuration(getEnv("ENRICHMENT_TTL", "24h"))
+		defaultEnrichmentMinInterval, _ = time.ParseDuration(getEnv("ENRICHMENT_MIN_INTERVAL", "10s"))
+		defaultEnrichmentOffline, _     = strconv.ParseBool(getEnv("ENRICHMENT_OFFLINE", "false"))
+
+		defaultUpdateCheckUrl         = getEnv("UPDATE_CHECK_URL", "")
+		defaultUpdateCheckInterval, _ = time.ParseDuration(getEnv("UPDATE_CHECK_INTERVAL", "24h"))
+
+		defaultBitcoindRpcAddr = getEnv("BITCOIND_RPC_ADDR", "")
+		defaultBitcoindRpcUser = getEnv("BITCOIND_RPC_USER", "")
+		defaultBitcoindRpcPass = getEnv("BITCOIND_RPC_PASS", "")
 	)
 
 	// Command-line flags
@@ -53,46 +147,559 @@ func main() {
 			"An address to listen on for web interface and telemetry. The default value can be overwritten by LISTEN_ADDRESS environment variable.")
 		metricsPath = flag.String("web.telemetry-path", defaultMetricsPath,
 			"A path under which to expose metrics. The default value can be overwritten by TELEMETRY_PATH environment variable.")
+		webTLSCertFile = flag.String("web.tls-cert-file", defaultWebTLSCertFile,
+			"Serve the exporter's endpoints over TLS with this certificate, requires -web.tls-key-file. The default value can be overwritten by WEB_TLS_CERT_FILE environment variable.")
+		webTLSKeyFile = flag.String("web.tls-key-file", defaultWebTLSKeyFile,
+			"Private key of -web.tls-cert-file. The default value can be overwritten by WEB_TLS_KEY_FILE environment variable.")
+		webTLSClientCAFile = flag.String("web.tls-client-ca-file", defaultWebTLSClientCAFile,
+			"Require clients to present a certificate signed by a CA in this file, requires -web.tls-cert-file. The default value can be overwritten by WEB_TLS_CLIENT_CA_FILE environment variable.")
+		basicAuthUser = flag.String("web.basic-auth-user", defaultBasicAuthUser,
+			"Require basic auth with this user for the metrics, probe, metadata, delta and report endpoints. The default value can be overwritten by WEB_BASIC_AUTH_USER environment variable.")
+		basicAuthPasswordFile = flag.String("web.basic-auth-password-file", defaultBasicAuthPassword,
+			"File holding the password of -web.basic-auth-user. The default value can be overwritten by WEB_BASIC_AUTH_PASSWORD_FILE environment variable.")
 		rpcAddr = flag.String("rpc.addr", defaultRpcAddr,
 			"Lightning node RPC host. The default value can be overwritten by RPC_HOST environment variable.")
 		tlsCertPath = flag.String("lnd.tls-cert-path", defaultTLSCertPath,
 			"The path to the tls certificate. The default value can be overwritten by TLS_CERT_PATH environment variable.")
 		macaroonPath = flag.String("lnd.macaroon-path", defaultMacaroonPath,
-			"The path to the read only macaroon. The default value can be overwritten by MACAROON_PATH environment variable.")
+			"The path to the read only macaroon, leave empty for lnd running with --no-macaroons, which is checked when connecting. The default value can be overwritten by MACAROON_PATH environment variable.")
+		activeMacaroonPath = flag.String("lnd.active-macaroon-path", defaultActiveMacaroon,
+			"The path to the macaroon used by active features like probes, which need more than read only permissions. The read only macaroon is used if unset. The default value can be overwritten by ACTIVE_MACAROON_PATH environment variable.")
 		goMetrics = flag.Bool("go-metrics", defaultGoMetrics,
 			"Enable process and go metrics from go client library. The default value can be overwritten by GO_METRICS environmental variable.")
+		histogramBucketsFlag = flag.String("histogram.buckets", defaultHistogramBuckets,
+			"Override the buckets of histogram metrics, given as \"name=b1,b2,...;name2=...\" with metric names without namespace, e.g. \"forward_resolution_seconds=0.5,1,5,30\". The default value can be overwritten by HISTOGRAM_BUCKETS environment variable.")
+		refreshToken = flag.String("web.refresh-token", defaultRefreshToken,
+			"Enable /api/v1/refresh?collector=<name> to run expensive collectors (forwarding, peer_metadata, lsp_probe) out of band, authenticated with this bearer token. Disabled if empty. The default value can be overwritten by REFRESH_TOKEN environment variable.")
+		leaderLockFile = flag.String("leader-election.lock-file", defaultLeaderLockFile,
+			"Run probes and event subscriptions only while holding the lease in this file on a volume shared by the exporter replicas of a node. The default value can be overwritten by LEADER_ELECTION_LOCK_FILE environment variable.")
+		leaderKubernetesLease = flag.String("leader-election.kubernetes-lease", defaultLeaderKubernetesLease,
+			"Run probes and event subscriptions only while holding this Kubernetes Lease, given as <namespace>/<name>. The pod's service account needs get, create and update on leases. The default value can be overwritten by LEADER_ELECTION_KUBERNETES_LEASE environment variable.")
+		leaderIdentity = flag.String("leader-election.identity", defaultLeaderIdentity,
+			"Identity of this replica in the leader lease, the hostname if empty. The default value can be overwritten by LEADER_ELECTION_IDENTITY environment variable.")
+		leaderLeaseDuration = flag.Duration("leader-election.lease-duration", defaultLeaderLeaseDuration,
+			"How long the leader lease is valid without renewal, it is renewed every third of it. The default value can be overwritten by LEADER_ELECTION_LEASE_DURATION environment variable.")
+		rpcTimeout = flag.Duration("rpc.timeout", defaultRpcTimeout,
+			"The deadline for all lnd RPCs of a scrape, should be below Prometheus' scrape_timeout. The default value can be overwritten by RPC_TIMEOUT environment variable.")
+		pollInterval = flag.Duration("scrape.poll-interval", defaultPollInterval,
+			"Collect the lnd metrics in the background at this interval and serve the last results on scrape instead of calling lnd per scrape, for big nodes with slow RPCs. 0 collects on every scrape. The default value can be overwritten by SCRAPE_POLL_INTERVAL environment variable.")
+		rpcConcurrency = flag.Int("rpc.concurrency", defaultRpcConcurrency,
+			"The number of collectors run at once in a scrape, each making its own RPCs to lnd. 1 runs them one after another. The default value can be overwritten by RPC_CONCURRENCY environment variable.")
+		shedThreshold = flag.Duration("rpc.shed-threshold", defaultShedThreshold,
+			"Skip low priority collectors (forwarding, network) when less than this is left until the scrape deadline. The default value can be overwritten by SHED_THRESHOLD environment variable.")
+		rpcCompressionFlag = flag.String("rpc.compression", defaultRpcCompression,
+			"Compress the gRPC traffic to lnd with this compressor (none or gzip) to save bandwidth to remote nodes, e.g. over Tor. lnd only compresses responses when it supports the compressor. The default value can be overwritten by RPC_COMPRESSION environment variable.")
+		configFile = flag.String("config.file", defaultConfigFile,
+			"A YAML config file, e.g. listing multiple lnd nodes to scrape. The default value can be overwritten by CONFIG_FILE environment variable.")
+		statePath = flag.String("state.path", defaultStatePath,
+			"A file to persist event stream checkpoints and the forwarding history position in, so that the exporter resumes where it left off after a restart. The default value can be overwritten by STATE_PATH environment variable.")
+		stateExport = flag.String("state.export", defaultStateExport,
+			"Write the state of -state.path to this file as a portable snapshot and exit. The default value can be overwritten by STATE_EXPORT environment variable.")
+		stateImport = flag.String("state.import", defaultStateImport,
+			"Merge a snapshot written with -state.export into -state.path and exit, renaming the state of the exporting host's -rpc.addr to this one's. Stop the exporter using -state.path first. The default value can be overwritten by STATE_IMPORT environment variable.")
+		cacheMaxEntriesFlag = flag.Int("cache.max-entries", defaultCacheMaxEntries,
+			"The maximum number of entries of each internal cache, e.g. of channel peers or channel pair counters, the least recently used entries are evicted beyond it. 0 is unbounded. The default value can be overwritten by CACHE_MAX_ENTRIES environment variable.")
+		preset = flag.String("preset", defaultPreset,
+			"Enable the collectors and set the flags of a preset for a kind of node, flags set on the command line or through their environment variable take precedence: "+presetNames()+". The default value can be overwritten by PRESET environment variable.")
+		lowMemory = flag.Bool("low-memory", defaultLowMemory,
+			"Reduce memory usage for small machines like a Raspberry Pi: shrink caches and RPC page sizes and disable the network collector, unless set on the command line or through their environment variable. The default value can be overwritten by LOW_MEMORY environment variable.")
+		replayDir = flag.String("replay.fixtures-dir", defaultReplayDir,
+			"Serve metrics from recorded lnd RPC responses (<Method>.json files as printed by lncli) in this directory instead of a live node. Only the polling collectors support replay. The default value can be overwritten by REPLAY_FIXTURES_DIR environment variable.")
+
+		idleTimeout = flag.Duration("subscription.idle-timeout", defaultIdleTimeout,
+			"Re-subscribe to an lnd event stream that hasn't delivered a message for this long, 0 disables the watchdog. The default value can be overwritten by SUBSCRIPTION_IDLE_TIMEOUT environment variable.")
+		htlcEvents = flag.Bool("htlc-events", defaultHtlcEvents,
+			"Subscribe to lnd's HTLC event stream and export forwarding latency metrics. The default value can be overwritten by HTLC_EVENTS environment variable.")
+		invoiceEvents = flag.Bool("invoice-events", defaultInvoiceEvents,
+			"Subscribe to lnd's invoice updates and export metrics about the HTLCs settled invoices were paid with. The default value can be overwritten by INVOICE_EVENTS environment variable.")
+		largeInvoiceThreshold = flag.Int64("invoice-events.large-threshold-sat", defaultLargeInvoice,
+			"Export the settle time of the most recent invoices paid with at least this many satoshis, e.g. to notify on large receipts, 0 disables. Requires -invoice-events. The default value can be overwritten by INVOICE_LARGE_THRESHOLD_SAT environment variable.")
+		paymentEvents = flag.Bool("payment-events", defaultPaymentEvents,
+			"Track the payments sent by lnd and export the ones in flight. The default value can be overwritten by PAYMENT_EVENTS environment variable.")
+		peerEvents = flag.Bool("peer-events", defaultPeerEvents,
+			"Subscribe to lnd's peer events and count peers going online and offline, including flaps between scrapes. The default value can be overwritten by PEER_EVENTS environment variable.")
+		lspProbeTargets = flag.String("probe.lsp", defaultLspProbeTargets,
+			"Comma separated list of LSP nodes (pubkey[@host:port]) to probe for connectivity and routability. The default value can be overwritten by PROBE_LSP environment variable.")
+		lspProbeAmount = flag.Int64("probe.lsp-amount-sat", defaultLspProbeAmount,
+			"The amount in satoshis to find routes to the LSPs for. The default value can be overwritten by PROBE_LSP_AMOUNT_SAT environment variable.")
+		lspProbePayment = flag.Bool("probe.lsp-payment", defaultLspProbePayment,
+			"Send an unsettleable probe payment along the route to each LSP. The default value can be overwritten by PROBE_LSP_PAYMENT environment variable.")
+		lspProbeInterval = flag.Duration("probe.lsp-interval", defaultLspProbeInterval,
+			"The interval between LSP probes. The default value can be overwritten by PROBE_LSP_INTERVAL environment variable.")
+		activeDryRun = flag.Bool("active.dry-run", defaultActiveDryRun,
+			"Only log and count the state changing actions of active features, like probe payments, instead of performing them. The default value can be overwritten by ACTIVE_DRY_RUN environment variable.")
+		probeBudgetAmount = flag.Int64("probe.budget-amount-sat", defaultProbeBudgetAmount,
+			"The total amount in satoshis all probe payments together may send per UTC day, 0 is unlimited. The usage is kept across restarts with -state.path. The default value can be overwritten by PROBE_BUDGET_AMOUNT_SAT environment variable.")
+		probeBudgetFee = flag.Int64("probe.budget-fee-sat", defaultProbeBudgetFee,
+			"The total routing fees in satoshis all probe payments together may offer per UTC day, 0 is unlimited. The default value can be overwritten by PROBE_BUDGET_FEE_SAT environment variable.")
+		dustExposureThreshold = flag.Int64("lnd.dust-exposure-threshold", defaultDustExposureThreshold,
+			"The dust exposure threshold in satoshis lnd is configured with (lnd's channel-max-fee-exposure). The default value can be overwritten by DUST_EXPOSURE_THRESHOLD environment variable.")
+		channelSizeThresholds = flag.String("channels.size-thresholds", defaultChannelSizeThresholds,
+			"Comma separated channel capacities in satoshis, the number and capacity of channels above each are exported. The default counts wumbo channels. The default value can be overwritten by CHANNEL_SIZE_THRESHOLDS environment variable.")
+		concentrationTop = flag.String("channels.concentration-top", defaultConcentrationTop,
+			"Comma separated numbers of largest channels whose share of the total channel capacity is exported. The default value can be overwritten by CHANNEL_CONCENTRATION_TOP environment variable.")
+		peerShareTop = flag.Int("channels.peer-share-top", defaultPeerShareTop,
+			"Number of peers with the most channel capacity to export their share of the total capacity for. The default value can be overwritten by CHANNEL_PEER_SHARE_TOP environment variable.")
+		feeConfTargets = flag.String("fees.conf-targets", defaultFeeConfTargets,
+			"Comma separated confirmation targets in blocks to export lnd's fee estimates for with -collector.fee_estimates. The default value can be overwritten by FEE_CONF_TARGETS environment variable.")
+		staleThreshold = flag.Duration("graph.stale-threshold", defaultStaleThreshold,
+			"Channels whose peer hasn't updated its channel policy for longer than this are reported as stale. The default value can be overwritten by STALE_CHANNEL_THRESHOLD environment variable.")
+		channelPairs = flag.Int("forwarding.channel-pairs", defaultChannelPairs,
+			"Export the forwarded volume of this many busiest incoming/outgoing channel pairs, the rest aggregated as \"other\", 0 disables. The default value can be overwritten by FORWARDING_CHANNEL_PAIRS environment variable.")
+
+		enrichmentUrl = flag.String("enrichment.url", defaultEnrichmentUrl,
+			"Fetch metadata of channel peers from this external API, with {pubkey} replaced by the peer's pubkey, e.g. \"https://1ml.com/node/{pubkey}/json\". Disabled if empty. The default value can be overwritten by ENRICHMENT_URL environment variable.")
+		enrichmentAliasField = flag.String("enrichment.alias-field", defaultEnrichmentAliasField,
+			"The dot separated path of the peer's alias in the enrichment API response. The default value can be overwritten by ENRICHMENT_ALIAS_FIELD environment variable.")
+		enrichmentRankField = flag.String("enrichment.rank-field", defaultEnrichmentRankField,
+			"The dot separated path of the peer's rank in the enrichment API response. The default value can be overwritten by ENRICHMENT_RANK_FIELD environment variable.")
+		enrichmentTtl = flag.Duration("enrichment.ttl", defaultEnrichmentTtl,
+			"How long fetched peer metadata is cached before it is fetched again. The default value can be overwritten by ENRICHMENT_TTL environment variable.")
+		enrichmentMinInterval = flag.Duration("enrichment.min-interval", defaultEnrichmentMinInterval,
+			"The minimum time between requests to the enrichment API. The default value can be overwritten by ENRICHMENT_MIN_INTERVAL environment variable.")
+		enrichmentOffline = flag.Bool("enrichment.offline", defaultEnrichmentOffline,
+			"Only export peer metadata cached in the -state.path file, without requests to the enrichment API. The default value can be overwritten by ENRICHMENT_OFFLINE environment variable.")
+		peerScoreWeightsFlag = flag.String("peers.score-weights", defaultPeerScoreWeights,
+			"Weights of the components of the peer stability score as \"flaps=1,ping=1,uptime=1,htlc_failures=1\", components not given keep a weight of 1. HTLC failures are only known with -htlc-events. The default value can be overwritten by PEER_SCORE_WEIGHTS environment variable.")
+		geoIPCountryDb = flag.String("geoip.country-db", defaultGeoIPCountryDb,
+			"A MaxMind GeoLite2 Country (or compatible) database file to count connected clearnet peers by country. The default value can be overwritten by GEOIP_COUNTRY_DB environment variable.")
+		geoIPAsnDb = flag.String("geoip.asn-db", defaultGeoIPAsnDb,
+			"A MaxMind GeoLite2 ASN (or compatible) database file to count connected clearnet peers by autonomous system. The default value can be overwritten by GEOIP_ASN_DB environment variable.")
+		rebalanceWeightsFlag = flag.String("rebalance.weights", defaultRebalanceWeights,
+			"Weights of what makes rebalancing a channel worthwhile for the rebalance collector as \"velocity=1,fee_yield=1,peer_quality=1\", components not given keep a weight of 1. The default value can be overwritten by REBALANCE_WEIGHTS environment variable.")
+		rebalanceMaxFee = flag.Float64("rebalance.max-fee-adjustment", defaultRebalanceMaxFee,
+			"The largest fee rate change suggested by the rebalance collector, relative to the channel's fee rate, for a channel with all funds on one side. The default value can be overwritten by REBALANCE_MAX_FEE_ADJUSTMENT environment variable.")
+		forwardingLookback = flag.Duration("forwarding.lookback", defaultForwardingLookback,
+			"Start the forwarding counters this far back in the forwarding history when no position is persisted in -state.path, 0 starts from now. The default value can be overwritten by FORWARDING_LOOKBACK environment variable.")
+		forwardingArchiveDir = flag.String("forwarding.archive-dir", defaultForwardingArchiveDir,
+			"Append the forwards counted by the forwarding collector to daily CSV files in this directory, e.g. for tax reporting. Use with -state.path to archive every forward once. The default value can be overwritten by FORWARDING_ARCHIVE_DIR environment variable.")
+		forwardingMaxQueryLookback = flag.Duration("forwarding.max-query-lookback", defaultForwardingMaxQuery,
+			"The longest window that can be requested with /metrics?fwd_lookback=<duration>, 0 disables the parameter. The default value can be overwritten by FORWARDING_MAX_QUERY_LOOKBACK environment variable.")
+		forwardingSliceFlag = flag.Duration("forwarding.slice", defaultForwardingSlice,
+			"The time range of the forwarding history requested at once when catching up with a long history. The default value can be overwritten by FORWARDING_SLICE environment variable.")
+
+		updateCheckUrl = flag.String("update-check.url", defaultUpdateCheckUrl,
+			"Check this release feed for a newer version of the exporter and export whether one is available, e.g. \"https://api.github.com/repos/lnliz/prometheus-lnd-exporter/releases/latest\". Disabled if empty. The default value can be overwritten by UPDATE_CHECK_URL environment variable.")
+		updateCheckInterval = flag.Duration("update-check.interval", defaultUpdateCheckInterval,
+			"The interval between checks of -update-check.url. The default value can be overwritten by UPDATE_CHECK_INTERVAL environment variable.")
+
+		bitcoindRpcAddr = flag.String("bitcoind.rpc-addr", defaultBitcoindRpcAddr,
+			"The bitcoind RPC address (host:port) of lnd's chain backend. Backend health metrics are only exported when set. The default value can be overwritten by BITCOIND_RPC_ADDR environment variable.")
+		bitcoindRpcUser = flag.String("bitcoind.rpc-user", defaultBitcoindRpcUser,
+			"The bitcoind RPC user. The default value can be overwritten by BITCOIND_RPC_USER environment variable.")
+		bitcoindRpcPass = flag.String("bitcoind.rpc-pass", defaultBitcoindRpcPass,
+			"The bitcoind RPC password. The default value can be overwritten by BITCOIND_RPC_PASS environment variable.")
 	)
 
+	collectorFlags := map[string]*bool{}
+	for _, lc := range lndCollectors {
+		env := "COLLECTOR_" + strings.ToUpper(lc.name)
+		enabled, _ := strconv.ParseBool(getEnv(env, strconv.FormatBool(!lc.disabledByDefault)))
+		collectorFlags[lc.name] = flag.Bool("collector."+lc.name, enabled,
+			fmt.Sprintf("Enable the %s collector: %s. The default value can be overwritten by %s environment variable.", lc.name, lc.help, env))
+	}
+
 	flag.Parse()
 	log.Printf("Lightning Prometheus Exporter Version=%v GitCommit=%v", version, gitCommit)
 
-	defaultTimeout := 15 * time.Second
+	explicitFlags := explicitlySetFlags()
+	if *preset != "" {
+		if err := applyPreset(*preset, explicitFlags); err != nil {
+			log.Fatalf("invalid -preset: %s", err)
+		}
+	}
+	if *replayDir != "" {
+		log.Printf("Replaying RPC fixtures from %s", *replayDir)
+	}
+
+	buckets, err := parseHistogramBuckets(*histogramBucketsFlag)
+	if err != nil {
+		log.Fatalf("invalid -histogram.buckets: %s", err)
+	}
+	histogramBuckets = buckets
+	subscriptionIdleTimeout = *idleTimeout
+	cacheMaxEntries = *cacheMaxEntriesFlag
+	if *forwardingSliceFlag <= 0 {
+		log.Fatalf("invalid -forwarding.slice %s, must be positive", *forwardingSliceFlag)
+	}
+	forwardingSlice = *forwardingSliceFlag
+
+	if *rpcConcurrency < 1 {
+		log.Fatalf("invalid -rpc.concurrency %d, must be at least 1", *rpcConcurrency)
+	}
+
+	if !rpcCompressors[*rpcCompressionFlag] {
+		log.Fatalf("invalid -rpc.compression %q, expected none or gzip", *rpcCompressionFlag)
+	}
+	rpcCompression = *rpcCompressionFlag
+
+	scoreWeights, err := parsePeerScoreWeights(*peerScoreWeightsFlag)
+	if err != nil {
+		log.Fatalf("invalid -peers.score-weights: %s", err)
+	}
+	rebalanceWeights, err := parseRebalanceWeights(*rebalanceWeightsFlag)
+	if err != nil {
+		log.Fatalf("invalid -rebalance.weights: %s", err)
+	}
+	sizeThresholds, err := parsePositiveInts(*channelSizeThresholds)
+	if err != nil {
+		log.Fatalf("invalid -channels.size-thresholds: %s", err)
+	}
+	topChannels, err := parsePositiveInts(*concentrationTop)
+	if err != nil {
+		log.Fatalf("invalid -channels.concentration-top: %s", err)
+	}
+	confTargets, err := parsePositiveInts(*feeConfTargets)
+	if err != nil {
+		log.Fatalf("invalid -fees.conf-targets: %s", err)
+	}
+	geoIP, err := newGeoIP(*geoIPCountryDb, *geoIPAsnDb)
+	if err != nil {
+		log.Fatalf("cannot open GeoIP database: %s", err)
+	}
+
+	var archive *forwardingArchive
+	if *forwardingArchiveDir != "" {
+		if archive, err = newForwardingArchive(*forwardingArchiveDir); err != nil {
+			log.Fatalf("cannot create forwarding archive: %s", err)
+		}
+	}
+
+	web, err := newWebConfig(*webTLSCertFile, *webTLSKeyFile, *webTLSClientCAFile, *basicAuthUser, *basicAuthPasswordFile)
+	if err != nil {
+		log.Fatalf("invalid web config: %s", err)
+	}
+
+	config := &Config{}
+	if *configFile != "" {
+		if config, err = loadConfig(*configFile); err != nil {
+			log.Fatalf("cannot load config: %s", err)
+		}
+	}
+
+	state, err := newStateStore(*statePath)
+	if err != nil {
+		log.Fatalf("cannot load state: %s", err)
+	}
+	if *stateExport != "" || *stateImport != "" {
+		if *statePath == "" {
+			log.Fatalf("-state.export and -state.import need -state.path")
+		}
+		if *stateExport != "" {
+			if err := state.exportSnapshot(*stateExport, *rpcAddr); err != nil {
+				log.Fatalf("cannot export state: %s", err)
+			}
+			log.Printf("exported state of %s to %s", *statePath, *stateExport)
+		}
+		if *stateImport != "" {
+			n, err := state.importSnapshot(*stateImport, *rpcAddr)
+			if err != nil {
+				log.Fatalf("cannot import state: %s", err)
+			}
+			log.Printf("imported %d state entries from %s into %s", n, *stateImport, *statePath)
+		}
+		return
+	}
+
+	enabledCollectors := map[string]bool{}
+	for name, enabled := range collectorFlags {
+		enabledCollectors[name] = *enabled
+	}
+
+	if *lowMemory {
+		applyLowMemoryProfile(explicitFlags, enabledCollectors)
+	}
+
+	opts := LndExporterOpts{
+		Timeout:               *rpcTimeout,
+		ShedThreshold:         *shedThreshold,
+		Collectors:            enabledCollectors,
+		Concurrency:           *rpcConcurrency,
+		DustExposureThreshold: *dustExposureThreshold,
+		StaleThreshold:        *staleThreshold,
+
+		ChannelSizeThresholds:   sizeThresholds,
+		ChannelConcentrationTop: topChannels,
+		FeeConfTargets:          confTargets,
+		PeerShareTop:            *peerShareTop,
+		ChannelPairs:            *channelPairs,
+		ForwardingLookback:      *forwardingLookback,
+		ForwardingArchive:       archive,
+		FieldMetrics:            config.FieldMetrics,
+		PeerTags:                config.PeerTags,
+		PeerScoreWeights:        scoreWeights,
+
+		GeoIP:                     geoIP,
+		RebalanceWeights:          rebalanceWeights,
+		RebalanceMaxFeeAdjustment: *rebalanceMaxFee,
+		State:                     state,
+	}
+
+	registry := NewMetadataRegistry(prometheus.NewRegistry())
+	var scrapedNodes []NodeConfig
+	for _, node := range config.Nodes {
+		if !node.ProbeOnly {
+			scrapedNodes = append(scrapedNodes, node)
+		}
+	}
 
-	registry := prometheus.NewRegistry()
-	registry.MustRegister(
-		NewLightningExporter(
+	// polled serves the lnd metrics from background polling with
+	// -scrape.poll-interval.
+	polled := func(c prometheus.Collector) prometheus.Collector {
+		if *pollInterval <= 0 {
+			return c
+		}
+		pc := newPollingCollector(*namespace, c, *pollInterval)
+		pc.Start(context.Background())
+		return pc
+	}
+
+	// lndExporter is the node given by flags, nil if the config file lists
+	// the nodes to scrape.
+	var lndExporter *LndExporter
+	health := &healthHandler{}
+	if len(scrapedNodes) == 0 {
+		lndExporter = NewLightningExporter(
 			*namespace,
 			*rpcAddr,
 			*tlsCertPath, *macaroonPath,
-			defaultTimeout, true,
-		))
+			*replayDir,
+			opts,
+		)
+		registry.MustRegister("lnd", polled(lndExporter))
+		health.exporters = append(health.exporters, lndExporter)
+		registerRefresh("forwarding", func(ctx context.Context) error {
+			return lndExporter.refresh(ctx, "forwarding")
+		})
+	}
+
+	// Nodes from the config file are scraped by the polling collectors
+	// only, event subscriptions and probes use the node given by flags.
+	archivedNodes := map[string]bool{}
+	for _, node := range scrapedNodes {
+		// Only the first node with an RPC address archives its forwards
+		// and persists its state.
+		nodeOpts := opts
+		if archivedNodes[node.RpcAddr] {
+			nodeOpts.ForwardingArchive = nil
+			nodeOpts.State = nil
+		}
+		archivedNodes[node.RpcAddr] = true
+
+		nodeExporter := NewLightningExporter(
+			*namespace,
+			node.RpcAddr,
+			node.TLSCertPath, node.MacaroonPath,
+			"",
+			nodeOpts,
+		)
+		registry.MustRegisterWith("lnd/"+node.Name, prometheus.Labels{"node": node.Name}, polled(nodeExporter))
+		health.exporters = append(health.exporters, nodeExporter)
+	}
+
+	registry.MustRegister("subscriptions", NewSubscriptionExporter(*namespace))
+	registry.MustRegister("caches", NewCacheExporter(*namespace))
+	registry.MustRegister("rpc_errors", NewRPCErrorExporter(*namespace))
+
+	var macaroonPaths []string
+	seenMacaroons := map[string]bool{"": true}
+	addMacaroon := func(path string) {
+		if !seenMacaroons[path] {
+			seenMacaroons[path] = true
+			macaroonPaths = append(macaroonPaths, path)
+		}
+	}
+	if len(scrapedNodes) == 0 && *replayDir == "" {
+		addMacaroon(*macaroonPath)
+	}
+	for _, node := range config.Nodes {
+		addMacaroon(node.MacaroonPath)
+	}
+	addMacaroon(*activeMacaroonPath)
+	if len(macaroonPaths) > 0 {
+		registry.MustRegister("macaroons", NewMacaroonExpiryExporter(*namespace, macaroonPaths))
+	}
+
+	// Event subscriptions and probes run on the leader only if leader
+	// election is enabled, the collectors serve what they counted so far on
+	// every replica.
+	var activeStarts []func(ctx context.Context)
+
+	if *htlcEvents {
+		htlcEventExporter := NewHtlcEventExporter(
+			*namespace,
+			*rpcAddr,
+			*tlsCertPath, *macaroonPath,
+		)
+		activeStarts = append(activeStarts, htlcEventExporter.Start)
+		registry.MustRegister("htlc_events", htlcEventExporter)
+	}
+
+	if *invoiceEvents {
+		invoiceEventExporter := NewInvoiceEventExporter(
+			*namespace,
+			*rpcAddr,
+			*tlsCertPath, *macaroonPath,
+			state,
+			*largeInvoiceThreshold,
+		)
+		activeStarts = append(activeStarts, invoiceEventExporter.Start)
+		registry.MustRegister("invoice_events", invoiceEventExporter)
+	}
+
+	if *paymentEvents {
+		paymentEventExporter := NewPaymentEventExporter(
+			*namespace,
+			*rpcAddr,
+			*tlsCertPath, *macaroonPath,
+		)
+		activeStarts = append(activeStarts, paymentEventExporter.Start)
+		registry.MustRegister("payment_events", paymentEventExporter)
+	}
+
+	if *peerEvents {
+		peerEventExporter := NewPeerEventExporter(
+			*namespace,
+			*rpcAddr,
+			*tlsCertPath, *macaroonPath,
+		)
+		activeStarts = append(activeStarts, peerEventExporter.Start)
+		registry.MustRegister("peer_events", peerEventExporter)
+	}
+
+	probeBudget := NewProbeBudget(*namespace, *probeBudgetAmount, *probeBudgetFee, state)
+	if (*probeBudgetAmount > 0 || *probeBudgetFee > 0) && *statePath == "" {
+		log.Printf("the probe budget usage is reset on restart, set -state.path to keep it")
+	}
+	registry.MustRegister("probe_budget", probeBudget)
+
+	activeActions := NewActiveActions(*namespace, *activeDryRun)
+	registry.MustRegister("active", activeActions)
+
+	// Only explicitly enabled active features use the privileged macaroon,
+	// everything else sticks to the read only one.
+	activeMacaroon := *activeMacaroonPath
+	if activeMacaroon == "" {
+		activeMacaroon = *macaroonPath
+	}
+
+	if *lspProbeTargets != "" {
+		targets, err := parseLspTargets(*lspProbeTargets)
+		if err != nil {
+			log.Fatalf("invalid -probe.lsp: %s", err)
+		}
+		lspProbeExporter := NewLspProbeExporter(
+			*namespace,
+			*rpcAddr,
+			*tlsCertPath, activeMacaroon,
+			targets, *lspProbeAmount, *lspProbePayment,
+			*lspProbeInterval, *rpcTimeout,
+			probeBudget, activeActions,
+		)
+		activeStarts = append(activeStarts, lspProbeExporter.Start)
+		registry.MustRegister("lsp_probe", lspProbeExporter)
+	}
+
+	var leaderLock leaderLock
+	switch {
+	case *leaderLockFile != "" && *leaderKubernetesLease != "":
+		log.Fatalf("-leader-election.lock-file and -leader-election.kubernetes-lease are mutually exclusive")
+	case *leaderLockFile != "":
+		leaderLock = &fileLease{path: *leaderLockFile}
+	case *leaderKubernetesLease != "":
+		if leaderLock, err = newKubernetesLease(*leaderKubernetesLease, *rpcTimeout); err != nil {
+			log.Fatalf("invalid -leader-election.kubernetes-lease: %s", err)
+		}
+	}
+
+	startActive := func(ctx context.Context) {
+		for _, start := range activeStarts {
+			start(ctx)
+		}
+	}
+	if leaderLock != nil {
+		if *leaderLeaseDuration < 3*time.Second {
+			log.Fatalf("invalid -leader-election.lease-duration %s, must be at least 3s", *leaderLeaseDuration)
+		}
+		identity := *leaderIdentity
+		if identity == "" {
+			if identity, err = os.Hostname(); err != nil {
+				log.Fatalf("cannot get hostname for -leader-election.identity: %s", err)
+			}
+		}
+		leaderElector := NewLeaderElector(*namespace, leaderLock, identity, *leaderLeaseDuration)
+		leaderElector.Run(context.Background(), startActive)
+		registry.MustRegister("leader", leaderElector)
+	} else {
+		startActive(context.Background())
+	}
+
+	if *enrichmentUrl != "" || *enrichmentOffline {
+		peerMetadataExporter := NewPeerMetadataExporter(
+			*namespace,
+			*rpcAddr,
+			*tlsCertPath, *macaroonPath,
+			*enrichmentUrl, *enrichmentAliasField, *enrichmentRankField,
+			*enrichmentTtl, *enrichmentMinInterval, *enrichmentOffline,
+			*rpcTimeout,
+			state,
+		)
+		peerMetadataExporter.Start(context.Background())
+		registry.MustRegister("peer_metadata", peerMetadataExporter)
+	}
+
+	if *updateCheckUrl != "" {
+		if *updateCheckInterval <= 0 {
+			log.Fatalf("invalid -update-check.interval %s, must be positive", *updateCheckInterval)
+		}
+		updateCheckExporter := NewUpdateCheckExporter(*namespace, *updateCheckUrl, *updateCheckInterval, *rpcTimeout)
+		updateCheckExporter.Start(context.Background())
+		registry.MustRegister("update_check", updateCheckExporter)
+	}
+
+	if *bitcoindRpcAddr != "" {
+		registry.MustRegister("bitcoind",
+			NewBitcoindExporter(
+				*namespace,
+				*bitcoindRpcAddr,
+				*bitcoindRpcUser, *bitcoindRpcPass,
+				*rpcTimeout,
+			))
+	}
 
 	if *goMetrics {
-		registry.MustRegister(collectors.NewGoCollector())
-		registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+		registry.MustRegister("go", collectors.NewGoCollector())
+		registry.MustRegister("process", collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
 	}
 
-	http.Handle(*metricsPath, promhttp.HandlerFor(registry, promhttp.HandlerOpts{}))
+	http.Handle(*metricsPath, web.withBasicAuth(newMetricsHandler(registry, *namespace, lndExporter, *forwardingMaxQueryLookback)))
+	http.HandleFunc("/healthz", health.healthz)
+	http.HandleFunc("/readyz", health.readyz)
+	http.Handle("/api/v1/metadata", web.withBasicAuth(registry))
+	http.Handle("/debug/delta", web.withBasicAuth(http.HandlerFunc(registry.serveDelta)))
+	http.Handle("/probe", web.withBasicAuth(newProbeHandler(config, *namespace, opts)))
+	if lndExporter != nil {
+		http.Handle("/api/v1/report", web.withBasicAuth(newReportHandler(lndExporter, archive)))
+	}
+	if *refreshToken != "" {
+		http.Handle("/api/v1/refresh", newRefreshHandler(*refreshToken))
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		w.Write([]byte(`<html>
 			<head><title>Lightning Exporter</title></head>
 			<body>
 			<h1>Lightning Exporter</h1>
-			<p><a href='/metrics'>Metrics</a></p>
+			<p><a href='/metrics'>Metrics</a>, with the forwards of an ad-hoc window with /metrics?fwd_lookback=&lt;duration&gt;</p>
+			<p><a href='/healthz'>Health</a> and <a href='/readyz'>readiness</a>, ready while lnd can be reached</p>
+			<p><a href='/api/v1/metadata'>Metric metadata</a></p>
+			<p><a href='/debug/delta'>Series changed since the previous scrape</a></p>
+			<p>Probe a node from the config file with /probe?target=&lt;rpc_addr&gt;&amp;module=&lt;module&gt;</p>
+			<p>Income and expenses of a period with /api/v1/report?period=&lt;2024, 2024-Q1 or 2024-03&gt;, as CSV with &amp;format=csv</p>
+			<p>Refresh a background collector with an authenticated POST to /api/v1/refresh?collector=&lt;name&gt; if enabled</p>
 			</body>
 			</html>`))
 	})
 
 	log.Printf("ListenAndServe %s \n", *listenAddr)
-	log.Fatal(http.ListenAndServe(*listenAddr, nil))
+	log.Fatal(web.listenAndServe(*listenAddr))
 }
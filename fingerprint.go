@@ -0,0 +1,53 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// Implementation specific feature bits, as optional/required pairs.
+var (
+	// lnd's script enforced channel leases.
+	lndLeaseFeature = [2]uint32{2022, 2023}
+
+	// Eclair's trampoline payment prototype.
+	eclairTrampolineFeature = [2]uint32{148, 149}
+)
+
+// Default node announcement colors.
+const (
+	lndDefaultColor    = "#3399ff"
+	eclairDefaultColor = "#49daaa"
+)
+
+// peerImplementation guesses the software of a node from its announcement,
+// "lnd", "cln", "eclair" or "unknown". It relies on implementation specific
+// features and default colors, so it is a heuristic only: operators can
+// change the color and implementations adopt each other's features.
+//
+// LDK based nodes have neither and count as unknown.
+func peerImplementation(node *lnrpc.LightningNode) string {
+	if node == nil {
+		return "unknown"
+	}
+
+	hasFeature := func(bits [2]uint32) bool {
+		_, optional := node.Features[bits[0]]
+		_, required := node.Features[bits[1]]
+		return optional || required
+	}
+	color := strings.ToLower(node.Color)
+
+	switch {
+	case hasFeature(lndLeaseFeature), color == lndDefaultColor:
+		return "lnd"
+	case hasFeature(eclairTrampolineFeature), color == eclairDefaultColor:
+		return "eclair"
+	// CLN colors the node with the first three bytes of its pubkey by
+	// default.
+	case len(node.PubKey) >= 6 && color == "#"+strings.ToLower(node.PubKey[:6]):
+		return "cln"
+	}
+	return "unknown"
+}
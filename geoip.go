@@ -0,0 +1,345 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"strconv"
+)
+
+// mmdbMetadataMarker starts the metadata section at the end of a MaxMind DB
+// file, see https://maxmind.github.io/MaxMind-DB/.
+var mmdbMetadataMarker = []byte("\xab\xcd\xefMaxMind.com")
+
+// mmdbReader looks up IP addresses in a MaxMind DB file, e.g. GeoLite2
+// Country or ASN. Only the parts of the format needed for lookups are
+// implemented.
+type mmdbReader struct {
+	buf        []byte
+	nodeCount  uint64
+	recordSize uint64
+	ipVersion  uint64
+
+	// data is the data section, ipv4Start the node IPv4 addresses start
+	// from in an IPv6 tree.
+	data      []byte
+	ipv4Start uint64
+}
+
+func openMmdb(path string) (*mmdbReader, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	i := bytes.LastIndex(buf, mmdbMetadataMarker)
+	if i < 0 {
+		return nil, fmt.Errorf("%s is not a MaxMind DB file", path)
+	}
+	metaSection := buf[i+len(mmdbMetadataMarker):]
+	meta, _, err := (&mmdbDecoder{buf: metaSection}).decode(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata in %s: %w", path, err)
+	}
+	metadata, ok := meta.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid metadata in %s", path)
+	}
+
+	r := &mmdbReader{buf: buf}
+	for key, v := range map[string]*uint64{"node_count": &r.nodeCount, "record_size": &r.recordSize, "ip_version": &r.ipVersion} {
+		n, ok := metadata[key].(uint64)
+		if !ok {
+			return nil, fmt.Errorf("missing %s in metadata of %s", key, path)
+		}
+		*v = n
+	}
+	if r.recordSize != 24 && r.recordSize != 28 && r.recordSize != 32 {
+		return nil, fmt.Errorf("unsupported record size %d in %s", r.recordSize, path)
+	}
+
+	treeSize := r.recordSize * 2 / 8 * r.nodeCount
+	if treeSize+16 > uint64(i) {
+		return nil, fmt.Errorf("invalid search tree size in %s", path)
+	}
+	r.data = buf[treeSize+16 : i]
+
+	// IPv4 addresses are found under 96 zero bits in an IPv6 tree.
+	if r.ipVersion == 6 {
+		for j := 0; j < 96 && r.ipv4Start < r.nodeCount; j++ {
+			r.ipv4Start = r.record(r.ipv4Start, 0)
+		}
+	}
+	return r, nil
+}
+
+// record returns the left (bit 0) or right (bit 1) record of the node.
+func (r *mmdbReader) record(node uint64, bit uint) uint64 {
+	switch r.recordSize {
+	case 24:
+		off := node * 6
+		if bit == 1 {
+			off += 3
+		}
+		b := r.buf[off : off+3]
+		return uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+	case 28:
+		off := node * 7
+		b := r.buf[off : off+7]
+		if bit == 0 {
+			return uint64(b[3]&0xf0)<<20 | uint64(b[0])<<16 | uint64(b[1])<<8 | uint64(b[2])
+		}
+		return uint64(b[3]&0x0f)<<24 | uint64(b[4])<<16 | uint64(b[5])<<8 | uint64(b[6])
+	default:
+		off := node*8 + uint64(bit)*4
+		return uint64(binary.BigEndian.Uint32(r.buf[off : off+4]))
+	}
+}
+
+// lookup returns the record of the IP address, nil if there is none.
+func (r *mmdbReader) lookup(ip net.IP) (interface{}, error) {
+	node := uint64(0)
+	addr := ip.To16()
+	if ip4 := ip.To4(); ip4 != nil {
+		addr = ip4
+		if r.ipVersion == 6 {
+			node = r.ipv4Start
+		}
+	} else if r.ipVersion == 4 {
+		return nil, nil
+	}
+
+	for i := 0; i < len(addr)*8 && node < r.nodeCount; i++ {
+		node = r.record(node, uint(addr[i/8]>>(7-i%8))&1)
+	}
+	if node == r.nodeCount {
+		return nil, nil
+	}
+	if node < r.nodeCount {
+		return nil, errors.New("invalid search tree")
+	}
+
+	value, _, err := (&mmdbDecoder{buf: r.data}).decode(node - r.nodeCount - 16)
+	return value, err
+}
+
+// mmdbDecoder decodes values of the MaxMind DB data section format.
+type mmdbDecoder struct {
+	buf []byte
+}
+
+func (d *mmdbDecoder) bytes(off, n uint64) ([]byte, error) {
+	if off+n > uint64(len(d.buf)) {
+		return nil, errors.New("unexpected end of data")
+	}
+	return d.buf[off : off+n], nil
+}
+
+func (d *mmdbDecoder) uint(off, n uint64) (uint64, error) {
+	b, err := d.bytes(off, n)
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v, nil
+}
+
+// decode returns the value at off and the offset following it.
+func (d *mmdbDecoder) decode(off uint64) (interface{}, uint64, error) {
+	ctrl, err := d.uint(off, 1)
+	if err != nil {
+		return nil, 0, err
+	}
+	off++
+
+	typ := ctrl >> 5
+	if typ == 1 {
+		return d.decodePointer(ctrl, off)
+	}
+	if typ == 0 {
+		ext, err := d.uint(off, 1)
+		if err != nil {
+			return nil, 0, err
+		}
+		typ = 7 + ext
+		off++
+	}
+
+	size := ctrl & 0x1f
+	if size >= 29 {
+		n := size - 28
+		extra, err := d.uint(off, n)
+		if err != nil {
+			return nil, 0, err
+		}
+		off += n
+		size = map[uint64]uint64{1: 29, 2: 285, 3: 65821}[n] + extra
+	}
+
+	switch typ {
+	case 2:
+		b, err := d.bytes(off, size)
+		return string(b), off + size, err
+	case 3:
+		v, err := d.uint(off, 8)
+		return math.Float64frombits(v), off + 8, err
+	case 4:
+		b, err := d.bytes(off, size)
+		return b, off + size, err
+	case 5, 6, 9, 10:
+		// uint128 values are truncated, they don't occur in the country
+		// and ASN databases.
+		if size > 8 {
+			off += size - 8
+			size = 8
+		}
+		v, err := d.uint(off, size)
+		return v, off + size, err
+	case 7:
+		m := map[string]interface{}{}
+		for i := uint64(0); i < size; i++ {
+			key, next, err := d.decode(off)
+			if err != nil {
+				return nil, 0, err
+			}
+			value, next, err := d.decode(next)
+			if err != nil {
+				return nil, 0, err
+			}
+			m[fmt.Sprint(key)] = value
+			off = next
+		}
+		return m, off, nil
+	case 8:
+		v, err := d.uint(off, size)
+		return int64(int32(uint32(v))), off + size, err
+	case 11:
+		a := make([]interface{}, 0, size)
+		for i := uint64(0); i < size; i++ {
+			value, next, err := d.decode(off)
+			if err != nil {
+				return nil, 0, err
+			}
+			a = append(a, value)
+			off = next
+		}
+		return a, off, nil
+	case 14:
+		return size != 0, off, nil
+	case 15:
+		v, err := d.uint(off, 4)
+		return float64(math.Float32frombits(uint32(v))), off + 4, err
+	}
+	return nil, 0, fmt.Errorf("unsupported data type %d", typ)
+}
+
+// decodePointer follows the pointer at off, the value it points to is
+// followed by the data after the pointer.
+func (d *mmdbDecoder) decodePointer(ctrl uint64, off uint64) (interface{}, uint64, error) {
+	n := (ctrl>>3)&0x3 + 1
+	v, err := d.uint(off, n)
+	if err != nil {
+		return nil, 0, err
+	}
+	prefix := ctrl & 0x7
+	var target uint64
+	switch n {
+	case 1:
+		target = prefix<<8 | v
+	case 2:
+		target = (prefix<<16 | v) + 2048
+	case 3:
+		target = (prefix<<24 | v) + 526336
+	default:
+		target = v
+	}
+	value, _, err := d.decode(target)
+	return value, off + n, err
+}
+
+// geoIP classifies peer addresses by country and autonomous system with
+// local GeoLite2 (or compatible) Country and ASN databases.
+type geoIP struct {
+	country *mmdbReader
+	asn     *mmdbReader
+}
+
+// newGeoIP opens the databases given, it returns nil if neither is.
+func newGeoIP(countryPath string, asnPath string) (*geoIP, error) {
+	if countryPath == "" && asnPath == "" {
+		return nil, nil
+	}
+
+	g := &geoIP{}
+	var err error
+	if countryPath != "" {
+		if g.country, err = openMmdb(countryPath); err != nil {
+			return nil, err
+		}
+	}
+	if asnPath != "" {
+		if g.asn, err = openMmdb(asnPath); err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// peerIP returns the IP of a clearnet peer address like "1.2.3.4:9735",
+// nil for other addresses, e.g. Tor.
+func peerIP(address string) net.IP {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		host = address
+	}
+	return net.ParseIP(host)
+}
+
+// countryCode returns the ISO code of the IP's country, empty if unknown.
+func (g *geoIP) countryCode(ip net.IP) string {
+	if g.country == nil {
+		return ""
+	}
+	record, err := g.country.lookup(ip)
+	if err != nil {
+		return ""
+	}
+	country, _ := mmdbField(record, "country", "iso_code").(string)
+	return country
+}
+
+// as returns the number and organization of the IP's autonomous system,
+// empty if unknown.
+func (g *geoIP) as(ip net.IP) (string, string) {
+	if g.asn == nil {
+		return "", ""
+	}
+	record, err := g.asn.lookup(ip)
+	if err != nil {
+		return "", ""
+	}
+	number, ok := mmdbField(record, "autonomous_system_number").(uint64)
+	if !ok {
+		return "", ""
+	}
+	org, _ := mmdbField(record, "autonomous_system_organization").(string)
+	return strconv.FormatUint(number, 10), org
+}
+
+func mmdbField(record interface{}, path ...string) interface{} {
+	for _, key := range path {
+		m, ok := record.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		record = m[key]
+	}
+	return record
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// readyCheckInterval is how long the result of the last attempt to reach
+// lnd is used by /readyz before it calls GetInfo itself, so that an
+// exporter nobody scrapes doesn't stay unready.
+var readyCheckInterval = 30 * time.Second
+
+// lndHealth is the result of the last attempt to reach lnd, kept apart
+// from the exporter's lock so /readyz doesn't wait for a running scrape.
+type lndHealth struct {
+	sync.Mutex
+	err error
+	at  time.Time
+}
+
+func (h *lndHealth) set(err error) {
+	h.Lock()
+	defer h.Unlock()
+	h.err, h.at = err, time.Now()
+}
+
+func (h *lndHealth) get() (time.Time, error) {
+	h.Lock()
+	defer h.Unlock()
+	return h.at, h.err
+}
+
+// ready reports the last attempt to reach lnd, calling GetInfo if there was
+// none within readyCheckInterval.
+func (c *LndExporter) ready(ctx context.Context) error {
+	at, err := c.health.get()
+	if time.Since(at) < readyCheckInterval {
+		return err
+	}
+
+	client, err := c.lightningClient()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		_, err = client.GetInfo(ctx, &lnrpc.GetInfoRequest{})
+	}
+	c.health.set(err)
+	return err
+}
+
+// healthHandler serves /healthz, which only tells the exporter is alive,
+// and /readyz, which fails while any of the scraped nodes can't be
+// reached.
+type healthHandler struct {
+	exporters []*LndExporter
+}
+
+func (h *healthHandler) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Write([]byte("ok\n"))
+}
+
+func (h *healthHandler) readyz(w http.ResponseWriter, r *http.Request) {
+	var failures []string
+	for _, exporter := range h.exporters {
+		if err := exporter.ready(r.Context()); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", exporter.rpcAddr, err))
+		}
+	}
+	if len(failures) > 0 {
+		http.Error(w, strings.Join(failures, "\n"), http.StatusServiceUnavailable)
+		return
+	}
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// histogramBuckets holds bucket boundaries configured per histogram metric
+// name (without namespace), overriding the defaults.
+var histogramBuckets = map[string][]float64{}
+
+// histogramMetrics lists the names (without namespace) of the histogram
+// metrics whose buckets can be configured.
+var histogramMetrics = []string{
+	"forward_resolution_seconds",
+	"peer_htlc_hold_seconds",
+	"invoice_settled_htlcs",
+	"invoice_settled_htlc_satoshis",
+}
+
+// parseHistogramBuckets parses bucket overrides in the form
+// "name=b1,b2,...;name2=b1,b2,...".
+func parseHistogramBuckets(s string) (map[string][]float64, error) {
+	res := map[string][]float64{}
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, values, ok := strings.Cut(spec, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid histogram buckets %q, expected name=b1,b2,...", spec)
+		}
+		name = strings.TrimSpace(name)
+		if !isHistogramMetric(name) {
+			return nil, fmt.Errorf("unknown histogram metric %q, expected one of %s", name, strings.Join(histogramMetrics, ", "))
+		}
+
+		var buckets []float64
+		for _, v := range strings.Split(values, ",") {
+			b, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bucket for %s: %s", name, err)
+			}
+			if len(buckets) > 0 && b <= buckets[len(buckets)-1] {
+				return nil, fmt.Errorf("buckets for %s must be strictly increasing", name)
+			}
+			buckets = append(buckets, b)
+		}
+		res[name] = buckets
+	}
+	return res, nil
+}
+
+func isHistogramMetric(name string) bool {
+	for _, m := range histogramMetrics {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// bucketsFor returns the configured buckets for the histogram metric name,
+// or defaultBuckets if none are configured.
+func bucketsFor(name string, defaultBuckets []float64) []float64 {
+	if buckets, ok := histogramBuckets[name]; ok {
+		return buckets
+	}
+	return defaultBuckets
+}
+
+// histogram accumulates observations for export as a const histogram
+// metric. It is not safe for concurrent use, callers hold their exporter's
+// lock.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	count   uint64
+	sum     float64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{
+		buckets: buckets,
+		counts:  make([]uint64, len(buckets)),
+	}
+}
+
+func (h *histogram) observe(v float64) {
+	h.count++
+	h.sum += v
+	if i := sort.SearchFloat64s(h.buckets, v); i < len(h.buckets) {
+		h.counts[i]++
+	}
+}
+
+func (h *histogram) metric(desc *prometheus.Desc, labels ...string) prometheus.Metric {
+	buckets := make(map[float64]uint64, len(h.buckets))
+	var cumulative uint64
+	for i, upperBound := range h.buckets {
+		cumulative += h.counts[i]
+		buckets[upperBound] = cumulative
+	}
+	return prometheus.MustNewConstHistogram(desc, h.count, h.sum, buckets, labels...)
+}
@@ -0,0 +1,488 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// forwardLatencyBuckets are the default histogram buckets in seconds
+	// for the time it takes a forwarded HTLC to be settled or failed.
+	forwardLatencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300}
+
+	// maxPendingForwardAge bounds how long a forward is remembered while
+	// waiting for its resolution, so that missed events can't leak memory.
+	maxPendingForwardAge = 24 * time.Hour
+
+	// forwardBackfillPageSize is the number of events requested per
+	// ForwardingHistory call when backfilling after a reconnect.
+	forwardBackfillPageSize uint32 = 1000
+
+	// htlcPeersReloadInterval limits how often the channels are listed to
+	// learn the peer of a channel not seen before.
+	htlcPeersReloadInterval = time.Minute
+)
+
+const htlcSubscription = "htlc events"
+
+// htlcWindow is how long forwarded HTLCs count towards the failure ratio
+// of their outgoing channel and failed ones against the stability score of
+// its peer.
+var htlcWindow = 24 * time.Hour
+
+// htlcFailures and htlcSettles count the failed and settled forwards by
+// outgoing channel within htlcWindow, recorded from the HTLC event stream
+// when it is enabled.
+var (
+	htlcFailures = newRollingCounts()
+	htlcSettles  = newRollingCounts()
+)
+
+// rollingCounts counts events by channel in hourly buckets, dropping
+// buckets older than htlcWindow.
+type rollingCounts struct {
+	sync.Mutex
+	m map[uint64]map[int64]uint64
+}
+
+func newRollingCounts() *rollingCounts {
+	return &rollingCounts{m: map[uint64]map[int64]uint64{}}
+}
+
+func (r *rollingCounts) add(chanId uint64, ts time.Time) {
+	r.Lock()
+	defer r.Unlock()
+
+	buckets, ok := r.m[chanId]
+	if !ok {
+		buckets = map[int64]uint64{}
+		r.m[chanId] = buckets
+	}
+	buckets[ts.Unix()/3600]++
+	r.prune(chanId, ts)
+}
+
+// count returns the events of the channel within htlcWindow before now.
+func (r *rollingCounts) count(chanId uint64, now time.Time) uint64 {
+	r.Lock()
+	defer r.Unlock()
+
+	r.prune(chanId, now)
+	var n uint64
+	for _, c := range r.m[chanId] {
+		n += c
+	}
+	return n
+}
+
+// channels returns the channels with events within htlcWindow before now.
+func (r *rollingCounts) channels(now time.Time) []uint64 {
+	r.Lock()
+	defer r.Unlock()
+
+	var chanIds []uint64
+	for chanId := range r.m {
+		if r.prune(chanId, now) {
+			chanIds = append(chanIds, chanId)
+		}
+	}
+	return chanIds
+}
+
+// prune drops the buckets of the channel older than htlcWindow and reports
+// whether any are left, callers hold the lock.
+func (r *rollingCounts) prune(chanId uint64, now time.Time) bool {
+	oldest := now.Add(-htlcWindow).Unix() / 3600
+	for hour := range r.m[chanId] {
+		if hour < oldest {
+			delete(r.m[chanId], hour)
+		}
+	}
+	if len(r.m[chanId]) == 0 {
+		delete(r.m, chanId)
+		return false
+	}
+	return true
+}
+
+type htlcKey struct {
+	incomingChanId uint64
+	incomingHtlcId uint64
+	outgoingChanId uint64
+	outgoingHtlcId uint64
+}
+
+// htlcEventCount identifies a counter of HTLC events by a channel the HTLC
+// used, its direction through the channel, the type of the HTLC and what
+// happened to it.
+type htlcEventCount struct {
+	chanId    uint64
+	direction string
+	eventType string
+	outcome   string
+}
+
+// HtlcEventExporter subscribes to lnd's HTLC event stream in the background
+// and exports metrics derived from it on scrape.
+type HtlcEventExporter struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	rpcAddr      string
+	tlsCertPath  string
+	macaroonPath string
+
+	// pendingForwards maps forwards to the time they were offered to the
+	// outgoing channel.
+	pendingForwards map[htlcKey]time.Time
+	forwardLatency  map[string]*histogram
+
+	// forwardsSettled counts settled forwards, including those recovered
+	// from the forwarding history after the stream was down. syncedUntil
+	// is the time up to which events were received.
+	forwardsSettled uint64
+	syncedUntil     time.Time
+
+	// downSince is when the stream last failed, downSeconds the time it
+	// was down in total. Failures in that time can't be recovered.
+	downSince   time.Time
+	downSeconds float64
+
+	// htlcEvents counts all events received on the stream, including
+	// failures which never show up in the forwarding history. It isn't
+	// evicted from, which would reset counters, its size is bounded by
+	// the number of channels.
+	htlcEvents map[htlcEventCount]uint64
+
+	// peerHoldTime holds the time the peer of the outgoing channel took to
+	// settle or fail forwards, by peer and outcome. chanPeers maps channels
+	// to their peer, reloaded at most every htlcPeersReloadInterval.
+	peerHoldTime    *lruCache[peerOutcome, *histogram]
+	chanPeers       *lruCache[uint64, string]
+	chanPeersLoaded time.Time
+}
+
+type peerOutcome struct {
+	pubkey  string
+	outcome string
+}
+
+func NewHtlcEventExporter(namespace string, rpcAddr string, tlsCertPath string, macaroonPath string) *HtlcEventExporter {
+	latencyBuckets := bucketsFor("forward_resolution_seconds", forwardLatencyBuckets)
+
+	return &HtlcEventExporter{
+		rpcAddr:      rpcAddr,
+		tlsCertPath:  tlsCertPath,
+		macaroonPath: macaroonPath,
+
+		pendingForwards: map[htlcKey]time.Time{},
+		htlcEvents:      map[htlcEventCount]uint64{},
+		peerHoldTime:    newLruCache[peerOutcome, *histogram]("htlc_peer_hold_time", rpcAddr),
+		chanPeers:       newLruCache[uint64, string]("htlc_channel_peers", rpcAddr),
+		forwardLatency: map[string]*histogram{
+			"settle": newHistogram(latencyBuckets),
+			"fail":   newHistogram(latencyBuckets),
+		},
+
+		metrics: map[string]*prometheus.Desc{
+			"forward_resolution_seconds":    newGlobalHistogram(namespace, "forward_resolution_seconds", "Time between forwarding an HTLC and its settlement or failure", []string{"outcome"}),
+			"peer_htlc_hold_seconds":        newGlobalHistogram(namespace, "peer_htlc_hold_seconds", "Time the peer of the outgoing channel held a forwarded HTLC before settling or failing it", []string{"remote_pubkey", "outcome"}),
+			"forwards_pending":              newGlobalMetric(namespace, "forwards_pending", "Number of forwarded HTLCs waiting for resolution", []string{}),
+			"htlc_forwards_settled":         newGlobalCounter(namespace, "htlc_forwards_settled_total", "Number of settled forwards seen on the HTLC event stream or backfilled from the forwarding history", []string{}),
+			"channel_forward_failure_ratio": newGlobalMetric(namespace, "channel_forward_failure_ratio", "Failed forwards relative to all resolved forwards out through the channel in the last 24 hours. Settles missed while the HTLC event stream was down are backfilled, failures are not", []string{"chan_id"}),
+			"htlc_events":                   newGlobalCounter(namespace, "htlc_events_total", "Number of HTLC events seen on the HTLC event stream by channel, direction of the HTLC through the channel (in, out), HTLC type (send, receive, forward) and outcome (forward, forward_fail, link_fail, settle). Settled forwards missed while the stream was down are backfilled from the forwarding history, other events are not", []string{"chan_id", "direction", "event_type", "outcome"}),
+			"htlc_stream_down":              newGlobalCounter(namespace, "htlc_stream_down_seconds_total", "Time the HTLC event stream was down, HTLC events other than settled forwards are missing for it", []string{}),
+		},
+	}
+}
+
+func (c *HtlcEventExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *HtlcEventExporter) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	for outcome, h := range c.forwardLatency {
+		ch <- h.metric(c.metrics["forward_resolution_seconds"], outcome)
+	}
+	c.peerHoldTime.each(func(k peerOutcome, h *histogram) {
+		ch <- h.metric(c.metrics["peer_htlc_hold_seconds"], k.pubkey, k.outcome)
+	})
+	ch <- prometheus.MustNewConstMetric(c.metrics["forwards_pending"],
+		prometheus.GaugeValue, float64(len(c.pendingForwards)))
+	ch <- prometheus.MustNewConstMetric(c.metrics["htlc_forwards_settled"],
+		prometheus.CounterValue, float64(c.forwardsSettled))
+	ch <- prometheus.MustNewConstMetric(c.metrics["htlc_stream_down"],
+		prometheus.CounterValue, c.downSeconds)
+	now := time.Now()
+	chanIds := map[uint64]bool{}
+	for _, chanId := range append(htlcFailures.channels(now), htlcSettles.channels(now)...) {
+		chanIds[chanId] = true
+	}
+	for chanId := range chanIds {
+		failed, settled := htlcFailures.count(chanId, now), htlcSettles.count(chanId, now)
+		if failed+settled == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["channel_forward_failure_ratio"],
+			prometheus.GaugeValue, float64(failed)/float64(failed+settled),
+			strconv.FormatUint(chanId, 10))
+	}
+
+	for k, count := range c.htlcEvents {
+		ch <- prometheus.MustNewConstMetric(c.metrics["htlc_events"],
+			prometheus.CounterValue, float64(count),
+			strconv.FormatUint(k.chanId, 10), k.direction, k.eventType, k.outcome)
+	}
+}
+
+// Start runs the HTLC event subscription until ctx is canceled,
+// re-subscribing whenever the stream fails.
+func (c *HtlcEventExporter) Start(ctx context.Context) {
+	runSubscription(ctx, htlcSubscription, c.subscribe)
+}
+
+func (c *HtlcEventExporter) subscribe(ctx context.Context, touch func()) error {
+	defer func() {
+		c.Lock()
+		if c.downSince.IsZero() {
+			c.downSince = time.Now()
+		}
+		c.Unlock()
+	}()
+
+	con, err := getGrpcClient(c.rpcAddr, c.tlsCertPath, c.macaroonPath)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	routerClient := routerrpc.NewRouterClient(con)
+	rpcClient := lnrpc.NewLightningClient(con)
+	subscribedAt := time.Now()
+	stream, err := routerClient.SubscribeHtlcEvents(ctx, &routerrpc.SubscribeHtlcEventsRequest{})
+	if err != nil {
+		return err
+	}
+
+	// Forwards settled while the stream was down are recovered from the
+	// forwarding history, the new stream only delivers later events.
+	if err := c.backfill(ctx, rpcClient, subscribedAt); err != nil {
+		return err
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		touch()
+		if event.GetForwardEvent() != nil && event.EventType == routerrpc.HtlcEvent_FORWARD {
+			c.loadChanPeers(ctx, rpcClient, event.OutgoingChannelId)
+		}
+		c.handleEvent(event)
+	}
+}
+
+// loadChanPeers lists the channels if the peer of chanId is unknown and
+// they weren't listed within htlcPeersReloadInterval.
+func (c *HtlcEventExporter) loadChanPeers(ctx context.Context, rpcClient lnrpc.LightningClient, chanId uint64) {
+	if _, ok := c.chanPeers.get(chanId); ok || time.Since(c.chanPeersLoaded) < htlcPeersReloadInterval {
+		return
+	}
+	c.chanPeersLoaded = time.Now()
+
+	channels, err := rpcClient.ListChannels(ctx, &lnrpc.ListChannelsRequest{})
+	if err != nil {
+		log.Printf("rpcClient.ListChannels err: %s", err)
+		return
+	}
+	for _, channel := range channels.Channels {
+		c.chanPeers.put(channel.ChanId, channel.RemotePubkey)
+	}
+}
+
+// backfill counts the forwards settled between syncedUntil and until.
+func (c *HtlcEventExporter) backfill(ctx context.Context, rpcClient lnrpc.LightningClient, until time.Time) error {
+	c.Lock()
+	since := c.syncedUntil
+	if !c.downSince.IsZero() {
+		c.downSeconds += until.Sub(c.downSince).Seconds()
+		c.downSince = time.Time{}
+	}
+	c.Unlock()
+
+	// Nothing to catch up with on the first subscription.
+	if since.IsZero() {
+		c.Lock()
+		c.syncedUntil = until
+		c.Unlock()
+		return nil
+	}
+
+	var settled []*lnrpc.ForwardingEvent
+	var latest time.Time
+	var offset uint32
+	for {
+		resp, err := rpcClient.ForwardingHistory(ctx, &lnrpc.ForwardingHistoryRequest{
+			StartTime:    uint64(since.Unix()),
+			EndTime:      uint64(until.Unix()) + 1,
+			IndexOffset:  offset,
+			NumMaxEvents: forwardBackfillPageSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, event := range resp.ForwardingEvents {
+			ts := time.Unix(0, int64(event.TimestampNs))
+			if ts.After(latest) {
+				latest = ts
+			}
+			if ts.After(since) && ts.Before(until) {
+				settled = append(settled, event)
+			}
+		}
+
+		if uint32(len(resp.ForwardingEvents)) < forwardBackfillPageSize {
+			break
+		}
+		offset = resp.LastOffsetIndex
+	}
+
+	c.Lock()
+	for _, event := range settled {
+		c.forwardsSettled++
+		htlcSettles.add(event.ChanIdOut, time.Unix(0, int64(event.TimestampNs)))
+		c.htlcEvents[htlcEventCount{chanId: event.ChanIdIn, direction: "in", eventType: "forward", outcome: "settle"}]++
+		c.htlcEvents[htlcEventCount{chanId: event.ChanIdOut, direction: "out", eventType: "forward", outcome: "settle"}]++
+	}
+	for _, ts := range []time.Time{latest, until} {
+		if ts.After(c.syncedUntil) {
+			c.syncedUntil = ts
+		}
+	}
+	c.Unlock()
+
+	updateSubscription(htlcSubscription, func(s *subscriptionState) { s.backfilled += uint64(len(settled)) })
+	return nil
+}
+
+func (c *HtlcEventExporter) handleEvent(event *routerrpc.HtlcEvent) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.countEvent(event)
+
+	if event.EventType != routerrpc.HtlcEvent_FORWARD {
+		return
+	}
+
+	key := htlcKey{
+		incomingChanId: event.IncomingChannelId,
+		incomingHtlcId: event.IncomingHtlcId,
+		outgoingChanId: event.OutgoingChannelId,
+		outgoingHtlcId: event.OutgoingHtlcId,
+	}
+	ts := time.Unix(0, int64(event.TimestampNs))
+
+	switch {
+	case event.GetForwardEvent() != nil:
+		c.pendingForwards[key] = ts
+		c.prunePendingForwards(ts)
+
+	case event.GetSettleEvent() != nil:
+		c.forwardsSettled++
+		// The next backfill starts after the forwards counted live.
+		if ts.After(c.syncedUntil) {
+			c.syncedUntil = ts
+		}
+		c.resolveForward(key, ts, "settle")
+
+	case event.GetForwardFailEvent() != nil:
+		c.resolveForward(key, ts, "fail")
+	}
+}
+
+// countEvent counts the event by channels, type and outcome. Final HTLC
+// and subscription confirmation events are not counted.
+func (c *HtlcEventExporter) countEvent(event *routerrpc.HtlcEvent) {
+	// Only forwards count towards the failure ratio of their outgoing
+	// channel, receives have none.
+	forward := event.EventType == routerrpc.HtlcEvent_FORWARD && event.OutgoingChannelId != 0
+	ts := time.Unix(0, int64(event.TimestampNs))
+
+	var outcome string
+	switch {
+	case event.GetForwardEvent() != nil:
+		outcome = "forward"
+	case event.GetForwardFailEvent() != nil:
+		outcome = "forward_fail"
+		if forward {
+			htlcFailures.add(event.OutgoingChannelId, ts)
+		}
+	case event.GetLinkFailEvent() != nil:
+		outcome = "link_fail"
+		if forward {
+			htlcFailures.add(event.OutgoingChannelId, ts)
+		}
+	case event.GetSettleEvent() != nil:
+		outcome = "settle"
+		if forward {
+			htlcSettles.add(event.OutgoingChannelId, ts)
+		}
+	default:
+		return
+	}
+
+	// Sends have no incoming and receives no outgoing channel.
+	eventType := strings.ToLower(event.EventType.String())
+	if event.IncomingChannelId != 0 {
+		c.htlcEvents[htlcEventCount{chanId: event.IncomingChannelId, direction: "in", eventType: eventType, outcome: outcome}]++
+	}
+	if event.OutgoingChannelId != 0 {
+		c.htlcEvents[htlcEventCount{chanId: event.OutgoingChannelId, direction: "out", eventType: eventType, outcome: outcome}]++
+	}
+}
+
+func (c *HtlcEventExporter) resolveForward(key htlcKey, ts time.Time, outcome string) {
+	forwardedAt, ok := c.pendingForwards[key]
+	if !ok {
+		return
+	}
+	delete(c.pendingForwards, key)
+
+	c.forwardLatency[outcome].observe(ts.Sub(forwardedAt).Seconds())
+
+	pubkey, ok := c.chanPeers.get(key.outgoingChanId)
+	if !ok {
+		pubkey = unknownPeer
+	}
+	k := peerOutcome{pubkey: pubkey, outcome: outcome}
+	h, ok := c.peerHoldTime.get(k)
+	if !ok {
+		h = newHistogram(bucketsFor("peer_htlc_hold_seconds", forwardLatencyBuckets))
+		c.peerHoldTime.put(k, h)
+	}
+	h.observe(ts.Sub(forwardedAt).Seconds())
+}
+
+func (c *HtlcEventExporter) prunePendingForwards(now time.Time) {
+	for key, forwardedAt := range c.pendingForwards {
+		if now.Sub(forwardedAt) > maxPendingForwardAge {
+			delete(c.pendingForwards, key)
+		}
+	}
+}
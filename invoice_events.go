@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// invoiceShardCountBuckets are the default histogram buckets for the
+	// number of HTLCs a settled invoice was paid with.
+	invoiceShardCountBuckets = []float64{1, 2, 3, 4, 6, 8, 12, 16, 24, 32}
+
+	// invoiceShardSizeBuckets are the default histogram buckets in
+	// satoshis for the amount of a single HTLC paying an invoice.
+	invoiceShardSizeBuckets = []float64{100, 1_000, 10_000, 50_000, 100_000, 250_000, 500_000, 1_000_000, 5_000_000}
+
+	// invoiceBackfillPageSize is the number of invoices requested per
+	// ListInvoices call when backfilling after a reconnect.
+	invoiceBackfillPageSize uint64 = 500
+
+	// largeInvoiceRetain is the number of most recent large invoices
+	// exported.
+	largeInvoiceRetain = 50
+)
+
+const invoiceSubscription = "invoices"
+
+// invoiceCheckpoint is the position in lnd's invoice stream persisted in the
+// state store.
+type invoiceCheckpoint struct {
+	AddIndex    uint64 `json:"add_index"`
+	SettleIndex uint64 `json:"settle_index"`
+}
+
+// InvoiceEventExporter subscribes to lnd's invoice updates in the background
+// and exports metrics about settled invoices on scrape.
+type InvoiceEventExporter struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	rpcAddr      string
+	tlsCertPath  string
+	macaroonPath string
+
+	shardCount map[string]*histogram
+	shardSize  map[string]*histogram
+
+	// addIndex and settleIndex are the highest add and settle index seen
+	// so far, checkpointed to state to resume the stream after restarts.
+	// syncedUntil is the time up to which settled invoices were seen, used
+	// to backfill invoices settled while the stream was down as long as no
+	// settle index is known.
+	state       *stateStore
+	stateKey    string
+	addIndex    uint64
+	settleIndex uint64
+	syncedUntil time.Time
+
+	// largeInvoices are the most recent settled invoices of at least
+	// largeThresholdSat, 0 disables them.
+	largeThresholdSat int64
+	largeInvoices     []largeInvoice
+}
+
+type largeInvoice struct {
+	settleIndex uint64
+	kind        string
+	amountSat   int64
+	settledAt   time.Time
+}
+
+func NewInvoiceEventExporter(namespace string, rpcAddr string, tlsCertPath string, macaroonPath string, state *stateStore, largeThresholdSat int64) *InvoiceEventExporter {
+	c := &InvoiceEventExporter{
+		rpcAddr:      rpcAddr,
+		tlsCertPath:  tlsCertPath,
+		macaroonPath: macaroonPath,
+		state:        state,
+		stateKey:     invoiceSubscription + "/" + rpcAddr,
+
+		largeThresholdSat: largeThresholdSat,
+
+		shardCount: map[string]*histogram{},
+		shardSize:  map[string]*histogram{},
+
+		metrics: map[string]*prometheus.Desc{
+			"invoice_settled_htlcs":     newGlobalHistogram(namespace, "invoice_settled_htlcs", "Number of HTLCs (shards) settled invoices were paid with", []string{"kind"}),
+			"invoice_settled_htlc_sats": newGlobalHistogram(namespace, "invoice_settled_htlc_satoshis", "Amount of the individual HTLCs (shards) settled invoices were paid with", []string{"kind"}),
+			"invoice_add_index":         newGlobalMetric(namespace, "invoice_add_index", "Highest invoice add index seen on the invoice stream", []string{}),
+			"invoice_settle_index":      newGlobalMetric(namespace, "invoice_settle_index", "Highest invoice settle index seen on the invoice stream", []string{}),
+			"invoice_large_settled":     newGlobalMetric(namespace, "invoice_large_settled_timestamp_seconds", "Unix time the most recent invoices paid with at least the large invoice threshold were settled, by amount bucket (power of ten lower bound in satoshis)", []string{"settle_index", "kind", "amount_bucket"}),
+		},
+	}
+
+	// The checkpoint used to be stored without the node's address.
+	var checkpoint invoiceCheckpoint
+	ok, err := state.get(c.stateKey, &checkpoint)
+	if err == nil && !ok {
+		_, err = state.get(invoiceSubscription, &checkpoint)
+	}
+	if err != nil {
+		log.Printf("invalid invoice checkpoint, starting from current invoices: %s", err)
+	}
+	c.addIndex = checkpoint.AddIndex
+	c.settleIndex = checkpoint.SettleIndex
+
+	for _, kind := range []string{"regular", "keysend", "amp"} {
+		c.shardCount[kind] = newHistogram(bucketsFor("invoice_settled_htlcs", invoiceShardCountBuckets))
+		c.shardSize[kind] = newHistogram(bucketsFor("invoice_settled_htlc_satoshis", invoiceShardSizeBuckets))
+	}
+	return c
+}
+
+func (c *InvoiceEventExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *InvoiceEventExporter) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	for kind, h := range c.shardCount {
+		ch <- h.metric(c.metrics["invoice_settled_htlcs"], kind)
+	}
+	for kind, h := range c.shardSize {
+		ch <- h.metric(c.metrics["invoice_settled_htlc_sats"], kind)
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["invoice_add_index"],
+		prometheus.GaugeValue, float64(c.addIndex))
+	ch <- prometheus.MustNewConstMetric(c.metrics["invoice_settle_index"],
+		prometheus.GaugeValue, float64(c.settleIndex))
+	for _, invoice := range c.largeInvoices {
+		ch <- prometheus.MustNewConstMetric(c.metrics["invoice_large_settled"],
+			prometheus.GaugeValue, float64(invoice.settledAt.Unix()),
+			strconv.FormatUint(invoice.settleIndex, 10), invoice.kind, amountBucket(invoice.amountSat))
+	}
+}
+
+// amountBucket returns the power of ten at or below amountSat.
+func amountBucket(amountSat int64) string {
+	bucket := int64(1)
+	for bucket <= amountSat/10 {
+		bucket *= 10
+	}
+	return strconv.FormatInt(bucket, 10)
+}
+
+// Start runs the invoice subscription until ctx is canceled, re-subscribing
+// whenever the stream fails.
+func (c *InvoiceEventExporter) Start(ctx context.Context) {
+	runSubscription(ctx, invoiceSubscription, c.subscribe)
+}
+
+func (c *InvoiceEventExporter) subscribe(ctx context.Context, touch func()) error {
+	con, err := getGrpcClient(c.rpcAddr, c.tlsCertPath, c.macaroonPath)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	rpcClient := lnrpc.NewLightningClient(con)
+	c.Lock()
+	checkpoint := invoiceCheckpoint{AddIndex: c.addIndex, SettleIndex: c.settleIndex}
+	c.Unlock()
+
+	// With a known settle index lnd replays everything missed since, be it
+	// while the stream was down or the exporter wasn't running.
+	subscribedAt := time.Now()
+	stream, err := rpcClient.SubscribeInvoices(ctx, &lnrpc.InvoiceSubscription{
+		AddIndex:    checkpoint.AddIndex,
+		SettleIndex: checkpoint.SettleIndex,
+	})
+	if err != nil {
+		return err
+	}
+
+	// Otherwise invoices settled while the stream was down are recovered
+	// from ListInvoices. Those also delivered on the new stream are skipped
+	// by their settle index.
+	if checkpoint.SettleIndex == 0 {
+		if err := c.backfill(ctx, rpcClient); err != nil {
+			return err
+		}
+	}
+	c.Lock()
+	c.syncedUntil = subscribedAt
+	c.Unlock()
+
+	for {
+		invoice, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		touch()
+		c.handleInvoice(invoice)
+	}
+}
+
+func invoiceKind(invoice *lnrpc.Invoice) string {
+	switch {
+	case invoice.IsAmp:
+		return "amp"
+	case invoice.IsKeysend:
+		return "keysend"
+	}
+	return "regular"
+}
+
+// backfill handles the invoices settled since syncedUntil. It pages
+// backwards through ListInvoices until a whole page consists of invoices
+// that expired before syncedUntil, as those can't have been settled since.
+func (c *InvoiceEventExporter) backfill(ctx context.Context, rpcClient lnrpc.LightningClient) error {
+	c.Lock()
+	since := c.syncedUntil
+	c.Unlock()
+
+	// Nothing to catch up with on the first subscription.
+	if since.IsZero() {
+		return nil
+	}
+
+	var missed []*lnrpc.Invoice
+	var offset uint64
+	for {
+		resp, err := rpcClient.ListInvoices(ctx, &lnrpc.ListInvoiceRequest{
+			IndexOffset:    offset,
+			NumMaxInvoices: invoiceBackfillPageSize,
+			Reversed:       true,
+		})
+		if err != nil {
+			return err
+		}
+
+		done := true
+		for _, invoice := range resp.Invoices {
+			if invoice.CreationDate+invoice.Expiry >= since.Unix() {
+				done = false
+			}
+			if invoice.State == lnrpc.Invoice_SETTLED && invoice.SettleDate >= since.Unix() {
+				missed = append(missed, invoice)
+			}
+		}
+
+		if done || resp.FirstIndexOffset <= 1 || uint64(len(resp.Invoices)) < invoiceBackfillPageSize {
+			break
+		}
+		offset = resp.FirstIndexOffset
+	}
+
+	sort.Slice(missed, func(i, j int) bool {
+		return missed[i].SettleIndex < missed[j].SettleIndex
+	})
+
+	var backfilled uint64
+	for _, invoice := range missed {
+		if c.handleInvoice(invoice) {
+			backfilled++
+		}
+	}
+	updateSubscription(invoiceSubscription, func(s *subscriptionState) { s.backfilled += backfilled })
+	return nil
+}
+
+// handleInvoice records a settled invoice and reports whether it wasn't
+// handled before.
+func (c *InvoiceEventExporter) handleInvoice(invoice *lnrpc.Invoice) bool {
+	c.Lock()
+	defer c.Unlock()
+
+	if invoice.AddIndex > c.addIndex {
+		c.addIndex = invoice.AddIndex
+		c.saveCheckpoint()
+	}
+
+	if invoice.State != lnrpc.Invoice_SETTLED || invoice.SettleIndex <= c.settleIndex {
+		return false
+	}
+	c.settleIndex = invoice.SettleIndex
+	c.saveCheckpoint()
+	if settledAt := time.Unix(invoice.SettleDate, 0); settledAt.After(c.syncedUntil) {
+		c.syncedUntil = settledAt
+	}
+
+	kind := invoiceKind(invoice)
+
+	shards := 0
+	for _, htlc := range invoice.Htlcs {
+		if htlc.State != lnrpc.InvoiceHTLCState_SETTLED {
+			continue
+		}
+		shards++
+		c.shardSize[kind].observe(float64(htlc.AmtMsat) / 1000)
+	}
+	c.shardCount[kind].observe(float64(shards))
+
+	if c.largeThresholdSat > 0 && invoice.AmtPaidSat >= c.largeThresholdSat {
+		c.largeInvoices = append(c.largeInvoices, largeInvoice{
+			settleIndex: invoice.SettleIndex,
+			kind:        kind,
+			amountSat:   invoice.AmtPaidSat,
+			settledAt:   time.Unix(invoice.SettleDate, 0),
+		})
+		if len(c.largeInvoices) > largeInvoiceRetain {
+			c.largeInvoices = c.largeInvoices[len(c.largeInvoices)-largeInvoiceRetain:]
+		}
+	}
+	return true
+}
+
+func (c *InvoiceEventExporter) saveCheckpoint() {
+	checkpoint := invoiceCheckpoint{AddIndex: c.addIndex, SettleIndex: c.settleIndex}
+	if err := c.state.put(c.stateKey, checkpoint); err != nil {
+		log.Printf("saving invoice checkpoint err: %s", err)
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// leaderLock is a lease held by one exporter replica at a time.
+type leaderLock interface {
+	// acquire takes the lease if it is free or expired, or renews it if
+	// identity already holds it, and reports whether identity holds it.
+	acquire(ctx context.Context, identity string, duration time.Duration) (bool, error)
+}
+
+// LeaderElector runs the active features, i.e. probes and event
+// subscriptions, only while this replica holds the lease, so that two
+// replicas pointed at the same node don't probe or count events twice.
+// Polling collectors run on every replica.
+type LeaderElector struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	lock     leaderLock
+	identity string
+	duration time.Duration
+
+	leader      bool
+	renewedAt   time.Time
+	transitions uint64
+}
+
+func NewLeaderElector(namespace string, lock leaderLock, identity string, duration time.Duration) *LeaderElector {
+	return &LeaderElector{
+		lock:     lock,
+		identity: identity,
+		duration: duration,
+
+		metrics: map[string]*prometheus.Desc{
+			"leader":             newGlobalMetric(namespace, "exporter_leader", "Whether this replica holds the leader lease and runs the active features", []string{"identity"}),
+			"leader_transitions": newGlobalCounter(namespace, "exporter_leader_transitions_total", "Number of times this replica gained or lost the leader lease", []string{"identity"}),
+		},
+	}
+}
+
+func (c *LeaderElector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *LeaderElector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["leader"],
+		prometheus.GaugeValue, boolToFloat(c.leader), c.identity)
+	ch <- prometheus.MustNewConstMetric(c.metrics["leader_transitions"],
+		prometheus.CounterValue, float64(c.transitions), c.identity)
+}
+
+// Run tries to acquire the lease every third of the lease duration until
+// ctx is canceled. start is called with a context that is canceled when the
+// lease is lost, and again whenever it is regained.
+func (c *LeaderElector) Run(ctx context.Context, start func(ctx context.Context)) {
+	go func() {
+		ticker := time.NewTicker(c.duration / 3)
+		defer ticker.Stop()
+
+		var cancel context.CancelFunc
+		for {
+			held, err := c.lock.acquire(ctx, c.identity, c.duration)
+			now := time.Now()
+
+			c.Lock()
+			if err != nil {
+				// Nobody else can take the lease before it expires, keep
+				// leading until then.
+				log.Printf("leader election err: %s", err)
+				held = c.leader && now.Sub(c.renewedAt) < c.duration
+			} else if held {
+				c.renewedAt = now
+			}
+			changed := held != c.leader
+			if changed {
+				c.leader = held
+				c.transitions++
+			}
+			c.Unlock()
+
+			if changed && held {
+				log.Printf("acquired leader lease as %s, starting active features", c.identity)
+				var leaderCtx context.Context
+				leaderCtx, cancel = context.WithCancel(ctx)
+				start(leaderCtx)
+			} else if changed {
+				log.Printf("lost leader lease as %s, stopping active features", c.identity)
+				cancel()
+			}
+
+			select {
+			case <-ctx.Done():
+				if cancel != nil {
+					cancel()
+				}
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// fileLease is a lease in a file on a volume shared by the replicas. The
+// file is replaced atomically and read back to detect a replica that took
+// the lease at the same time.
+type fileLease struct {
+	path string
+}
+
+type fileLeaseRecord struct {
+	Holder    string    `json:"holder"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+func (l *fileLease) read() (fileLeaseRecord, error) {
+	var r fileLeaseRecord
+	data, err := os.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return r, nil
+	} else if err != nil {
+		return r, err
+	}
+	if err := json.Unmarshal(data, &r); err != nil {
+		// A torn or foreign file counts as expired.
+		log.Printf("invalid lease file %s, taking it over: %s", l.path, err)
+		return fileLeaseRecord{}, nil
+	}
+	return r, nil
+}
+
+func (l *fileLease) acquire(ctx context.Context, identity string, duration time.Duration) (bool, error) {
+	r, err := l.read()
+	if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if r.Holder != "" && r.Holder != identity && now.Before(r.ExpiresAt) {
+		return false, nil
+	}
+
+	data, err := json.Marshal(fileLeaseRecord{Holder: identity, ExpiresAt: now.Add(duration)})
+	if err != nil {
+		return false, err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(l.path), filepath.Base(l.path)+".tmp")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return false, err
+	}
+	if err := tmp.Close(); err != nil {
+		return false, err
+	}
+	if err := os.Rename(tmp.Name(), l.path); err != nil {
+		return false, err
+	}
+
+	r, err = l.read()
+	if err != nil {
+		return false, err
+	}
+	return r.Holder == identity, nil
+}
+
+const (
+	kubernetesServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+	// kubernetesMicroTime is the format of the lease timestamps.
+	kubernetesMicroTime = "2006-01-02T15:04:05.000000Z07:00"
+)
+
+// kubernetesLease is a coordination.k8s.io/v1 Lease, updated with the pod's
+// service account through the API server. Concurrent updates are rejected
+// by the API server based on the resource version.
+type kubernetesLease struct {
+	// collection is the URL of the namespace's leases.
+	collection string
+	name       string
+	httpClient *http.Client
+}
+
+type kubernetesLeaseObject struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity,omitempty"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds,omitempty"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions,omitempty"`
+	} `json:"spec"`
+}
+
+// newKubernetesLease returns the lease given as <namespace>/<name>, using
+// the in-cluster API server address and service account.
+func newKubernetesLease(lease string, timeout time.Duration) (*kubernetesLease, error) {
+	namespace, name, ok := strings.Cut(lease, "/")
+	if !ok || namespace == "" || name == "" {
+		return nil, fmt.Errorf("invalid lease %q, expected <namespace>/<name>", lease)
+	}
+
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in a Kubernetes pod, KUBERNETES_SERVICE_HOST is not set")
+	}
+	ca, err := os.ReadFile(filepath.Join(kubernetesServiceAccountDir, "ca.crt"))
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificates in service account ca.crt")
+	}
+
+	return &kubernetesLease{
+		collection: fmt.Sprintf("https://%s:%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", host, port, namespace),
+		name:       name,
+		httpClient: &http.Client{
+			Timeout:   timeout,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+func (l *kubernetesLease) do(ctx context.Context, method string, url string, in interface{}, out interface{}) (int, error) {
+	var body bytes.Buffer
+	if in != nil {
+		if err := json.NewEncoder(&body).Encode(in); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, method, url, &body)
+	if err != nil {
+		return 0, err
+	}
+	// The projected service account token is rotated, read it per request.
+	token, err := os.ReadFile(filepath.Join(kubernetesServiceAccountDir, "token"))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := l.httpClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		return resp.StatusCode, nil
+	}
+	return resp.StatusCode, json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (l *kubernetesLease) acquire(ctx context.Context, identity string, duration time.Duration) (bool, error) {
+	var lease kubernetesLeaseObject
+	url := l.collection + "/" + l.name
+	status, err := l.do(ctx, http.MethodGet, url, nil, &lease)
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+	switch status {
+	case http.StatusOK:
+		renewed, _ := time.Parse(kubernetesMicroTime, lease.Spec.RenewTime)
+		expires := renewed.Add(time.Duration(lease.Spec.LeaseDurationSeconds) * time.Second)
+		if lease.Spec.HolderIdentity != "" && lease.Spec.HolderIdentity != identity && now.Before(expires) {
+			return false, nil
+		}
+		if lease.Spec.HolderIdentity != identity {
+			lease.Spec.AcquireTime = now.UTC().Format(kubernetesMicroTime)
+			lease.Spec.LeaseTransitions++
+		}
+	case http.StatusNotFound:
+		lease.APIVersion, lease.Kind = "coordination.k8s.io/v1", "Lease"
+		lease.Metadata.Name = l.name
+		lease.Spec.AcquireTime = now.UTC().Format(kubernetesMicroTime)
+	default:
+		return false, fmt.Errorf("get lease: unexpected status %d", status)
+	}
+
+	lease.Spec.HolderIdentity = identity
+	lease.Spec.LeaseDurationSeconds = int((duration + time.Second - 1) / time.Second)
+	lease.Spec.RenewTime = now.UTC().Format(kubernetesMicroTime)
+
+	method := http.MethodPut
+	if status == http.StatusNotFound {
+		method, url = http.MethodPost, l.collection
+	}
+	status, err = l.do(ctx, method, url, &lease, &lease)
+	if err != nil {
+		return false, err
+	}
+	switch status {
+	case http.StatusOK, http.StatusCreated:
+		return true, nil
+	case http.StatusConflict:
+		// Another replica updated the lease since we read it.
+		return false, nil
+	default:
+		return false, fmt.Errorf("%s lease: unexpected status %d", strings.ToLower(method), status)
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import "log"
+
+// lowMemory* are the settings of the -low-memory profile, for small
+// machines like a Raspberry Pi that run the exporter next to lnd.
+var (
+	lowMemoryCacheMaxEntries    = 1000
+	lowMemoryForwardingPageSize = uint32(1000)
+	lowMemoryListPageSize       = uint64(100)
+	lowMemoryDisabledCollectors = []string{"network"}
+)
+
+// applyLowMemoryProfile shrinks caches and RPC page sizes and disables the
+// collectors that walk the network graph. Settings given explicitly on
+// the command line or through their environment variable are kept.
+//
+// ListChannels has no paginated or streaming variant, its response is held
+// once per scrape and shared by all collectors.
+func applyLowMemoryProfile(explicitFlags map[string]bool, collectors map[string]bool) {
+	if !explicitFlags["cache.max-entries"] {
+		cacheMaxEntries = lowMemoryCacheMaxEntries
+	}
+
+	forwardingPageSize = lowMemoryForwardingPageSize
+	forwardBackfillPageSize = lowMemoryForwardingPageSize
+	invoicePageSize = lowMemoryListPageSize
+	invoiceBackfillPageSize = lowMemoryListPageSize
+	paymentPageSize = lowMemoryListPageSize
+
+	for _, name := range lowMemoryDisabledCollectors {
+		if !explicitFlags["collector."+name] {
+			collectors[name] = false
+		}
+	}
+
+	log.Printf("low memory profile: cache size %d, page size %d, disabled collectors %v",
+		cacheMaxEntries, lowMemoryListPageSize, lowMemoryDisabledCollectors)
+}
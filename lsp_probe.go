@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// lspTarget is a Lightning Service Provider node to probe, configured as
+// pubkey[@host:port].
+type lspTarget struct {
+	pubkey string
+	host   string
+}
+
+func parseLspTargets(s string) ([]lspTarget, error) {
+	var targets []lspTarget
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		pubkey, host, _ := strings.Cut(spec, "@")
+		if _, err := hex.DecodeString(pubkey); err != nil || len(pubkey) != 66 {
+			return nil, fmt.Errorf("invalid lsp pubkey %q", pubkey)
+		}
+		targets = append(targets, lspTarget{pubkey: pubkey, host: host})
+	}
+	return targets, nil
+}
+
+type lspProbeResult struct {
+	success  bool
+	duration time.Duration
+}
+
+// LspProbeExporter periodically checks connectivity and routability to a
+// set of LSP nodes and exports the outcome of the last probe.
+type LspProbeExporter struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	rpcAddr      string
+	tlsCertPath  string
+	macaroonPath string
+
+	targets      []lspTarget
+	amountSat    int64
+	probePayment bool
+	interval     time.Duration
+	timeout      time.Duration
+	budget       *ProbeBudget
+	active       *ActiveActions
+
+	// results holds the outcome of the last probe per lsp and step.
+	results     map[string]map[string]lspProbeResult
+	routeFees   map[string]int64
+	lastProbe   map[string]time.Time
+	probeErrors map[string]map[string]uint64
+}
+
+func NewLspProbeExporter(namespace string, rpcAddr string, tlsCertPath string, macaroonPath string, targets []lspTarget, amountSat int64, probePayment bool, interval time.Duration, timeout time.Duration, budget *ProbeBudget, active *ActiveActions) *LspProbeExporter {
+	return &LspProbeExporter{
+		rpcAddr:      rpcAddr,
+		tlsCertPath:  tlsCertPath,
+		macaroonPath: macaroonPath,
+
+		targets:      targets,
+		amountSat:    amountSat,
+		probePayment: probePayment,
+		interval:     interval,
+		timeout:      timeout,
+		budget:       budget,
+		active:       active,
+
+		results:     map[string]map[string]lspProbeResult{},
+		routeFees:   map[string]int64{},
+		lastProbe:   map[string]time.Time{},
+		probeErrors: map[string]map[string]uint64{},
+
+		metrics: map[string]*prometheus.Desc{
+			"lsp_probe_success":          newGlobalMetric(namespace, "lsp_probe_success", "Whether the last probe step against the LSP succeeded", []string{"lsp", "step"}),
+			"lsp_probe_duration_seconds": newGlobalMetric(namespace, "lsp_probe_duration_seconds", "Duration of the last probe step against the LSP", []string{"lsp", "step"}),
+			"lsp_probe_failures_total":   newGlobalCounter(namespace, "lsp_probe_failures_total", "Number of failed probe steps against the LSP", []string{"lsp", "step"}),
+			"lsp_probe_route_fee_msat":   newGlobalMetric(namespace, "lsp_probe_route_fee_msat", "Fee of the best route to the LSP found by the last probe", []string{"lsp"}),
+			"lsp_probe_last_timestamp":   newGlobalMetric(namespace, "lsp_probe_last_timestamp_seconds", "Unix time of the last probe against the LSP", []string{"lsp"}),
+		},
+	}
+}
+
+func (c *LspProbeExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *LspProbeExporter) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	for lsp, steps := range c.results {
+		for step, res := range steps {
+			ch <- prometheus.MustNewConstMetric(c.metrics["lsp_probe_success"],
+				prometheus.GaugeValue, boolToFloat(res.success), lsp, step)
+			ch <- prometheus.MustNewConstMetric(c.metrics["lsp_probe_duration_seconds"],
+				prometheus.GaugeValue, res.duration.Seconds(), lsp, step)
+			ch <- prometheus.MustNewConstMetric(c.metrics["lsp_probe_failures_total"],
+				prometheus.CounterValue, float64(c.probeErrors[lsp][step]), lsp, step)
+		}
+	}
+	for lsp, fee := range c.routeFees {
+		ch <- prometheus.MustNewConstMetric(c.metrics["lsp_probe_route_fee_msat"],
+			prometheus.GaugeValue, float64(fee), lsp)
+	}
+	for lsp, ts := range c.lastProbe {
+		ch <- prometheus.MustNewConstMetric(c.metrics["lsp_probe_last_timestamp"],
+			prometheus.GaugeValue, float64(ts.Unix()), lsp)
+	}
+}
+
+// Start probes all LSPs every interval until ctx is canceled.
+func (c *LspProbeExporter) Start(ctx context.Context) {
+	registerRefresh("lsp_probe", func(ctx context.Context) error {
+		c.probeAll(ctx)
+		return nil
+	})
+
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			c.probeAll(ctx)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *LspProbeExporter) probeAll(ctx context.Context) {
+	con, err := getGrpcClient(c.rpcAddr, c.tlsCertPath, c.macaroonPath)
+	if err != nil {
+		log.Printf("lsp probe getGrpcClient() err: %s", err)
+		return
+	}
+	defer con.Close()
+
+	rpcClient := lnrpc.NewLightningClient(con)
+	routerClient := routerrpc.NewRouterClient(con)
+
+	for _, target := range c.targets {
+		probeCtx, cancel := context.WithTimeout(ctx, c.timeout)
+		c.probe(probeCtx, rpcClient, routerClient, target)
+		cancel()
+	}
+}
+
+func (c *LspProbeExporter) probe(ctx context.Context, rpcClient lnrpc.LightningClient, routerClient routerrpc.RouterClient, target lspTarget) {
+	c.setLastProbe(target.pubkey, time.Now())
+
+	if target.host != "" && c.active.allow("lsp_probe", "connect", fmt.Sprintf("connect to %s@%s", target.pubkey, target.host)) {
+		start := time.Now()
+		_, err := rpcClient.ConnectPeer(ctx, &lnrpc.ConnectPeerRequest{
+			Addr: &lnrpc.LightningAddress{Pubkey: target.pubkey, Host: target.host},
+		})
+		// lnd reports an error if we are already connected, which is
+		// what we want to know.
+		if err != nil && strings.Contains(err.Error(), "already connected") {
+			err = nil
+		}
+		c.record(target.pubkey, "connect", start, err)
+	}
+
+	start := time.Now()
+	routes, err := rpcClient.QueryRoutes(ctx, &lnrpc.QueryRoutesRequest{
+		PubKey: target.pubkey,
+		Amt:    c.amountSat,
+	})
+	if err == nil && len(routes.Routes) == 0 {
+		err = fmt.Errorf("no route found")
+	}
+	c.record(target.pubkey, "route", start, err)
+	if err != nil {
+		return
+	}
+
+	route := routes.Routes[0]
+	c.Lock()
+	c.routeFees[target.pubkey] = route.TotalFeesMsat
+	c.Unlock()
+
+	if !c.probePayment {
+		return
+	}
+
+	if !c.active.allow("lsp_probe", "payment", fmt.Sprintf("send a %d msat probe payment to %s", route.TotalAmtMsat, target.pubkey)) {
+		return
+	}
+
+	if err := c.budget.spend("lsp", route.TotalAmtMsat, route.TotalFeesMsat); err != nil {
+		log.Printf("lsp probe %s skipping payment: %s", target.pubkey, err)
+		return
+	}
+
+	// Send an HTLC along the route with a random payment hash. The LSP can't
+	// settle it, so it costs nothing, but an unknown payment hash failure
+	// from the destination proves the route is usable end to end.
+	start = time.Now()
+	err = sendProbe(ctx, routerClient, route)
+	c.record(target.pubkey, "payment", start, err)
+}
+
+// sendProbe sends a payment along route that can't be settled by the
+// destination and returns nil if it reached the destination.
+func sendProbe(ctx context.Context, routerClient routerrpc.RouterClient, route *lnrpc.Route) error {
+	paymentHash := make([]byte, 32)
+	if _, err := rand.Read(paymentHash); err != nil {
+		return err
+	}
+
+	attempt, err := routerClient.SendToRouteV2(ctx, &routerrpc.SendToRouteRequest{
+		PaymentHash: paymentHash,
+		Route:       route,
+	})
+	if err != nil {
+		return err
+	}
+	if code := attempt.GetFailure().GetCode(); code != lnrpc.Failure_INCORRECT_OR_UNKNOWN_PAYMENT_DETAILS {
+		return fmt.Errorf("probe payment failed: %s", code)
+	}
+	return nil
+}
+
+func (c *LspProbeExporter) setLastProbe(lsp string, ts time.Time) {
+	c.Lock()
+	defer c.Unlock()
+	c.lastProbe[lsp] = ts
+}
+
+func (c *LspProbeExporter) record(lsp string, step string, start time.Time, err error) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.results[lsp] == nil {
+		c.results[lsp] = map[string]lspProbeResult{}
+		c.probeErrors[lsp] = map[string]uint64{}
+	}
+	c.results[lsp][step] = lspProbeResult{success: err == nil, duration: time.Since(start)}
+	if err != nil {
+		log.Printf("lsp probe %s %s err: %s", lsp, step, err)
+		c.probeErrors[lsp][step]++
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/macaroon.v2"
+)
+
+// timeBeforeCaveat is the condition of the first-party caveat lnd adds to
+// macaroons baked with a timeout, e.g. by lncli bakemacaroon --timeout.
+const timeBeforeCaveat = "time-before "
+
+// MacaroonExpiryExporter exports when the time limited macaroons the
+// exporter uses expire, so that monitoring doesn't break silently. The
+// files are read on every scrape to pick up rotated macaroons.
+type MacaroonExpiryExporter struct {
+	metrics map[string]*prometheus.Desc
+
+	paths []string
+}
+
+func NewMacaroonExpiryExporter(namespace string, paths []string) *MacaroonExpiryExporter {
+	return &MacaroonExpiryExporter{
+		paths: paths,
+
+		metrics: map[string]*prometheus.Desc{
+			"expiry_timestamp": newGlobalMetric(namespace, "macaroon_expiry_timestamp_seconds", "Earliest time-before caveat of the macaroon, only exported for time limited macaroons", []string{"macaroon_path"}),
+			"expiry_days":      newGlobalMetric(namespace, "macaroon_expiry_days_remaining", "Days until the earliest time-before caveat of the macaroon, negative once expired", []string{"macaroon_path"}),
+		},
+	}
+}
+
+func (c *MacaroonExpiryExporter) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *MacaroonExpiryExporter) Collect(ch chan<- prometheus.Metric) {
+	for _, path := range c.paths {
+		expiry, ok, err := macaroonExpiry(path)
+		if err != nil {
+			log.Printf("macaroon expiry %s err: %s", path, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.metrics["expiry_timestamp"],
+			prometheus.GaugeValue, float64(expiry.Unix()), path)
+		ch <- prometheus.MustNewConstMetric(c.metrics["expiry_days"],
+			prometheus.GaugeValue, time.Until(expiry).Hours()/24, path)
+	}
+}
+
+// macaroonExpiry returns the earliest time-before caveat of the macaroon
+// file, ok is false if it has none.
+func macaroonExpiry(path string) (expiry time.Time, ok bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	mac := &macaroon.Macaroon{}
+	if err := mac.UnmarshalBinary(data); err != nil {
+		return time.Time{}, false, err
+	}
+
+	for _, caveat := range mac.Caveats() {
+		// Third-party caveats have a verification id, their condition
+		// is opaque.
+		if len(caveat.VerificationId) > 0 {
+			continue
+		}
+		cond, found := strings.CutPrefix(string(caveat.Id), timeBeforeCaveat)
+		if !found {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(cond))
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if !ok || t.Before(expiry) {
+			expiry, ok = t, true
+		}
+	}
+	return expiry, ok, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+type metricMetadata struct {
+	Name      string   `json:"name"`
+	Type      string   `json:"type"`
+	Help      string   `json:"help"`
+	Labels    []string `json:"labels"`
+	Collector string   `json:"collector"`
+
+	// field is set for the metrics configured with field_metrics.
+	field bool
+}
+
+// describedMetrics holds name, type, help and labels of every metric created
+// by newGlobalMetric, and the lnd collector it belongs to once known.
+var describedMetrics = struct {
+	sync.Mutex
+	m map[*prometheus.Desc]metricMetadata
+}{m: map[*prometheus.Desc]metricMetadata{}}
+
+func registerMetricMetadata(desc *prometheus.Desc, name string, metricType string, help string, labels []string) {
+	describedMetrics.Lock()
+	defer describedMetrics.Unlock()
+	describedMetrics.m[desc] = metricMetadata{Name: name, Type: metricType, Help: help, Labels: labels}
+}
+
+// setMetricCollector records name as the collector of the metrics of c,
+// for the lnd collectors registered together under one registry name.
+func setMetricCollector(c interface{ Describe(chan<- *prometheus.Desc) }, name string) {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	describedMetrics.Lock()
+	defer describedMetrics.Unlock()
+	for desc := range ch {
+		if meta, ok := describedMetrics.m[desc]; ok {
+			meta.Collector = name
+			describedMetrics.m[desc] = meta
+		}
+	}
+}
+
+// markFieldMetric records that desc was configured with field_metrics.
+func markFieldMetric(desc *prometheus.Desc) {
+	describedMetrics.Lock()
+	defer describedMetrics.Unlock()
+	meta := describedMetrics.m[desc]
+	meta.field = true
+	describedMetrics.m[desc] = meta
+}
+
+// fieldMetricCollisions returns an error if a field metric of c has the
+// name of a built-in metric of c or of names, which maps the names of the
+// collectors registered before to whether they are field metrics.
+func fieldMetricCollisions(c prometheus.Collector, names map[string]bool) error {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+
+	describedMetrics.Lock()
+	defer describedMetrics.Unlock()
+
+	var err error
+	for desc := range ch {
+		meta, ok := describedMetrics.m[desc]
+		if !ok {
+			continue
+		}
+		if field, seen := names[meta.Name]; seen && field != meta.field && err == nil {
+			err = fmt.Errorf("field_metrics: %s collides with a built-in metric", meta.Name)
+		}
+		names[meta.Name] = meta.field
+	}
+	return err
+}
+
+// MetadataRegistry registers collectors under a name and serves metadata
+// about all metrics they export. It wraps the gatherer serving /metrics to
+// keep the series of the last scrapes.
+type MetadataRegistry struct {
+	sync.Mutex
+
+	registry    *prometheus.Registry
+	collectors  map[string]prometheus.Collector
+	constLabels map[string][]string
+
+	// names maps the names of the registered metrics to whether they
+	// are field metrics.
+	names map[string]bool
+
+	// previous and current are the series of the last two scrapes, for
+	// the delta served by serveDelta.
+	previous *seriesSnapshot
+	current  *seriesSnapshot
+}
+
+func NewMetadataRegistry(registry *prometheus.Registry) *MetadataRegistry {
+	return &MetadataRegistry{
+		registry:    registry,
+		collectors:  map[string]prometheus.Collector{},
+		constLabels: map[string][]string{},
+		names:       map[string]bool{},
+	}
+}
+
+// MustRegister registers the collector with the underlying registry.
+func (r *MetadataRegistry) MustRegister(name string, c prometheus.Collector) {
+	r.checkNames(c)
+	r.registry.MustRegister(c)
+
+	r.Lock()
+	defer r.Unlock()
+	r.collectors[name] = c
+}
+
+// checkNames exits if a field metric of the collector collides with a
+// built-in metric, before the registry panics on the duplicate.
+func (r *MetadataRegistry) checkNames(c prometheus.Collector) {
+	r.Lock()
+	defer r.Unlock()
+	if err := fieldMetricCollisions(c, r.names); err != nil {
+		log.Fatalf("invalid config: %s", err)
+	}
+}
+
+// MustRegisterWith registers the collector with the underlying registry,
+// adding labels to all its metrics.
+func (r *MetadataRegistry) MustRegisterWith(name string, labels prometheus.Labels, c prometheus.Collector) {
+	r.checkNames(c)
+	prometheus.WrapRegistererWith(labels, r.registry).MustRegister(c)
+
+	var labelNames []string
+	for l := range labels {
+		labelNames = append(labelNames, l)
+	}
+	sort.Strings(labelNames)
+
+	r.Lock()
+	defer r.Unlock()
+	r.collectors[name] = c
+	r.constLabels[name] = labelNames
+}
+
+// Gather implements prometheus.Gatherer.
+func (r *MetadataRegistry) Gather() ([]*dto.MetricFamily, error) {
+	families, err := r.registry.Gather()
+
+	r.Lock()
+	defer r.Unlock()
+	r.previous, r.current = r.current, newSeriesSnapshot(families)
+	return families, err
+}
+
+func (r *MetadataRegistry) metadata() []metricMetadata {
+	r.Lock()
+	defer r.Unlock()
+
+	describedMetrics.Lock()
+	defer describedMetrics.Unlock()
+
+	var res []metricMetadata
+	for name, c := range r.collectors {
+		ch := make(chan *prometheus.Desc)
+		go func() {
+			c.Describe(ch)
+			close(ch)
+		}()
+		for desc := range ch {
+			meta, ok := describedMetrics.m[desc]
+			if !ok {
+				continue
+			}
+			if meta.Collector == "" {
+				meta.Collector = name
+			}
+			if constLabels := r.constLabels[name]; len(constLabels) > 0 {
+				meta.Labels = append(append([]string{}, meta.Labels...), constLabels...)
+			}
+			res = append(res, meta)
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Name < res[j].Name
+	})
+	return res
+}
+
+// ServeHTTP serves the metric metadata as JSON, or as text if requested
+// with ?format=text or an Accept header preferring text/plain.
+func (r *MetadataRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	metadata := r.metadata()
+
+	format := req.URL.Query().Get("format")
+	if format == "" && strings.HasPrefix(req.Header.Get("Accept"), "text/plain") {
+		format = "text"
+	}
+
+	if format == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		for _, m := range metadata {
+			fmt.Fprintf(w, "# HELP %s %s\n", m.Name, m.Help)
+			fmt.Fprintf(w, "# TYPE %s %s\n", m.Name, m.Type)
+			fmt.Fprintf(w, "# LABELS %s %s\n", m.Name, strings.Join(m.Labels, ","))
+			fmt.Fprintf(w, "# COLLECTOR %s %s\n", m.Name, m.Collector)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status": "success",
+		"data":   metadata,
+	})
+}
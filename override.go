@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsHandler serves the registry on the metrics path. With
+// ?fwd_lookback=<duration> it also exports the forwards within that window
+// before now, for ad-hoc investigations without changing the running
+// config. The window is bounded by -forwarding.max-query-lookback.
+type metricsHandler struct {
+	registry prometheus.Gatherer
+
+	exporter    *LndExporter
+	maxLookback time.Duration
+
+	// windowMetrics are created once, newGlobalMetric keeps track of
+	// every Desc it returns.
+	windowMetrics map[string]*prometheus.Desc
+}
+
+func newMetricsHandler(registry prometheus.Gatherer, namespace string, exporter *LndExporter, maxLookback time.Duration) *metricsHandler {
+	return &metricsHandler{
+		registry:    registry,
+		exporter:    exporter,
+		maxLookback: maxLookback,
+
+		windowMetrics: map[string]*prometheus.Desc{
+			"window_forwards":         newGlobalMetric(namespace, "forwarding_window_forwards", "Number of settled forwards within the window requested with ?fwd_lookback", []string{"window"}),
+			"window_forwarded_msat":   newGlobalMetric(namespace, "forwarding_window_forwarded_msat", "Amount forwarded within the window requested with ?fwd_lookback", []string{"window"}),
+			"window_fees_msat":        newGlobalMetric(namespace, "forwarding_window_fees_msat", "Fees earned within the window requested with ?fwd_lookback", []string{"window"}),
+			"window_channel_forwards": newGlobalMetric(namespace, "forwarding_window_channel_forwards", "Number of settled forwards through the channel within the window requested with ?fwd_lookback, by whether it was the incoming (in) or outgoing (out) channel", []string{"window", "chan_id", "peer_alias", "direction"}),
+			"window_channel_fees":     newGlobalMetric(namespace, "forwarding_window_channel_fees_msat", "Fees earned forwarding out through the channel within the window requested with ?fwd_lookback", []string{"window", "chan_id", "peer_alias"}),
+		},
+	}
+}
+
+func (h *metricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	param := r.URL.Query().Get("fwd_lookback")
+	if param == "" {
+		promhttp.HandlerFor(h.registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+		return
+	}
+
+	lookback, err := h.parseLookback(param)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	window := prometheus.NewRegistry()
+	window.MustRegister(&forwardingWindowCollector{
+		metrics:  h.windowMetrics,
+		exporter: h.exporter,
+		window:   param,
+		lookback: lookback,
+	})
+	promhttp.HandlerFor(prometheus.Gatherers{h.registry, window}, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+func (h *metricsHandler) parseLookback(param string) (time.Duration, error) {
+	if h.exporter == nil {
+		return 0, fmt.Errorf("fwd_lookback is only supported for the node given by -rpc.addr")
+	}
+	if h.maxLookback <= 0 {
+		return 0, fmt.Errorf("fwd_lookback is disabled, see -forwarding.max-query-lookback")
+	}
+	lookback, err := time.ParseDuration(param)
+	if err != nil || lookback <= 0 {
+		return 0, fmt.Errorf("invalid fwd_lookback %q, expected a positive duration like 1h", param)
+	}
+	if lookback > h.maxLookback {
+		return 0, fmt.Errorf("fwd_lookback %s exceeds the maximum of %s", lookback, h.maxLookback)
+	}
+	return lookback, nil
+}
+
+// forwardingWindowCollector exports the forwards within lookback before the
+// scrape, labeled with the requested window. Unlike the forwarding
+// counters they are gauges computed from scratch per request.
+type forwardingWindowCollector struct {
+	metrics map[string]*prometheus.Desc
+
+	exporter *LndExporter
+	window   string
+	lookback time.Duration
+}
+
+func (c *forwardingWindowCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *forwardingWindowCollector) Collect(ch chan<- prometheus.Metric) {
+	client, err := c.exporter.lightningClient()
+	if err != nil {
+		log.Printf("forwarding window getGrpcClient() err: %s", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.exporter.timeout)
+	defer cancel()
+
+	type channelWindow struct {
+		alias                   string
+		forwardsIn, forwardsOut uint64
+		feesMsat                uint64
+	}
+	channels := map[uint64]*channelWindow{}
+	channel := func(chanId uint64, alias string) *channelWindow {
+		cw, ok := channels[chanId]
+		if !ok {
+			cw = &channelWindow{alias: alias}
+			channels[chanId] = cw
+		}
+		return cw
+	}
+
+	var forwards, forwardedMsat, feesMsat uint64
+	end := time.Now()
+	var offset uint32
+	for {
+		resp, err := client.ForwardingHistory(ctx, &lnrpc.ForwardingHistoryRequest{
+			StartTime:       uint64(end.Add(-c.lookback).Unix()),
+			EndTime:         uint64(end.Unix()),
+			IndexOffset:     offset,
+			NumMaxEvents:    forwardingPageSize,
+			PeerAliasLookup: true,
+		})
+		if err != nil {
+			log.Printf("forwarding window rpcClient.ForwardingHistory err: %s", err)
+			return
+		}
+
+		for _, f := range resp.ForwardingEvents {
+			forwards++
+			forwardedMsat += f.AmtOutMsat
+			feesMsat += f.FeeMsat
+
+			channel(f.ChanIdIn, f.PeerAliasIn).forwardsIn++
+			out := channel(f.ChanIdOut, f.PeerAliasOut)
+			out.forwardsOut++
+			out.feesMsat += f.FeeMsat
+		}
+
+		if uint32(len(resp.ForwardingEvents)) < forwardingPageSize {
+			break
+		}
+		offset = resp.LastOffsetIndex
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["window_forwards"],
+		prometheus.GaugeValue, float64(forwards), c.window)
+	ch <- prometheus.MustNewConstMetric(c.metrics["window_forwarded_msat"],
+		prometheus.GaugeValue, float64(forwardedMsat), c.window)
+	ch <- prometheus.MustNewConstMetric(c.metrics["window_fees_msat"],
+		prometheus.GaugeValue, float64(feesMsat), c.window)
+
+	for chanId, cw := range channels {
+		id := strconv.FormatUint(chanId, 10)
+		ch <- prometheus.MustNewConstMetric(c.metrics["window_channel_forwards"],
+			prometheus.GaugeValue, float64(cw.forwardsIn), c.window, id, cw.alias, "in")
+		ch <- prometheus.MustNewConstMetric(c.metrics["window_channel_forwards"],
+			prometheus.GaugeValue, float64(cw.forwardsOut), c.window, id, cw.alias, "out")
+		ch <- prometheus.MustNewConstMetric(c.metrics["window_channel_fees"],
+			prometheus.GaugeValue, float64(cw.feesMsat), c.window, id, cw.alias)
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/lightningnetwork/lnd/lnrpc/routerrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const paymentSubscription = "payments"
+
+// inFlightPayment is a sent payment that neither succeeded nor failed yet.
+type inFlightPayment struct {
+	valueMsat int64
+	createdAt time.Time
+}
+
+// PaymentEventExporter tracks the payments sent by the node with lnd's
+// payment stream in the background and exports the ones in flight on
+// scrape, so that stuck payments can be alerted on.
+type PaymentEventExporter struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	rpcAddr      string
+	tlsCertPath  string
+	macaroonPath string
+
+	inFlight map[string]inFlightPayment
+}
+
+func NewPaymentEventExporter(namespace string, rpcAddr string, tlsCertPath string, macaroonPath string) *PaymentEventExporter {
+	return &PaymentEventExporter{
+		rpcAddr:      rpcAddr,
+		tlsCertPath:  tlsCertPath,
+		macaroonPath: macaroonPath,
+
+		inFlight: map[string]inFlightPayment{},
+
+		metrics: map[string]*prometheus.Desc{
+			"payments_in_flight":                newGlobalMetric(namespace, "payments_in_flight", "Number of sent payments in flight", []string{}),
+			"payments_in_flight_msat":           newGlobalMetric(namespace, "payments_in_flight_msat", "Amount of the sent payments in flight, excluding fees", []string{}),
+			"payments_in_flight_oldest_seconds": newGlobalMetric(namespace, "payments_in_flight_oldest_seconds", "Time since the oldest payment in flight was created, 0 if there is none", []string{}),
+		},
+	}
+}
+
+func (c *PaymentEventExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *PaymentEventExporter) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	var amountMsat int64
+	var oldest time.Duration
+	for _, p := range c.inFlight {
+		amountMsat += p.valueMsat
+		if age := time.Since(p.createdAt); age > oldest {
+			oldest = age
+		}
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["payments_in_flight"],
+		prometheus.GaugeValue, float64(len(c.inFlight)))
+	ch <- prometheus.MustNewConstMetric(c.metrics["payments_in_flight_msat"],
+		prometheus.GaugeValue, float64(amountMsat))
+	ch <- prometheus.MustNewConstMetric(c.metrics["payments_in_flight_oldest_seconds"],
+		prometheus.GaugeValue, oldest.Seconds())
+}
+
+// Start runs the payment subscription until ctx is canceled,
+// re-subscribing whenever the stream fails.
+func (c *PaymentEventExporter) Start(ctx context.Context) {
+	runSubscription(ctx, paymentSubscription, c.subscribe)
+}
+
+func (c *PaymentEventExporter) subscribe(ctx context.Context, touch func()) error {
+	con, err := getGrpcClient(c.rpcAddr, c.tlsCertPath, c.macaroonPath)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	stream, err := routerrpc.NewRouterClient(con).TrackPayments(ctx, &routerrpc.TrackPaymentsRequest{})
+	if err != nil {
+		return err
+	}
+
+	// The stream only delivers payments updated after subscribing, the
+	// ones already in flight are looked up once.
+	if err := c.loadInFlight(ctx, lnrpc.NewLightningClient(con)); err != nil {
+		return err
+	}
+
+	for {
+		payment, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		touch()
+		c.handlePayment(payment)
+	}
+}
+
+// loadInFlight replaces the payments in flight with the ones listed by
+// ListPayments.
+func (c *PaymentEventExporter) loadInFlight(ctx context.Context, rpcClient lnrpc.LightningClient) error {
+	inFlight := map[string]inFlightPayment{}
+	var offset uint64
+	for {
+		resp, err := rpcClient.ListPayments(ctx, &lnrpc.ListPaymentsRequest{
+			IncludeIncomplete: true,
+			IndexOffset:       offset,
+			MaxPayments:       paymentPageSize,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, payment := range resp.Payments {
+			if payment.Status == lnrpc.Payment_IN_FLIGHT {
+				inFlight[payment.PaymentHash] = inFlightPayment{
+					valueMsat: payment.ValueMsat,
+					createdAt: time.Unix(0, payment.CreationTimeNs),
+				}
+			}
+		}
+
+		if uint64(len(resp.Payments)) < paymentPageSize {
+			break
+		}
+		offset = resp.LastIndexOffset
+	}
+
+	c.Lock()
+	c.inFlight = inFlight
+	c.Unlock()
+	return nil
+}
+
+func (c *PaymentEventExporter) handlePayment(payment *lnrpc.Payment) {
+	c.Lock()
+	defer c.Unlock()
+
+	if payment.Status != lnrpc.Payment_IN_FLIGHT {
+		delete(c.inFlight, payment.PaymentHash)
+		return
+	}
+	c.inFlight[payment.PaymentHash] = inFlightPayment{
+		valueMsat: payment.ValueMsat,
+		createdAt: time.Unix(0, payment.CreationTimeNs),
+	}
+}
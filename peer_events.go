@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const peerSubscription = "peer events"
+
+type peerEventCounts struct {
+	online  uint64
+	offline uint64
+}
+
+// PeerEventExporter counts lnd's peer online and offline events in the
+// background, catching the flaps that happen between scrapes.
+type PeerEventExporter struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	rpcAddr      string
+	tlsCertPath  string
+	macaroonPath string
+
+	// counts are bounded as any node can connect, counters of evicted
+	// peers start from zero again.
+	counts *lruCache[string, *peerEventCounts]
+}
+
+func NewPeerEventExporter(namespace string, rpcAddr string, tlsCertPath string, macaroonPath string) *PeerEventExporter {
+	return &PeerEventExporter{
+		rpcAddr:      rpcAddr,
+		tlsCertPath:  tlsCertPath,
+		macaroonPath: macaroonPath,
+
+		counts: newLruCache[string, *peerEventCounts]("peer_events", rpcAddr),
+
+		metrics: map[string]*prometheus.Desc{
+			"peer_online_events_total":  newGlobalCounter(namespace, "peer_online_events_total", "Number of times the peer came online seen on the peer event stream", []string{"remote_pubkey"}),
+			"peer_offline_events_total": newGlobalCounter(namespace, "peer_offline_events_total", "Number of times the peer went offline seen on the peer event stream", []string{"remote_pubkey"}),
+		},
+	}
+}
+
+func (c *PeerEventExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *PeerEventExporter) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.counts.each(func(pubkey string, counts *peerEventCounts) {
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_online_events_total"],
+			prometheus.CounterValue, float64(counts.online), pubkey)
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_offline_events_total"],
+			prometheus.CounterValue, float64(counts.offline), pubkey)
+	})
+}
+
+// Start runs the peer event subscription until ctx is canceled,
+// re-subscribing whenever the stream fails.
+func (c *PeerEventExporter) Start(ctx context.Context) {
+	runSubscription(ctx, peerSubscription, c.subscribe)
+}
+
+func (c *PeerEventExporter) subscribe(ctx context.Context, touch func()) error {
+	con, err := getGrpcClient(c.rpcAddr, c.tlsCertPath, c.macaroonPath)
+	if err != nil {
+		return err
+	}
+	defer con.Close()
+
+	stream, err := lnrpc.NewLightningClient(con).SubscribePeerEvents(ctx, &lnrpc.PeerEventSubscription{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		event, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		touch()
+		c.handleEvent(event)
+	}
+}
+
+func (c *PeerEventExporter) handleEvent(event *lnrpc.PeerEvent) {
+	c.Lock()
+	defer c.Unlock()
+
+	counts, ok := c.counts.get(event.PubKey)
+	if !ok {
+		counts = &peerEventCounts{}
+		c.counts.put(event.PubKey, counts)
+	}
+	switch event.Type {
+	case lnrpc.PeerEvent_PEER_ONLINE:
+		counts.online++
+	case lnrpc.PeerEvent_PEER_OFFLINE:
+		counts.offline++
+	}
+}
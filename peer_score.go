@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// peerScoreWeights weigh the components of the peer stability score, set
+// with -peers.score-weights.
+var peerScoreWeights = map[string]float64{
+	"flaps":         1,
+	"ping":          1,
+	"uptime":        1,
+	"htlc_failures": 1,
+}
+
+// peerScoreScales are the values of the components at which they count as
+// half bad, e.g. 10 flaps or a ping time of one second.
+var peerScoreScales = map[string]float64{
+	"flaps":         10,
+	"ping":          float64(time.Second / time.Microsecond),
+	"htlc_failures": 10,
+}
+
+// parsePeerScoreWeights parses weights in the form "flaps=1,ping=0.5,...",
+// components that are not given keep their weight.
+func parsePeerScoreWeights(s string) (map[string]float64, error) {
+	return parseWeights(s, peerScoreWeights)
+}
+
+// parseWeights parses weights in the form "a=1,b=0.5,..." for the
+// components of defaults, components that are not given keep their default
+// weight.
+func parseWeights(s string, defaults map[string]float64) (map[string]float64, error) {
+	weights := map[string]float64{}
+	var components []string
+	for component, w := range defaults {
+		weights[component] = w
+		components = append(components, component)
+	}
+	sort.Strings(components)
+
+	for _, spec := range strings.Split(s, ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		component, value, ok := strings.Cut(spec, "=")
+		if _, known := weights[component]; !ok || !known {
+			return nil, fmt.Errorf("invalid weight %q, expected <component>=<weight> with component one of %s", spec, strings.Join(components, ", "))
+		}
+		w, err := strconv.ParseFloat(value, 64)
+		if err != nil || w < 0 {
+			return nil, fmt.Errorf("invalid weight for %s: %q", component, value)
+		}
+		weights[component] = w
+	}
+	return weights, nil
+}
+
+// peerScore holds the inputs of a peer's stability score.
+type peerScore struct {
+	connected    bool
+	flaps        int32
+	pingMicros   int64
+	uptime       int64
+	lifetime     int64
+	htlcFailures int
+}
+
+// score combines the components into a score between 0 (unstable) and 1
+// (stable), as one minus the weighted mean of the component penalties:
+//
+//	flaps, ping, htlc_failures: x / (x + scale), 0.5 at the scale
+//	uptime: 1 - uptime / lifetime of the peer's channels
+//
+// A disconnected peer gets the full ping penalty.
+func (p *peerScore) score(weights map[string]float64) float64 {
+	penalties := map[string]float64{
+		"flaps":         saturate(float64(p.flaps), peerScoreScales["flaps"]),
+		"ping":          1,
+		"uptime":        0,
+		"htlc_failures": saturate(float64(p.htlcFailures), peerScoreScales["htlc_failures"]),
+	}
+	if p.connected {
+		penalties["ping"] = saturate(float64(p.pingMicros), peerScoreScales["ping"])
+	}
+	if p.lifetime > 0 {
+		penalties["uptime"] = 1 - float64(p.uptime)/float64(p.lifetime)
+	}
+
+	var sum, total float64
+	for component, w := range weights {
+		sum += w * penalties[component]
+		total += w
+	}
+	if total == 0 {
+		return 1
+	}
+	return 1 - sum/total
+}
+
+func saturate(x, scale float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	return x / (x + scale)
+}
+
+// collectStabilityScores exports the stability score of every channel
+// peer.
+func (c *peersCollector) collectStabilityScores(ch chan<- prometheus.Metric, peers []*lnrpc.Peer, channels []*lnrpc.Channel) {
+	for pubkey, p := range peerScores(peers, channels) {
+		ch <- prometheus.MustNewConstMetric(c.metrics["peer_stability_score"],
+			prometheus.GaugeValue, p.score(c.scoreWeights), pubkey, c.peerTags[pubkey])
+	}
+}
+
+// peerScores returns the score inputs of every channel peer by pubkey.
+func peerScores(peers []*lnrpc.Peer, channels []*lnrpc.Channel) map[string]*peerScore {
+	scores := map[string]*peerScore{}
+	for _, channel := range channels {
+		p, ok := scores[channel.RemotePubkey]
+		if !ok {
+			p = &peerScore{}
+			scores[channel.RemotePubkey] = p
+		}
+		p.uptime += channel.Uptime
+		p.lifetime += channel.Lifetime
+
+		p.htlcFailures += int(htlcFailures.count(channel.ChanId, time.Now()))
+	}
+
+	for _, peer := range peers {
+		if p, ok := scores[peer.PubKey]; ok {
+			p.connected = true
+			p.flaps = peer.FlapCount
+			p.pingMicros = peer.PingTime
+		}
+	}
+
+	return scores
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type collectRPCsKey struct{}
+
+// collectRPCs records the outcome of the RPCs a collector made: the ones
+// lnd refused with PermissionDenied, as the macaroon lacks a permission
+// they need, and the number of failed ones.
+type collectRPCs struct {
+	sync.Mutex
+	denied []string
+	failed int
+}
+
+// withCollectRPCs returns a context recording the RPCs made with it.
+func withCollectRPCs(ctx context.Context) (context.Context, *collectRPCs) {
+	rpcs := &collectRPCs{}
+	return context.WithValue(ctx, collectRPCsKey{}, rpcs), rpcs
+}
+
+// withoutCollectRPCs returns a context for optional RPCs whose failure
+// doesn't fail or disable the collector.
+func withoutCollectRPCs(ctx context.Context) context.Context {
+	return context.WithValue(ctx, collectRPCsKey{}, nil)
+}
+
+func (r *collectRPCs) deniedMethods() []string {
+	r.Lock()
+	defer r.Unlock()
+	return append([]string{}, r.denied...)
+}
+
+func (r *collectRPCs) failures() int {
+	r.Lock()
+	defer r.Unlock()
+	return r.failed
+}
+
+// permissionInterceptor records failed and denied RPCs in the collectRPCs
+// of the context, if any, and counts them in rpcErrors.
+func permissionInterceptor(ctx context.Context, method string, req, reply any, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	err := invoker(ctx, method, req, reply, cc, opts...)
+	if err == nil {
+		return nil
+	}
+	recordRPCError(cc.Target(), method, err)
+	if rpcs, ok := ctx.Value(collectRPCsKey{}).(*collectRPCs); ok {
+		rpcs.Lock()
+		rpcs.failed++
+		if status.Code(err) == codes.PermissionDenied {
+			rpcs.denied = append(rpcs.denied, method)
+		}
+		rpcs.Unlock()
+	}
+	return err
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// pollingCollector collects the wrapped collector in the background every
+// interval and serves the metrics of the last run on scrape, so that heavy
+// RPCs like ForwardingHistory or ListChannels on big nodes don't run on
+// every scrape. The metrics are as old as the exported timestamp.
+type pollingCollector struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	collector prometheus.Collector
+	interval  time.Duration
+
+	cached   []prometheus.Metric
+	polledAt time.Time
+	duration time.Duration
+}
+
+func newPollingCollector(namespace string, collector prometheus.Collector, interval time.Duration) *pollingCollector {
+	return &pollingCollector{
+		collector: collector,
+		interval:  interval,
+
+		metrics: map[string]*prometheus.Desc{
+			"poll_timestamp":        newGlobalMetric(namespace, "exporter_poll_timestamp_seconds", "Time the served metrics were collected at by the background polling of -scrape.poll-interval", []string{}),
+			"poll_duration_seconds": newGlobalMetric(namespace, "exporter_poll_duration_seconds", "Time the last background collection took", []string{}),
+		},
+	}
+}
+
+func (c *pollingCollector) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+	c.collector.Describe(ch)
+}
+
+func (c *pollingCollector) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.polledAt.IsZero() {
+		return
+	}
+	for _, m := range c.cached {
+		ch <- m
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["poll_timestamp"],
+		prometheus.GaugeValue, float64(c.polledAt.UnixNano())/1e9)
+	ch <- prometheus.MustNewConstMetric(c.metrics["poll_duration_seconds"],
+		prometheus.GaugeValue, c.duration.Seconds())
+}
+
+// Start collects every interval until ctx is canceled, starting right away.
+func (c *pollingCollector) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			c.poll()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+func (c *pollingCollector) poll() {
+	start := time.Now()
+	ch := make(chan prometheus.Metric)
+	done := make(chan []prometheus.Metric)
+	go func() {
+		var metrics []prometheus.Metric
+		for m := range ch {
+			metrics = append(metrics, m)
+		}
+		done <- metrics
+	}()
+	c.collector.Collect(ch)
+	close(ch)
+	metrics := <-done
+
+	c.Lock()
+	defer c.Unlock()
+	c.cached, c.polledAt, c.duration = metrics, time.Now(), time.Since(start)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+)
+
+// collectorPreset enables a curated set of collectors and sets the flags
+// that fit a common kind of node. Collectors not listed are disabled.
+type collectorPreset struct {
+	help       string
+	collectors []string
+	flags      map[string]string
+}
+
+var collectorPresets = map[string]collectorPreset{
+	"readonly-minimal": {
+		help:       "node, wallet, channel and peer state only, works with lnd's readonly.macaroon and keeps the number of series low",
+		collectors: []string{"info", "wallet", "channels", "peers"},
+		flags: map[string]string{
+			"channels.peer-share-top":  "0",
+			"forwarding.channel-pairs": "0",
+		},
+	},
+	"routing-node": {
+		help:       "forwarding, channel health and graph metrics, including HTLC and peer events and the mission control of the routerrpc subserver",
+		collectors: []string{"info", "wallet", "channels", "peers", "consistency", "forwarding", "closed_channels", "network", "mission_control", "rebalance"},
+		flags: map[string]string{
+			"htlc-events":              "true",
+			"peer-events":              "true",
+			"forwarding.lookback":      "720h",
+			"forwarding.channel-pairs": "20",
+			"channels.peer-share-top":  "20",
+		},
+	},
+	"merchant": {
+		help:       "invoices and payments, including invoice and payment events, with the channel state needed to receive",
+		collectors: []string{"info", "wallet", "channels", "peers", "closed_channels", "invoices", "payments"},
+		flags: map[string]string{
+			"invoice-events":           "true",
+			"payment-events":           "true",
+			"channels.peer-share-top":  "5",
+			"forwarding.channel-pairs": "0",
+		},
+	},
+	"full": {
+		help: "every collector and event subscription, needs the walletrpc, routerrpc and watchtowerrpc subservers",
+		flags: map[string]string{
+			"htlc-events":              "true",
+			"invoice-events":           "true",
+			"payment-events":           "true",
+			"peer-events":              "true",
+			"forwarding.lookback":      "8760h",
+			"forwarding.channel-pairs": "50",
+		},
+	},
+}
+
+// presetNames returns the names of the presets for the -preset help.
+func presetNames() string {
+	var names []string
+	for name, p := range collectorPresets {
+		names = append(names, fmt.Sprintf("%s (%s)", name, p.help))
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
+
+// applyPreset sets the flags of the named preset. Flags set explicitly, see
+// explicitlySetFlags, are kept like with -low-memory. It must be called
+// before the flag values are read.
+func applyPreset(name string, explicitFlags map[string]bool) error {
+	p, ok := collectorPresets[name]
+	if !ok {
+		return fmt.Errorf("unknown preset %q", name)
+	}
+
+	// A preset without collectors enables all of them.
+	enabled := map[string]bool{}
+	for _, c := range p.collectors {
+		enabled[c] = true
+	}
+	settings := map[string]string{}
+	for _, lc := range lndCollectors {
+		settings["collector."+lc.name] = fmt.Sprint(p.collectors == nil || enabled[lc.name])
+	}
+	for f, value := range p.flags {
+		settings[f] = value
+	}
+
+	for f, value := range settings {
+		if explicitFlags[f] {
+			continue
+		}
+		if err := flag.Set(f, value); err != nil {
+			return fmt.Errorf("preset %s: %s", name, err)
+		}
+	}
+
+	log.Printf("preset %s: %s", name, p.help)
+	return nil
+}
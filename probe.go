@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// probeHandler serves the metrics of a single node from the config file
+// per request, like the blackbox exporter, for Prometheus to pass the
+// target with ?target=<rpc_addr or name> and optionally a module selecting
+// the collectors with ?module=<name>.
+type probeHandler struct {
+	sync.Mutex
+
+	config *Config
+
+	namespace string
+	opts      LndExporterOpts
+
+	// exporters are kept per target and module to reuse connections and
+	// the state of counters across probes.
+	exporters map[string]*LndExporter
+
+	// owners holds the RPC addresses of probe only nodes whose forwards
+	// are archived and whose state is persisted by one of exporters.
+	owners map[string]bool
+}
+
+func newProbeHandler(config *Config, namespace string, opts LndExporterOpts) *probeHandler {
+	return &probeHandler{
+		config:    config,
+		namespace: namespace,
+		opts:      opts,
+		exporters: map[string]*LndExporter{},
+		owners:    map[string]bool{},
+	}
+}
+
+func (h *probeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	target := r.URL.Query().Get("target")
+	if target == "" {
+		http.Error(w, "target parameter is missing", http.StatusBadRequest)
+		return
+	}
+	node, ok := h.config.node(target)
+	if !ok {
+		http.Error(w, fmt.Sprintf("unknown target %q", target), http.StatusBadRequest)
+		return
+	}
+
+	moduleName := r.URL.Query().Get("module")
+	opts := h.opts
+	if moduleName != "" {
+		module, ok := h.config.Modules[moduleName]
+		if !ok {
+			http.Error(w, fmt.Sprintf("unknown module %q", moduleName), http.StatusBadRequest)
+			return
+		}
+		opts.Collectors = map[string]bool{}
+		for _, name := range module.Collectors {
+			opts.Collectors[name] = true
+		}
+	}
+
+	exporter := h.exporter(node, moduleName, opts)
+	if err := fieldMetricCollisions(exporter, map[string]bool{}); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(exporter)
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, r)
+}
+
+func (h *probeHandler) exporter(node NodeConfig, moduleName string, opts LndExporterOpts) *LndExporter {
+	h.Lock()
+	defer h.Unlock()
+
+	key := node.Name + "/" + moduleName
+	exporter, ok := h.exporters[key]
+	if !ok {
+		// A node has one owner of its forwarding archive and state: its
+		// exporter on /metrics, or the first probe of a probe only node.
+		// Others keep their state in memory.
+		if !node.ProbeOnly || h.owners[node.RpcAddr] {
+			opts.ForwardingArchive = nil
+			opts.State = nil
+		}
+		if node.ProbeOnly {
+			h.owners[node.RpcAddr] = true
+		}
+
+		exporter = NewLightningExporter(
+			h.namespace,
+			node.RpcAddr,
+			node.TLSCertPath, node.MacaroonPath,
+			"",
+			opts,
+		)
+		h.exporters[key] = exporter
+	}
+	return exporter
+}
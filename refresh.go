@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// refreshJob is an expensive background task that can be started out of
+// band with the /api/v1/refresh endpoint.
+type refreshJob struct {
+	sync.Mutex
+	run func(ctx context.Context) error
+
+	running    bool
+	startedAt  time.Time
+	finishedAt time.Time
+	lastError  string
+	runs       uint64
+}
+
+type refreshStatus struct {
+	Collector  string     `json:"collector"`
+	Running    bool       `json:"running"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	LastError  string     `json:"last_error,omitempty"`
+	Runs       uint64     `json:"runs"`
+}
+
+// refreshJobs holds the jobs registered with registerRefresh by name.
+var refreshJobs = struct {
+	sync.Mutex
+	m map[string]*refreshJob
+}{m: map[string]*refreshJob{}}
+
+func registerRefresh(name string, run func(ctx context.Context) error) {
+	refreshJobs.Lock()
+	defer refreshJobs.Unlock()
+	refreshJobs.m[name] = &refreshJob{run: run}
+}
+
+// start runs the job in the background unless it is already running.
+func (j *refreshJob) start(name string) {
+	j.Lock()
+	defer j.Unlock()
+	if j.running {
+		return
+	}
+	j.running = true
+	j.startedAt = time.Now()
+
+	go func() {
+		err := j.run(context.Background())
+		if err != nil {
+			log.Printf("refresh %s err: %s", name, err)
+		}
+
+		j.Lock()
+		defer j.Unlock()
+		j.running = false
+		j.finishedAt = time.Now()
+		j.runs++
+		j.lastError = ""
+		if err != nil {
+			j.lastError = err.Error()
+		}
+	}()
+}
+
+func (j *refreshJob) status(name string) refreshStatus {
+	j.Lock()
+	defer j.Unlock()
+
+	s := refreshStatus{Collector: name, Running: j.running, LastError: j.lastError, Runs: j.runs}
+	if !j.startedAt.IsZero() {
+		startedAt := j.startedAt
+		s.StartedAt = &startedAt
+	}
+	if !j.finishedAt.IsZero() {
+		finishedAt := j.finishedAt
+		s.FinishedAt = &finishedAt
+	}
+	return s
+}
+
+// refreshHandler serves /api/v1/refresh. A POST with ?collector=<name>
+// starts the job, a GET reports the status of one or all jobs. Requests
+// must carry the token as "Authorization: Bearer <token>".
+type refreshHandler struct {
+	token string
+}
+
+func newRefreshHandler(token string) *refreshHandler {
+	return &refreshHandler{token: token}
+}
+
+func (h *refreshHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := []byte(r.Header.Get("Authorization"))
+	if subtle.ConstantTimeCompare(auth, []byte("Bearer "+h.token)) != 1 {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	name := r.URL.Query().Get("collector")
+	refreshJobs.Lock()
+	job, ok := refreshJobs.m[name]
+	var names []string
+	for n := range refreshJobs.m {
+		names = append(names, n)
+	}
+	refreshJobs.Unlock()
+	sort.Strings(names)
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		var statuses []refreshStatus
+		for _, n := range names {
+			refreshJobs.Lock()
+			j := refreshJobs.m[n]
+			refreshJobs.Unlock()
+			statuses = append(statuses, j.status(n))
+		}
+		writeRefreshJSON(w, http.StatusOK, statuses)
+
+	case !ok:
+		http.Error(w, fmt.Sprintf("unknown collector %q, expected one of %v", name, names), http.StatusNotFound)
+
+	case r.Method == http.MethodGet:
+		writeRefreshJSON(w, http.StatusOK, job.status(name))
+
+	case r.Method == http.MethodPost:
+		job.start(name)
+		writeRefreshJSON(w, http.StatusAccepted, job.status(name))
+
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func writeRefreshJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("writing refresh response err: %s", err)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lightningnetwork/lnd/lnrpc"
+)
+
+// accountingReport is the income and expenses of the node over a period.
+// Routing fees are read from the forwarding archive if there is one, which
+// keeps forwards lnd may have deleted, otherwise from lnd like the rest.
+type accountingReport struct {
+	Period string    `json:"period"`
+	Start  time.Time `json:"start"`
+	End    time.Time `json:"end"`
+
+	Forwards              uint64 `json:"forwards"`
+	ForwardedMsat         uint64 `json:"forwarded_msat"`
+	RoutingFeesEarnedMsat uint64 `json:"routing_fees_earned_msat"`
+	ForwardsSource        string `json:"forwards_source"`
+
+	Payments            uint64 `json:"payments"`
+	PaymentFeesPaidMsat uint64 `json:"payment_fees_paid_msat"`
+
+	// OnchainFeesPaidSat is keyed by the transaction type from lnd's
+	// label, like onchain_fees_paid_sats_total.
+	OnchainFeesPaidSat map[string]int64 `json:"onchain_fees_paid_sat"`
+
+	NetIncomeMsat int64 `json:"net_income_msat"`
+}
+
+// parsePeriod parses a year (2024), quarter (2024-Q1) or month (2024-03)
+// into its UTC start and end.
+func parsePeriod(period string) (time.Time, time.Time, error) {
+	invalid := fmt.Errorf("invalid period %q, expected a year (2024), quarter (2024-Q1) or month (2024-03)", period)
+
+	year, rest, _ := strings.Cut(period, "-")
+	y, err := strconv.Atoi(year)
+	if err != nil || len(year) != 4 {
+		return time.Time{}, time.Time{}, invalid
+	}
+
+	switch {
+	case rest == "":
+		start := time.Date(y, time.January, 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(1, 0, 0), nil
+	case strings.HasPrefix(rest, "Q"):
+		q, err := strconv.Atoi(rest[1:])
+		if err != nil || q < 1 || q > 4 {
+			return time.Time{}, time.Time{}, invalid
+		}
+		start := time.Date(y, time.Month(3*(q-1)+1), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 3, 0), nil
+	default:
+		m, err := strconv.Atoi(rest)
+		if err != nil || m < 1 || m > 12 {
+			return time.Time{}, time.Time{}, invalid
+		}
+		start := time.Date(y, time.Month(m), 1, 0, 0, 0, 0, time.UTC)
+		return start, start.AddDate(0, 1, 0), nil
+	}
+}
+
+// reportHandler serves the accounting report of the node given by flags at
+// /api/v1/report?period=<period>, as JSON or with ?format=csv as CSV.
+type reportHandler struct {
+	exporter *LndExporter
+	archive  *forwardingArchive
+}
+
+func newReportHandler(exporter *LndExporter, archive *forwardingArchive) *reportHandler {
+	return &reportHandler{exporter: exporter, archive: archive}
+}
+
+func (h *reportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	period := r.URL.Query().Get("period")
+	start, end, err := parsePeriod(period)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := h.report(r.Context(), period, start, end)
+	if err != nil {
+		log.Printf("accounting report err: %s", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		writeReportCSV(w, report)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func (h *reportHandler) report(ctx context.Context, period string, start time.Time, end time.Time) (*accountingReport, error) {
+	client, err := h.exporter.lightningClient()
+	if err != nil {
+		return nil, err
+	}
+
+	report := &accountingReport{
+		Period:             period,
+		Start:              start,
+		End:                end,
+		OnchainFeesPaidSat: map[string]int64{},
+	}
+
+	var forwards forwardingTotals
+	if h.archive != nil {
+		report.ForwardsSource = "archive"
+		forwards, err = h.archive.sum(h.exporter.rpcAddr, start, end)
+	} else {
+		report.ForwardsSource = "lnd"
+		forwards, err = sumForwardingHistory(ctx, client, start, end)
+	}
+	if err != nil {
+		return nil, err
+	}
+	report.Forwards = forwards.forwards
+	report.ForwardedMsat = forwards.forwardedMsat
+	report.RoutingFeesEarnedMsat = forwards.feesMsat
+
+	var offset uint64
+	for {
+		resp, err := client.ListPayments(ctx, &lnrpc.ListPaymentsRequest{
+			IndexOffset: offset,
+			MaxPayments: paymentPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, payment := range resp.Payments {
+			created := time.Unix(0, payment.CreationTimeNs)
+			if payment.Status != lnrpc.Payment_SUCCEEDED || created.Before(start) || !created.Before(end) {
+				continue
+			}
+			report.Payments++
+			report.PaymentFeesPaidMsat += uint64(payment.FeeMsat)
+		}
+		if uint64(len(resp.Payments)) < paymentPageSize {
+			break
+		}
+		offset = resp.LastIndexOffset
+	}
+
+	txs, err := client.GetTransactions(ctx, &lnrpc.GetTransactionsRequest{})
+	if err != nil {
+		return nil, err
+	}
+	var onchainSat int64
+	for _, tx := range txs.Transactions {
+		ts := time.Unix(tx.TimeStamp, 0)
+		if tx.BlockHeight <= 0 || tx.TotalFees == 0 || ts.Before(start) || !ts.Before(end) {
+			continue
+		}
+		report.OnchainFeesPaidSat[txType(tx.Label)] += tx.TotalFees
+		onchainSat += tx.TotalFees
+	}
+
+	report.NetIncomeMsat = int64(report.RoutingFeesEarnedMsat) - int64(report.PaymentFeesPaidMsat) - onchainSat*1000
+	return report, nil
+}
+
+// sumForwardingHistory adds up the forwards lnd reports within
+// [start, end).
+func sumForwardingHistory(ctx context.Context, client lnrpc.LightningClient, start time.Time, end time.Time) (forwardingTotals, error) {
+	var totals forwardingTotals
+	var offset uint32
+	for {
+		resp, err := client.ForwardingHistory(ctx, &lnrpc.ForwardingHistoryRequest{
+			StartTime:    uint64(start.Unix()),
+			EndTime:      uint64(end.Unix()),
+			IndexOffset:  offset,
+			NumMaxEvents: forwardingPageSize,
+		})
+		if err != nil {
+			return totals, err
+		}
+		for _, f := range resp.ForwardingEvents {
+			totals.forwards++
+			totals.forwardedMsat += f.AmtOutMsat
+			totals.feesMsat += f.FeeMsat
+		}
+		if uint32(len(resp.ForwardingEvents)) < forwardingPageSize {
+			return totals, nil
+		}
+		offset = resp.LastOffsetIndex
+	}
+}
+
+// writeReportCSV writes the report as rows of category, type and amount in
+// msat, for import into bookkeeping tools.
+func writeReportCSV(w http.ResponseWriter, report *accountingReport) {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"period", "category", "type", "amount_msat"})
+	row := func(category string, typ string, msat int64) {
+		cw.Write([]string{report.Period, category, typ, strconv.FormatInt(msat, 10)})
+	}
+
+	row("income", "routing_fees", int64(report.RoutingFeesEarnedMsat))
+	row("expense", "payment_fees", int64(report.PaymentFeesPaidMsat))
+	var types []string
+	for txType := range report.OnchainFeesPaidSat {
+		types = append(types, txType)
+	}
+	sort.Strings(types)
+	for _, txType := range types {
+		row("expense", "onchain_fees_"+txType, report.OnchainFeesPaidSat[txType]*1000)
+	}
+	row("net", "net_income", report.NetIncomeMsat)
+	cw.Flush()
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+type rpcErrorKey struct {
+	rpcAddr string
+	method  string
+	code    string
+}
+
+// rpcErrors counts the failed RPCs to lnd of all connections made with
+// getGrpcClient, by gRPC status code.
+var rpcErrors = struct {
+	sync.Mutex
+	m map[rpcErrorKey]uint64
+}{m: map[rpcErrorKey]uint64{}}
+
+func recordRPCError(rpcAddr string, method string, err error) {
+	rpcErrors.Lock()
+	defer rpcErrors.Unlock()
+	rpcErrors.m[rpcErrorKey{rpcAddr: rpcAddr, method: method, code: status.Code(err).String()}]++
+}
+
+// rpcErrorStream records the errors of a stream other than its end and
+// the cancellation of its context.
+type rpcErrorStream struct {
+	grpc.ClientStream
+	ctx     context.Context
+	rpcAddr string
+	method  string
+}
+
+func (s *rpcErrorStream) RecvMsg(m any) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil && err != io.EOF && s.ctx.Err() == nil {
+		recordRPCError(s.rpcAddr, s.method, err)
+	}
+	return err
+}
+
+// streamErrorInterceptor records the errors of event subscriptions.
+func streamErrorInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		recordRPCError(cc.Target(), method, err)
+		return nil, err
+	}
+	return &rpcErrorStream{ClientStream: stream, ctx: ctx, rpcAddr: cc.Target(), method: method}, nil
+}
+
+// RPCErrorExporter exports the failed RPCs to lnd.
+type RPCErrorExporter struct {
+	metrics map[string]*prometheus.Desc
+}
+
+func NewRPCErrorExporter(namespace string) *RPCErrorExporter {
+	return &RPCErrorExporter{
+		metrics: map[string]*prometheus.Desc{
+			"rpc_errors_total": newGlobalCounter(namespace, "exporter_rpc_errors_total", "Number of failed RPCs to lnd by method and gRPC status code", []string{"rpc_addr", "method", "code"}),
+		},
+	}
+}
+
+func (c *RPCErrorExporter) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *RPCErrorExporter) Collect(ch chan<- prometheus.Metric) {
+	rpcErrors.Lock()
+	defer rpcErrors.Unlock()
+
+	for key, n := range rpcErrors.m {
+		ch <- prometheus.MustNewConstMetric(c.metrics["rpc_errors_total"],
+			prometheus.CounterValue, float64(n), key.rpcAddr, key.method, key.code)
+	}
+}
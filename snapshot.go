@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+const stateSnapshotVersion = 1
+
+// stateSnapshot is the portable form of the state store written by
+// -state.export and read by -state.import, to move the exporter to another
+// host without resetting checkpoints and cached data.
+type stateSnapshot struct {
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+
+	// RpcAddr is the -rpc.addr of the exporting host. State keys of the
+	// node are suffixed with it and renamed on import if the address
+	// changed.
+	RpcAddr string                     `json:"rpc_addr"`
+	State   map[string]json.RawMessage `json:"state"`
+}
+
+// exportSnapshot writes all state to path.
+func (s *stateStore) exportSnapshot(path string, rpcAddr string) error {
+	s.Lock()
+	snapshot := stateSnapshot{
+		Version:   stateSnapshotVersion,
+		CreatedAt: time.Now().UTC(),
+		RpcAddr:   rpcAddr,
+		State:     s.state,
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	s.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// importSnapshot merges the state of the snapshot at path into the store,
+// replacing keys that exist in both, and returns the number of keys
+// imported.
+func (s *stateStore) importSnapshot(path string, rpcAddr string) (int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	var snapshot stateSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return 0, fmt.Errorf("invalid snapshot %s: %w", path, err)
+	}
+	if snapshot.Version != stateSnapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+
+	s.Lock()
+	defer s.Unlock()
+	for key, value := range snapshot.State {
+		if snapshot.RpcAddr != "" && snapshot.RpcAddr != rpcAddr {
+			if prefix, ok := strings.CutSuffix(key, "/"+snapshot.RpcAddr); ok {
+				key = prefix + "/" + rpcAddr
+			}
+		}
+		s.state[key] = value
+	}
+	return len(snapshot.State), s.save()
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// stateStore persists small pieces of exporter state, e.g. stream
+// checkpoints, as a JSON object in a file so that they survive restarts.
+// Without a path the state is only kept in memory.
+type stateStore struct {
+	sync.Mutex
+
+	path  string
+	state map[string]json.RawMessage
+}
+
+func newStateStore(path string) (*stateStore, error) {
+	s := &stateStore{
+		path:  path,
+		state: map[string]json.RawMessage{},
+	}
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &s.state); err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// get decodes the state stored under key into v and reports whether there
+// was any.
+func (s *stateStore) get(key string, v interface{}) (bool, error) {
+	s.Lock()
+	defer s.Unlock()
+
+	data, ok := s.state[key]
+	if !ok {
+		return false, nil
+	}
+	return true, json.Unmarshal(data, v)
+}
+
+// put stores v under key and writes the state file.
+func (s *stateStore) put(key string, v interface{}) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	s.Lock()
+	defer s.Unlock()
+
+	s.state[key] = data
+	return s.save()
+}
+
+// save writes the state file, callers hold the lock.
+func (s *stateStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	file, err := json.MarshalIndent(s.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash can't leave a truncated
+	// state file behind.
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(file); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	// subscriptionRetryDelay is the delay before re-subscribing after an
+	// event stream failed.
+	subscriptionRetryDelay = 10 * time.Second
+
+	// subscriptionIdleTimeout is how long a stream may go without a message
+	// before the watchdog tears it down and re-subscribes. Zero disables the
+	// watchdog.
+	subscriptionIdleTimeout = time.Hour
+)
+
+type subscriptionState struct {
+	connected      bool
+	lastMessage    time.Time
+	reconnects     uint64
+	watchdogResets uint64
+	backfilled     uint64
+}
+
+// subscriptions holds the state of all event streams started with
+// runSubscription, keyed by name.
+var subscriptions = struct {
+	sync.Mutex
+	m map[string]*subscriptionState
+}{m: map[string]*subscriptionState{}}
+
+func updateSubscription(name string, update func(s *subscriptionState)) {
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+
+	s, ok := subscriptions.m[name]
+	if !ok {
+		s = &subscriptionState{}
+		subscriptions.m[name] = s
+	}
+	update(s)
+}
+
+// runSubscription calls subscribe in a background goroutine until ctx is
+// canceled, calling it again whenever it returns. subscribe must call touch
+// for every message it receives, a stream that stays silent for longer than
+// subscriptionIdleTimeout is canceled by a watchdog.
+func runSubscription(ctx context.Context, name string, subscribe func(ctx context.Context, touch func()) error) {
+	go func() {
+		for attempt := 0; ; attempt++ {
+			if attempt > 0 {
+				updateSubscription(name, func(s *subscriptionState) { s.reconnects++ })
+			}
+
+			if err := watchSubscription(ctx, name, subscribe); err != nil {
+				log.Printf("%s subscription err: %s", name, err)
+			}
+			updateSubscription(name, func(s *subscriptionState) { s.connected = false })
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(subscriptionRetryDelay):
+			}
+		}
+	}()
+}
+
+func watchSubscription(ctx context.Context, name string, subscribe func(ctx context.Context, touch func()) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		mu           sync.Mutex
+		lastActivity = time.Now()
+	)
+	touch := func() {
+		now := time.Now()
+		mu.Lock()
+		lastActivity = now
+		mu.Unlock()
+		updateSubscription(name, func(s *subscriptionState) {
+			s.connected = true
+			s.lastMessage = now
+		})
+	}
+
+	if subscriptionIdleTimeout > 0 {
+		go func() {
+			ticker := time.NewTicker(subscriptionIdleTimeout / 4)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+
+				mu.Lock()
+				idle := time.Since(lastActivity)
+				mu.Unlock()
+
+				if idle > subscriptionIdleTimeout {
+					log.Printf("%s subscription idle for %s, re-subscribing", name, idle)
+					updateSubscription(name, func(s *subscriptionState) { s.watchdogResets++ })
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+
+	return subscribe(ctx, touch)
+}
+
+// SubscriptionExporter exports the health of all event streams.
+type SubscriptionExporter struct {
+	metrics map[string]*prometheus.Desc
+}
+
+func NewSubscriptionExporter(namespace string) *SubscriptionExporter {
+	return &SubscriptionExporter{
+		metrics: map[string]*prometheus.Desc{
+			"subscription_up":               newGlobalMetric(namespace, "subscription_up", "Whether the event stream received a message since it was last (re)started", []string{"subscription"}),
+			"subscription_last_message":     newGlobalMetric(namespace, "subscription_last_message_timestamp_seconds", "Unix time of the last message received on the event stream", []string{"subscription"}),
+			"subscription_reconnects_total": newGlobalCounter(namespace, "subscription_reconnects_total", "Number of times the event stream was re-subscribed", []string{"subscription"}),
+			"subscription_watchdog_resets":  newGlobalCounter(namespace, "subscription_watchdog_resets_total", "Number of times the watchdog tore down an idle event stream", []string{"subscription"}),
+			"subscription_backfilled":       newGlobalCounter(namespace, "subscription_backfilled_events_total", "Number of events missed while the event stream was down and recovered from polling RPCs", []string{"subscription"}),
+		},
+	}
+}
+
+func (c *SubscriptionExporter) Describe(ch chan<- *prometheus.Desc) {
+	for _, m := range c.metrics {
+		ch <- m
+	}
+}
+
+func (c *SubscriptionExporter) Collect(ch chan<- prometheus.Metric) {
+	subscriptions.Lock()
+	defer subscriptions.Unlock()
+
+	for name, s := range subscriptions.m {
+		ch <- prometheus.MustNewConstMetric(c.metrics["subscription_up"],
+			prometheus.GaugeValue, boolToFloat(s.connected), name)
+		if !s.lastMessage.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.metrics["subscription_last_message"],
+				prometheus.GaugeValue, float64(s.lastMessage.Unix()), name)
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["subscription_reconnects_total"],
+			prometheus.CounterValue, float64(s.reconnects), name)
+		ch <- prometheus.MustNewConstMetric(c.metrics["subscription_watchdog_resets"],
+			prometheus.CounterValue, float64(s.watchdogResets), name)
+		ch <- prometheus.MustNewConstMetric(c.metrics["subscription_backfilled"],
+			prometheus.CounterValue, float64(s.backfilled), name)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// UpdateCheckExporter polls the release feed of the exporter in the
+// background and exports whether a newer version than the running one is
+// available. Nothing is fetched unless it is enabled with -update-check.url.
+type UpdateCheckExporter struct {
+	sync.Mutex
+	metrics map[string]*prometheus.Desc
+
+	url        string
+	interval   time.Duration
+	httpClient *http.Client
+
+	latest    string
+	checkedAt time.Time
+	failures  uint64
+}
+
+func NewUpdateCheckExporter(namespace string, url string, interval time.Duration, timeout time.Duration) *UpdateCheckExporter {
+	return &UpdateCheckExporter{
+		url:        url,
+		interval:   interval,
+		httpClient: &http.Client{Timeout: timeout},
+
+		metrics: map[string]*prometheus.Desc{
+			"update_available":       newGlobalMetric(namespace, "exporter_update_available", "Whether a newer release of the exporter than the running version is available", []string{"version", "latest_version"}),
+			"update_check_timestamp": newGlobalMetric(namespace, "exporter_update_check_timestamp_seconds", "Time of the last successful check of the release feed", []string{}),
+			"update_check_failures":  newGlobalCounter(namespace, "exporter_update_check_failures_total", "Number of failed checks of the release feed", []string{}),
+		},
+	}
+}
+
+func (c *UpdateCheckExporter) Describe(ch chan<- *prometheus.Desc) {
+	describeMetrics(c.metrics, ch)
+}
+
+func (c *UpdateCheckExporter) Collect(ch chan<- prometheus.Metric) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.latest != "" {
+		ch <- prometheus.MustNewConstMetric(c.metrics["update_available"],
+			prometheus.GaugeValue, boolToFloat(newerVersion(c.latest, version)), version, c.latest)
+		ch <- prometheus.MustNewConstMetric(c.metrics["update_check_timestamp"],
+			prometheus.GaugeValue, float64(c.checkedAt.Unix()))
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["update_check_failures"],
+		prometheus.CounterValue, float64(c.failures))
+}
+
+// Start checks the release feed every interval until ctx is canceled.
+func (c *UpdateCheckExporter) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+
+		for {
+			latest, err := c.fetch(ctx)
+			c.Lock()
+			if err != nil {
+				log.Printf("update check %s err: %s", c.url, err)
+				c.failures++
+			} else {
+				c.latest, c.checkedAt = latest, time.Now()
+			}
+			c.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// fetch returns the tag of the latest release from a GitHub style release
+// feed.
+func (c *UpdateCheckExporter) fetch(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	var release struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.Unmarshal(data, &release); err != nil {
+		return "", err
+	}
+	if release.TagName == "" {
+		return "", fmt.Errorf("no tag_name in release feed response")
+	}
+	return release.TagName, nil
+}
+
+// newerVersion reports whether latest is a higher version than current,
+// compared as dot separated numbers with an optional v prefix. Versions
+// that don't parse, like those of development builds, are never outdated.
+func newerVersion(latest string, current string) bool {
+	l, ok := parseVersion(latest)
+	if !ok {
+		return false
+	}
+	c, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+
+	for i := 0; i < len(l) || i < len(c); i++ {
+		var lp, cp int
+		if i < len(l) {
+			lp = l[i]
+		}
+		if i < len(c) {
+			cp = c[i]
+		}
+		if lp != cp {
+			return lp > cp
+		}
+	}
+	return false
+}
+
+// parseVersion parses the numbers of a version like v0.3.1, ignoring a
+// pre-release or build suffix like -beta.
+func parseVersion(v string) ([]int, bool) {
+	v = strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(v, "-+"); i >= 0 {
+		v = v[:i]
+	}
+	if v == "" {
+		return nil, false
+	}
+
+	var parts []int
+	for _, s := range strings.Split(v, ".") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		parts = append(parts, n)
+	}
+	return parts, true
+}
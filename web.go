@@ -0,0 +1,99 @@
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// webConfig is the TLS and authentication setup of the exporter's own
+// endpoints.
+type webConfig struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	// basicAuthUser is empty if basic auth is disabled, the password is
+	// kept as its hash to compare in constant time.
+	basicAuthUser     string
+	basicAuthPassword [sha256.Size]byte
+}
+
+func newWebConfig(certFile string, keyFile string, clientCAFile string, basicAuthUser string, basicAuthPasswordFile string) (*webConfig, error) {
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("-web.tls-cert-file and -web.tls-key-file must be set together")
+	}
+	if clientCAFile != "" && certFile == "" {
+		return nil, fmt.Errorf("-web.tls-client-ca-file needs -web.tls-cert-file")
+	}
+	if (basicAuthUser == "") != (basicAuthPasswordFile == "") {
+		return nil, fmt.Errorf("-web.basic-auth-user and -web.basic-auth-password-file must be set together")
+	}
+
+	c := &webConfig{
+		certFile:      certFile,
+		keyFile:       keyFile,
+		clientCAFile:  clientCAFile,
+		basicAuthUser: basicAuthUser,
+	}
+	if basicAuthPasswordFile != "" {
+		password, err := os.ReadFile(basicAuthPasswordFile)
+		if err != nil {
+			return nil, err
+		}
+		c.basicAuthPassword = sha256.Sum256([]byte(strings.TrimSpace(string(password))))
+	}
+	return c, nil
+}
+
+// withBasicAuth requires the basic auth credentials for the handler if they
+// are configured. Endpoints with their own token, like refresh, are not
+// wrapped as both use the Authorization header.
+func (c *webConfig) withBasicAuth(h http.Handler) http.Handler {
+	if c.basicAuthUser == "" {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, ok := r.BasicAuth()
+		userHash, wantUserHash := sha256.Sum256([]byte(user)), sha256.Sum256([]byte(c.basicAuthUser))
+		passwordHash := sha256.Sum256([]byte(password))
+		if !ok ||
+			subtle.ConstantTimeCompare(userHash[:], wantUserHash[:]) != 1 ||
+			subtle.ConstantTimeCompare(passwordHash[:], c.basicAuthPassword[:]) != 1 {
+			w.Header().Set("WWW-Authenticate", `Basic realm="lnd exporter"`)
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// listenAndServe serves the default mux on addr, over TLS if a certificate
+// and key are given, requiring client certificates signed by the client CA
+// if one is given.
+func (c *webConfig) listenAndServe(addr string) error {
+	server := &http.Server{Addr: addr}
+	if c.certFile == "" {
+		return server.ListenAndServe()
+	}
+
+	server.TLSConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.clientCAFile != "" {
+		ca, err := os.ReadFile(c.clientCAFile)
+		if err != nil {
+			return err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return fmt.Errorf("no certificates in %s", c.clientCAFile)
+		}
+		server.TLSConfig.ClientCAs = pool
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return server.ListenAndServeTLS(c.certFile, c.keyFile)
+}